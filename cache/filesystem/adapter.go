@@ -0,0 +1,51 @@
+package filesystem
+
+import (
+	"errors"
+	"net/url"
+	"path"
+	"path/filepath"
+	"time"
+
+	"gitlab.com/gitlab-org/gitlab-ci-multi-runner/common"
+)
+
+// filesystemAdapter stores the cache in a directory shared by the runners, eg. a NFS mount,
+// the helper streams the archive directly from and to the file:// address
+type filesystemAdapter struct {
+	objectPath string
+}
+
+func (a *filesystemAdapter) getURL() *url.URL {
+	return &url.URL{
+		Scheme: "file",
+		Path:   a.objectPath,
+	}
+}
+
+func (a *filesystemAdapter) GetDownloadURL() *url.URL {
+	return a.getURL()
+}
+
+func (a *filesystemAdapter) GetUploadURL() *url.URL {
+	return a.getURL()
+}
+
+func (a *filesystemAdapter) IsStreamingSupported() bool {
+	return true
+}
+
+func New(config *common.CacheConfig, timeout time.Duration, objectName string) (common.CacheAdapter, error) {
+	if config.Path == "" {
+		return nil, errors.New("missing Path for the filesystem cache")
+	}
+
+	adapter := &filesystemAdapter{
+		objectPath: path.Join(filepath.ToSlash(config.Path), objectName),
+	}
+	return adapter, nil
+}
+
+func init() {
+	common.RegisterCacheAdapter("filesystem", New)
+}
@@ -0,0 +1,29 @@
+package filesystem
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-ci-multi-runner/common"
+)
+
+func TestFilesystemAdapterURLs(t *testing.T) {
+	config := &common.CacheConfig{
+		Type: "filesystem",
+		Path: "/mnt/cache",
+	}
+
+	adapter, err := common.CreateCacheAdapter(config, time.Hour, "runner/abc/project/10/key")
+	require.NoError(t, err)
+
+	assert.Equal(t, "file:///mnt/cache/runner/abc/project/10/key", adapter.GetDownloadURL().String())
+	assert.Equal(t, "file:///mnt/cache/runner/abc/project/10/key", adapter.GetUploadURL().String())
+}
+
+func TestFilesystemAdapterRequiresPath(t *testing.T) {
+	_, err := New(&common.CacheConfig{Type: "filesystem"}, time.Hour, "key")
+	assert.Error(t, err)
+}
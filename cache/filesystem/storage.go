@@ -0,0 +1,65 @@
+package filesystem
+
+import (
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"gitlab.com/gitlab-org/gitlab-ci-multi-runner/common"
+)
+
+type filesystemStorage struct {
+	dir string
+}
+
+func (s *filesystemStorage) ListObjects(prefix string) (objects []common.CacheObject, err error) {
+	err = filepath.Walk(s.dir, func(filePath string, fi os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		} else if err != nil {
+			return err
+		} else if !fi.Mode().IsRegular() {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(s.dir, filePath)
+		if err != nil {
+			return err
+		}
+
+		objectName := filepath.ToSlash(relPath)
+		if !strings.HasPrefix(objectName, prefix) {
+			return nil
+		}
+
+		objects = append(objects, common.CacheObject{
+			Name:         objectName,
+			Size:         fi.Size(),
+			LastModified: fi.ModTime(),
+		})
+		return nil
+	})
+	return
+}
+
+func (s *filesystemStorage) RemoveObject(objectName string) error {
+	cleaned := path.Clean("/" + objectName)
+	if cleaned != "/"+objectName {
+		return errors.New("invalid object name: " + objectName)
+	}
+	return os.Remove(filepath.Join(s.dir, filepath.FromSlash(cleaned)))
+}
+
+func NewStorage(config *common.CacheConfig) (common.CacheStorage, error) {
+	if config.Path == "" {
+		return nil, errors.New("missing Path for the filesystem cache")
+	}
+
+	return &filesystemStorage{dir: config.Path}, nil
+}
+
+func init() {
+	common.RegisterCacheStorage("filesystem", NewStorage)
+}
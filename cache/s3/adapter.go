@@ -0,0 +1,79 @@
+package s3
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/minio/minio-go"
+
+	"gitlab.com/gitlab-org/gitlab-ci-multi-runner/common"
+)
+
+type bucketLocationTripper struct {
+	bucketLocation string
+}
+
+func (b *bucketLocationTripper) RoundTrip(req *http.Request) (res *http.Response, err error) {
+	var buffer bytes.Buffer
+	xml.NewEncoder(&buffer).Encode(b.bucketLocation)
+	res = &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       ioutil.NopCloser(&buffer),
+	}
+	return
+}
+
+func (b *bucketLocationTripper) CancelRequest(req *http.Request) {
+	// Do nothing
+}
+
+type s3Adapter struct {
+	config     *common.CacheConfig
+	client     *minio.Client
+	timeout    time.Duration
+	objectName string
+}
+
+func (a *s3Adapter) GetDownloadURL() *url.URL {
+	url, err := a.client.PresignedGetObject(a.config.BucketName, a.objectName, a.timeout, nil)
+	if err != nil {
+		logrus.Warningln(err)
+		return nil
+	}
+	return url
+}
+
+func (a *s3Adapter) GetUploadURL() *url.URL {
+	url, err := a.client.PresignedPutObject(a.config.BucketName, a.objectName, a.timeout)
+	if err != nil {
+		logrus.Warningln(err)
+		return nil
+	}
+	return url
+}
+
+func New(config *common.CacheConfig, timeout time.Duration, objectName string) (common.CacheAdapter, error) {
+	client, err := minio.New(config.ServerAddress, config.AccessKey, config.SecretKey, config.Insecure)
+	if err != nil {
+		return nil, err
+	}
+
+	client.SetCustomTransport(&bucketLocationTripper{config.BucketLocation})
+
+	adapter := &s3Adapter{
+		config:     config,
+		client:     client,
+		timeout:    timeout,
+		objectName: objectName,
+	}
+	return adapter, nil
+}
+
+func init() {
+	common.RegisterCacheAdapter("s3", New)
+}
@@ -0,0 +1,53 @@
+package s3
+
+import (
+	"github.com/minio/minio-go"
+
+	"gitlab.com/gitlab-org/gitlab-ci-multi-runner/common"
+)
+
+type s3Storage struct {
+	config *common.CacheConfig
+	client *minio.Client
+}
+
+func (s *s3Storage) ListObjects(prefix string) (objects []common.CacheObject, err error) {
+	doneCh := make(chan struct{})
+	defer close(doneCh)
+
+	for info := range s.client.ListObjects(s.config.BucketName, prefix, true, doneCh) {
+		if info.Err != nil {
+			return nil, info.Err
+		}
+
+		objects = append(objects, common.CacheObject{
+			Name:         info.Key,
+			Size:         info.Size,
+			LastModified: info.LastModified,
+		})
+	}
+	return
+}
+
+func (s *s3Storage) RemoveObject(objectName string) error {
+	return s.client.RemoveObject(s.config.BucketName, objectName)
+}
+
+func NewStorage(config *common.CacheConfig) (common.CacheStorage, error) {
+	// The bucket location tripper is not used, since it only answers the location requests
+	// that are needed to presign the URLs without connecting to the server
+	client, err := minio.New(config.ServerAddress, config.AccessKey, config.SecretKey, config.Insecure)
+	if err != nil {
+		return nil, err
+	}
+
+	storage := &s3Storage{
+		config: config,
+		client: client,
+	}
+	return storage, nil
+}
+
+func init() {
+	common.RegisterCacheStorage("s3", NewStorage)
+}
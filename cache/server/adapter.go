@@ -0,0 +1,64 @@
+package server
+
+import (
+	"errors"
+	"net/url"
+	"time"
+
+	"gitlab.com/gitlab-org/gitlab-ci-multi-runner/common"
+)
+
+const cachePathPrefix = "/cache/"
+
+type serverAdapter struct {
+	config     *common.CacheConfig
+	timeout    time.Duration
+	objectName string
+}
+
+func (a *serverAdapter) getURL(method string) *url.URL {
+	scheme := "https"
+	if a.config.Insecure {
+		scheme = "http"
+	}
+
+	query := SignQuery(a.config.Secret, method, a.objectName, time.Now().Add(a.timeout))
+	return &url.URL{
+		Scheme:   scheme,
+		Host:     a.config.ServerAddress,
+		Path:     cachePathPrefix + a.objectName,
+		RawQuery: query.Encode(),
+	}
+}
+
+func (a *serverAdapter) GetDownloadURL() *url.URL {
+	return a.getURL("GET")
+}
+
+func (a *serverAdapter) GetUploadURL() *url.URL {
+	return a.getURL("PUT")
+}
+
+func (a *serverAdapter) IsStreamingSupported() bool {
+	return true
+}
+
+func New(config *common.CacheConfig, timeout time.Duration, objectName string) (common.CacheAdapter, error) {
+	if config.ServerAddress == "" {
+		return nil, errors.New("missing ServerAddress for the server cache")
+	}
+	if config.Secret == "" {
+		return nil, errors.New("missing Secret for the server cache")
+	}
+
+	adapter := &serverAdapter{
+		config:     config,
+		timeout:    timeout,
+		objectName: objectName,
+	}
+	return adapter, nil
+}
+
+func init() {
+	common.RegisterCacheAdapter("server", New)
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// Handler serves the requests made by cache-extractor and cache-archiver
+// using the URLs signed by the runners
+type Handler struct {
+	Storage *Storage
+	Secret  string
+
+	// StatsToken is required by the /stats requests, the statistics are not served when it's empty
+	StatsToken string
+}
+
+func (h *Handler) isStatsAuthorized(r *http.Request) bool {
+	if h.StatsToken == "" {
+		return false
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(h.StatsToken)) == 1
+}
+
+func (h *Handler) serveStats(w http.ResponseWriter, r *http.Request) {
+	if h.StatsToken == "" {
+		http.NotFound(w, r)
+		return
+	}
+	if !h.isStatsAuthorized(r) {
+		http.Error(w, "invalid stats token", http.StatusUnauthorized)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.Storage.Stats())
+}
+
+func (h *Handler) serveDownload(w http.ResponseWriter, r *http.Request, objectName string) {
+	file, err := h.Storage.Open(objectName)
+	if os.IsNotExist(err) {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	fi, err := file.Stat()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeContent(w, r, "", fi.ModTime(), file)
+}
+
+func (h *Handler) serveUpload(w http.ResponseWriter, r *http.Request, objectName string) {
+	modTime, err := time.Parse(http.TimeFormat, r.Header.Get("Last-Modified"))
+	if err != nil {
+		modTime = time.Now()
+	}
+
+	err = h.Storage.Save(objectName, r.Body, r.ContentLength, modTime)
+	switch err {
+	case nil:
+		w.WriteHeader(http.StatusOK)
+	case ErrObjectTooLarge:
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+	case ErrInvalidObjectName:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		logrus.Warningln("Failed to save", objectName, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/stats" && r.Method == "GET" {
+		h.serveStats(w, r)
+		return
+	}
+
+	if !strings.HasPrefix(r.URL.Path, cachePathPrefix) {
+		http.NotFound(w, r)
+		return
+	}
+	objectName := strings.TrimPrefix(r.URL.Path, cachePathPrefix)
+
+	method := r.Method
+	if method == "HEAD" {
+		method = "GET"
+	}
+
+	err := VerifyQuery(h.Secret, method, objectName, r.URL.Query(), time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	switch method {
+	case "GET":
+		h.serveDownload(w, r, objectName)
+	case "PUT":
+		h.serveUpload(w, r, objectName)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
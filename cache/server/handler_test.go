@@ -0,0 +1,111 @@
+package server
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-ci-multi-runner/common"
+)
+
+func TestHandlerUploadAndDownload(t *testing.T) {
+	storage, cleanup := newTestStorage(t, 0, 0)
+	defer cleanup()
+
+	ts := httptest.NewServer(&Handler{Storage: storage, Secret: "secret"})
+	defer ts.Close()
+
+	config := &common.CacheConfig{
+		Type:          "server",
+		ServerAddress: strings.TrimPrefix(ts.URL, "http://"),
+		Secret:        "secret",
+		Insecure:      true,
+	}
+	adapter, err := New(config, time.Hour, "runner/abc/project/1/key")
+	require.NoError(t, err)
+
+	resp, err := http.Get(adapter.GetDownloadURL().String())
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	req, err := http.NewRequest("PUT", adapter.GetUploadURL().String(), strings.NewReader("content"))
+	require.NoError(t, err)
+	req.Header.Set("Last-Modified", time.Now().Format(http.TimeFormat))
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, err = http.Get(adapter.GetDownloadURL().String())
+	require.NoError(t, err)
+	data, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "content", string(data))
+	assert.NotEmpty(t, resp.Header.Get("Last-Modified"))
+}
+
+func TestHandlerRejectsInvalidSignature(t *testing.T) {
+	storage, cleanup := newTestStorage(t, 0, 0)
+	defer cleanup()
+
+	ts := httptest.NewServer(&Handler{Storage: storage, Secret: "secret"})
+	defer ts.Close()
+
+	config := &common.CacheConfig{
+		ServerAddress: strings.TrimPrefix(ts.URL, "http://"),
+		Secret:        "other",
+		Insecure:      true,
+	}
+	adapter, err := New(config, time.Hour, "key")
+	require.NoError(t, err)
+
+	req, err := http.NewRequest("PUT", adapter.GetUploadURL().String(), strings.NewReader("content"))
+	require.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	// Download URLs can't be used for uploads
+	config.Secret = "secret"
+	req, err = http.NewRequest("PUT", adapter.GetDownloadURL().String(), strings.NewReader("content"))
+	require.NoError(t, err)
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
+
+func getTestStats(t *testing.T, handler *Handler, token string) int {
+	req, err := http.NewRequest("GET", "/stats", nil)
+	require.NoError(t, err)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w.Code
+}
+
+func TestHandlerStatsRequireToken(t *testing.T) {
+	storage, cleanup := newTestStorage(t, 0, 0)
+	defer cleanup()
+
+	handler := &Handler{Storage: storage, Secret: "secret"}
+	assert.Equal(t, http.StatusNotFound, getTestStats(t, handler, ""), "stats are disabled without a token")
+	assert.Equal(t, http.StatusNotFound, getTestStats(t, handler, "secret"))
+
+	handler.StatsToken = "token"
+	assert.Equal(t, http.StatusUnauthorized, getTestStats(t, handler, ""))
+	assert.Equal(t, http.StatusUnauthorized, getTestStats(t, handler, "secret"))
+	assert.Equal(t, http.StatusOK, getTestStats(t, handler, "token"))
+}
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	expiresParam   = "expires"
+	signatureParam = "signature"
+)
+
+func sign(secret, method, objectName string, expires int64) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%s\n%s\n%d", method, objectName, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignQuery returns the query that allows the method on the object until the expiration time
+func SignQuery(secret, method, objectName string, expires time.Time) url.Values {
+	query := url.Values{}
+	query.Set(expiresParam, strconv.FormatInt(expires.Unix(), 10))
+	query.Set(signatureParam, sign(secret, method, objectName, expires.Unix()))
+	return query
+}
+
+// VerifyQuery checks that the query was signed with the secret and is not expired
+func VerifyQuery(secret, method, objectName string, query url.Values, now time.Time) error {
+	expires, err := strconv.ParseInt(query.Get(expiresParam), 10, 64)
+	if err != nil {
+		return errors.New("invalid expiration time")
+	}
+	if now.Unix() > expires {
+		return errors.New("signature expired")
+	}
+
+	expected := sign(secret, method, objectName, expires)
+	if !hmac.Equal([]byte(expected), []byte(query.Get(signatureParam))) {
+		return errors.New("invalid signature")
+	}
+	return nil
+}
@@ -0,0 +1,19 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifySignedQuery(t *testing.T) {
+	now := time.Now()
+	query := SignQuery("secret", "GET", "runner/abc/project/1/key", now.Add(time.Hour))
+
+	assert.NoError(t, VerifyQuery("secret", "GET", "runner/abc/project/1/key", query, now))
+	assert.Error(t, VerifyQuery("other", "GET", "runner/abc/project/1/key", query, now), "different secret")
+	assert.Error(t, VerifyQuery("secret", "PUT", "runner/abc/project/1/key", query, now), "different method")
+	assert.Error(t, VerifyQuery("secret", "GET", "runner/abc/project/2/key", query, now), "different object")
+	assert.Error(t, VerifyQuery("secret", "GET", "runner/abc/project/1/key", query, now.Add(2*time.Hour)), "expired")
+}
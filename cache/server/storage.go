@@ -0,0 +1,243 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+const uploadFilePrefix = ".upload"
+
+var ErrObjectTooLarge = errors.New("object exceeds the size quota")
+var ErrInvalidObjectName = errors.New("invalid object name")
+
+type Stats struct {
+	Objects   int   `json:"objects"`
+	Size      int64 `json:"size"`
+	MaxSize   int64 `json:"max_size"`
+	Hits      int64 `json:"hits"`
+	Misses    int64 `json:"misses"`
+	Uploads   int64 `json:"uploads"`
+	Evictions int64 `json:"evictions"`
+}
+
+type storageObject struct {
+	size     int64
+	lastUsed time.Time
+}
+
+// Storage keeps the caches on disk and removes the least recently used ones
+// when the total size exceeds the quota
+type Storage struct {
+	Dir           string
+	MaxSize       int64
+	MaxObjectSize int64
+
+	objects map[string]*storageObject
+	stats   Stats
+	lock    sync.Mutex
+}
+
+func (s *Storage) objectPath(objectName string) (string, error) {
+	cleaned := path.Clean("/" + objectName)
+	if cleaned == "/" || cleaned != "/"+objectName || strings.HasPrefix(path.Base(cleaned), uploadFilePrefix) {
+		return "", ErrInvalidObjectName
+	}
+	return filepath.Join(s.Dir, filepath.FromSlash(cleaned)), nil
+}
+
+// load builds the index from the files left by the previous run,
+// the modification time is the best approximation of the last use
+func (s *Storage) load() error {
+	s.objects = make(map[string]*storageObject)
+	s.stats = Stats{}
+
+	return filepath.Walk(s.Dir, func(filePath string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() {
+			return err
+		}
+
+		if strings.HasPrefix(fi.Name(), uploadFilePrefix) {
+			os.Remove(filePath)
+			return nil
+		}
+
+		relPath, err := filepath.Rel(s.Dir, filePath)
+		if err != nil {
+			return err
+		}
+
+		s.objects[filepath.ToSlash(relPath)] = &storageObject{
+			size:     fi.Size(),
+			lastUsed: fi.ModTime(),
+		}
+		s.stats.Size += fi.Size()
+		return nil
+	})
+}
+
+func (s *Storage) remove(objectName string) {
+	object := s.objects[objectName]
+	if object == nil {
+		return
+	}
+
+	objectPath, err := s.objectPath(objectName)
+	if err == nil {
+		os.Remove(objectPath)
+	}
+	delete(s.objects, objectName)
+	s.stats.Size -= object.size
+}
+
+func (s *Storage) evict(keep string) {
+	for s.MaxSize > 0 && s.stats.Size > s.MaxSize {
+		var oldestName string
+		var oldest *storageObject
+		for name, object := range s.objects {
+			if name == keep {
+				continue
+			}
+			if oldest == nil || object.lastUsed.Before(oldest.lastUsed) {
+				oldestName, oldest = name, object
+			}
+		}
+		if oldest == nil {
+			return
+		}
+
+		logrus.Infoln("Evicting", oldestName, "last used", oldest.lastUsed)
+		s.remove(oldestName)
+		s.stats.Evictions++
+	}
+}
+
+func (s *Storage) maxObjectSize() int64 {
+	if s.MaxObjectSize > 0 && (s.MaxSize <= 0 || s.MaxObjectSize < s.MaxSize) {
+		return s.MaxObjectSize
+	}
+	return s.MaxSize
+}
+
+// Open returns the stored object, the caller has to close it
+func (s *Storage) Open(objectName string) (*os.File, error) {
+	objectPath, err := s.objectPath(objectName)
+	if err != nil {
+		return nil, err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	object := s.objects[objectName]
+	if object == nil {
+		s.stats.Misses++
+		return nil, os.ErrNotExist
+	}
+
+	file, err := os.Open(objectPath)
+	if err != nil {
+		s.stats.Misses++
+		return nil, err
+	}
+
+	object.lastUsed = time.Now()
+	s.stats.Hits++
+	return file, nil
+}
+
+// Save stores the object, it becomes visible only when it's completely written
+func (s *Storage) Save(objectName string, reader io.Reader, size int64, modTime time.Time) error {
+	objectPath, err := s.objectPath(objectName)
+	if err != nil {
+		return err
+	}
+
+	maxSize := s.maxObjectSize()
+	if maxSize > 0 && size > maxSize {
+		return ErrObjectTooLarge
+	}
+
+	err = os.MkdirAll(filepath.Dir(objectPath), 0700)
+	if err != nil {
+		return err
+	}
+
+	file, err := ioutil.TempFile(filepath.Dir(objectPath), uploadFilePrefix)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	// The size is not known upfront for chunked requests
+	if maxSize > 0 {
+		reader = io.LimitReader(reader, maxSize+1)
+	}
+	written, err := io.Copy(file, reader)
+	if err != nil {
+		return err
+	}
+	if maxSize > 0 && written > maxSize {
+		return ErrObjectTooLarge
+	}
+	file.Close()
+	os.Chtimes(file.Name(), time.Now(), modTime)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	err = os.Rename(file.Name(), objectPath)
+	if err != nil {
+		return err
+	}
+
+	if object := s.objects[objectName]; object != nil {
+		s.stats.Size -= object.size
+	}
+	s.objects[objectName] = &storageObject{
+		size:     written,
+		lastUsed: time.Now(),
+	}
+	s.stats.Size += written
+	s.stats.Uploads++
+
+	s.evict(objectName)
+	return nil
+}
+
+func (s *Storage) Stats() Stats {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	stats := s.stats
+	stats.Objects = len(s.objects)
+	stats.MaxSize = s.MaxSize
+	return stats
+}
+
+func NewStorage(dir string, maxSize, maxObjectSize int64) (*Storage, error) {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	storage := &Storage{
+		Dir:           dir,
+		MaxSize:       maxSize,
+		MaxObjectSize: maxObjectSize,
+	}
+	err = storage.load()
+	if err != nil {
+		return nil, err
+	}
+	return storage, nil
+}
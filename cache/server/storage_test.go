@@ -0,0 +1,108 @@
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestStorage(t *testing.T, maxSize, maxObjectSize int64) (*Storage, func()) {
+	dir, err := ioutil.TempDir("", "cache-server")
+	require.NoError(t, err)
+
+	storage, err := NewStorage(dir, maxSize, maxObjectSize)
+	require.NoError(t, err)
+	return storage, func() { os.RemoveAll(dir) }
+}
+
+func saveTestObject(t *testing.T, storage *Storage, objectName, content string) {
+	err := storage.Save(objectName, strings.NewReader(content), int64(len(content)), time.Now())
+	require.NoError(t, err)
+}
+
+func TestStorageSaveAndOpen(t *testing.T) {
+	storage, cleanup := newTestStorage(t, 0, 0)
+	defer cleanup()
+
+	saveTestObject(t, storage, "project/1/key", "content")
+
+	file, err := storage.Open("project/1/key")
+	require.NoError(t, err)
+	data, err := ioutil.ReadAll(file)
+	file.Close()
+	require.NoError(t, err)
+	assert.Equal(t, "content", string(data))
+
+	_, err = storage.Open("project/1/other")
+	assert.True(t, os.IsNotExist(err))
+
+	stats := storage.Stats()
+	assert.Equal(t, 1, stats.Objects)
+	assert.Equal(t, int64(7), stats.Size)
+	assert.Equal(t, int64(1), stats.Hits)
+	assert.Equal(t, int64(1), stats.Misses)
+	assert.Equal(t, int64(1), stats.Uploads)
+}
+
+func TestStorageEvictsLeastRecentlyUsed(t *testing.T) {
+	storage, cleanup := newTestStorage(t, 10, 0)
+	defer cleanup()
+
+	saveTestObject(t, storage, "first", "1234")
+	saveTestObject(t, storage, "second", "1234")
+
+	// Make the first one used more recently than the second one
+	storage.objects["second"].lastUsed = time.Now().Add(-time.Hour)
+	file, err := storage.Open("first")
+	require.NoError(t, err)
+	file.Close()
+
+	saveTestObject(t, storage, "third", "1234")
+
+	_, err = storage.Open("second")
+	assert.True(t, os.IsNotExist(err), "second should be evicted")
+	_, err = storage.Open("first")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(8), storage.Stats().Size)
+	assert.Equal(t, int64(1), storage.Stats().Evictions)
+}
+
+func TestStorageRejectsTooLargeObjects(t *testing.T) {
+	storage, cleanup := newTestStorage(t, 0, 4)
+	defer cleanup()
+
+	err := storage.Save("key", strings.NewReader("12345"), 5, time.Now())
+	assert.Equal(t, ErrObjectTooLarge, err)
+
+	// The size is not always known upfront
+	err = storage.Save("key", strings.NewReader("12345"), -1, time.Now())
+	assert.Equal(t, ErrObjectTooLarge, err)
+	assert.Equal(t, 0, storage.Stats().Objects)
+}
+
+func TestStorageRejectsInvalidObjectNames(t *testing.T) {
+	storage, cleanup := newTestStorage(t, 0, 0)
+	defer cleanup()
+
+	for _, objectName := range []string{"", "../key", "project/../../key", uploadFilePrefix + "123"} {
+		err := storage.Save(objectName, strings.NewReader("data"), 4, time.Now())
+		assert.Equal(t, ErrInvalidObjectName, err, "for %q", objectName)
+	}
+}
+
+func TestStorageLoadsExistingObjects(t *testing.T) {
+	storage, cleanup := newTestStorage(t, 0, 0)
+	defer cleanup()
+
+	saveTestObject(t, storage, "project/1/key", "content")
+
+	loaded, err := NewStorage(storage.Dir, 0, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 1, loaded.Stats().Objects)
+	assert.Equal(t, int64(7), loaded.Stats().Size)
+}
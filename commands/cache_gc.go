@@ -0,0 +1,154 @@
+package commands
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/codegangsta/cli"
+
+	"gitlab.com/gitlab-org/gitlab-ci-multi-runner/common"
+	"gitlab.com/gitlab-org/gitlab-ci-multi-runner/helpers"
+)
+
+type cacheGCEntry struct {
+	name     string
+	runner   string
+	size     int64
+	lastUsed time.Time
+
+	// sizeUnknown entries are not removed by --max-size
+	sizeUnknown bool
+}
+
+type cacheGCBackend interface {
+	description() string
+	list() ([]cacheGCEntry, error)
+	remove(entry cacheGCEntry) error
+}
+
+type cacheGCEntriesByLastUsed []cacheGCEntry
+
+func (e cacheGCEntriesByLastUsed) Len() int           { return len(e) }
+func (e cacheGCEntriesByLastUsed) Swap(i, j int)      { e[i], e[j] = e[j], e[i] }
+func (e cacheGCEntriesByLastUsed) Less(i, j int) bool { return e[i].lastUsed.After(e[j].lastUsed) }
+
+type CacheGCCommand struct {
+	configOptions
+
+	MaxAge               time.Duration `long:"max-age" description:"Remove caches not updated for longer than this, eg. 720h"`
+	MaxSize              int64         `long:"max-size" description:"Keep only the most recently updated caches that fit in this size in megabytes, for each storage"`
+	RemoveUnknownRunners bool          `long:"remove-unknown-runners" description:"Report caches of runners that are not in the config file as removed, requires --dry-run"`
+	RemoveRunners        []string      `long:"remove-runner" description:"Remove caches of the runner with this token, can be repeated"`
+	DryRun               bool          `long:"dry-run" description:"Only report the caches that would be removed"`
+
+	knownRunners   map[string]bool
+	removedRunners map[string]bool
+}
+
+func (c *CacheGCCommand) isRemovedRunner(entry cacheGCEntry) bool {
+	if entry.runner == "" {
+		return false
+	}
+	return c.removedRunners[entry.runner] || (c.RemoveUnknownRunners && !c.knownRunners[entry.runner])
+}
+
+// selectEntries returns entries to remove, the newest entries are kept within the size budget
+func (c *CacheGCCommand) selectEntries(entries []cacheGCEntry, now time.Time) (selected []cacheGCEntry) {
+	sort.Sort(cacheGCEntriesByLastUsed(entries))
+
+	var totalSize int64
+	overBudget := false
+	for _, entry := range entries {
+		if c.isRemovedRunner(entry) || (c.MaxAge > 0 && now.Sub(entry.lastUsed) > c.MaxAge) {
+			selected = append(selected, entry)
+			continue
+		} else if entry.sizeUnknown {
+			continue
+		}
+
+		totalSize += entry.size
+		if c.MaxSize > 0 && totalSize > c.MaxSize*1024*1024 {
+			overBudget = true
+		}
+		if overBudget {
+			selected = append(selected, entry)
+		}
+	}
+	return
+}
+
+func (c *CacheGCCommand) collect(backend cacheGCBackend) {
+	logger := log.WithField("storage", backend.description())
+
+	entries, err := backend.list()
+	if err != nil {
+		logger.Warningln("Failed to list caches:", err)
+		return
+	}
+
+	if c.MaxSize > 0 && len(entries) > 0 && entries[0].sizeUnknown {
+		logger.Warningln("The size of the caches is unknown, --max-size is not applied")
+	}
+
+	removed := 0
+	var reclaimed int64
+	for _, entry := range c.selectEntries(entries, time.Now()) {
+		if c.DryRun {
+			logger.WithField("runner", entry.runner).Infoln("Would remove", entry.name, "last used", entry.lastUsed)
+		} else if err := backend.remove(entry); err != nil {
+			logger.Warningln("Failed to remove", entry.name, err)
+			continue
+		} else {
+			logger.Debugln("Removed", entry.name)
+		}
+		removed++
+		reclaimed += entry.size
+	}
+
+	logger.WithFields(log.Fields{
+		"entries":   len(entries),
+		"removed":   removed,
+		"reclaimed": fmt.Sprintf("%.1f MB", float64(reclaimed)/1024/1024),
+		"dry-run":   c.DryRun,
+	}).Println("Cache garbage collected")
+}
+
+func (c *CacheGCCommand) Execute(context *cli.Context) {
+	if c.MaxAge <= 0 && c.MaxSize <= 0 && !c.RemoveUnknownRunners && len(c.RemoveRunners) == 0 {
+		log.Fatalln("Specify at least one of --max-age, --max-size, --remove-runner or --remove-unknown-runners")
+	}
+
+	// the storage can be shared with runners of other hosts, so their caches are removed only when listed explicitly
+	if c.RemoveUnknownRunners && !c.DryRun {
+		log.Fatalln("The --remove-unknown-runners requires --dry-run, remove the caches of the reported runners with --remove-runner")
+	}
+
+	err := c.loadConfig()
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	c.knownRunners = make(map[string]bool)
+	for _, runner := range c.config.Runners {
+		c.knownRunners[runner.ShortDescription()] = true
+	}
+
+	c.removedRunners = make(map[string]bool)
+	for _, token := range c.RemoveRunners {
+		token = helpers.ShortenToken(token)
+		if c.knownRunners[token] {
+			log.Fatalln("The runner", token, "is in the config file, its caches are not removed")
+		}
+		c.removedRunners[token] = true
+	}
+
+	for _, backend := range getCacheGCBackends(c.config) {
+		c.collect(backend)
+	}
+}
+
+func init() {
+	common.RegisterCommand2("cache-gc", "remove old caches of the configured runners", &CacheGCCommand{})
+}
@@ -0,0 +1,312 @@
+package commands
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+
+	"gitlab.com/gitlab-org/gitlab-ci-multi-runner/common"
+	dockerExecutor "gitlab.com/gitlab-org/gitlab-ci-multi-runner/executors/docker"
+	"gitlab.com/gitlab-org/gitlab-ci-multi-runner/helpers/archives"
+	"gitlab.com/gitlab-org/gitlab-ci-multi-runner/helpers/docker"
+)
+
+// distributedCacheGCBackend removes the objects named runner/<runner>/project/<id>/<key>,
+// and the shared ones named project/<id>/<key> or namespace/<namespace>/project/<id>/<key>
+type distributedCacheGCBackend struct {
+	config  *common.CacheConfig
+	storage common.CacheStorage
+}
+
+func (b *distributedCacheGCBackend) description() string {
+	return b.config.Type + " cache"
+}
+
+func (b *distributedCacheGCBackend) listPrefix(prefix string) (entries []cacheGCEntry, err error) {
+	objects, err := b.storage.ListObjects(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, object := range objects {
+		var runner string
+		if prefix == "runner/" {
+			parts := strings.SplitN(object.Name, "/", 3)
+			if len(parts) < 3 {
+				continue
+			}
+			runner = parts[1]
+		}
+
+		entries = append(entries, cacheGCEntry{
+			name:     object.Name,
+			runner:   runner,
+			size:     object.Size,
+			lastUsed: object.LastModified,
+		})
+	}
+	return
+}
+
+func (b *distributedCacheGCBackend) list() (entries []cacheGCEntry, err error) {
+	for _, prefix := range []string{"runner/", "project/", "namespace/"} {
+		prefixEntries, err := b.listPrefix(prefix)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, prefixEntries...)
+	}
+	return
+}
+
+func (b *distributedCacheGCBackend) remove(entry cacheGCEntry) error {
+	return b.storage.RemoveObject(entry.name)
+}
+
+// localCacheGCBackend removes the cache archives stored in the cache_dir of the shell executor
+type localCacheGCBackend struct {
+	dir string
+}
+
+func (b *localCacheGCBackend) description() string {
+	return "local cache in " + b.dir
+}
+
+func isLocalCacheArchive(name string) bool {
+	switch name {
+	case "cache." + string(archives.ZipArchive), "cache." + string(archives.TarGzipArchive):
+		return true
+	default:
+		return false
+	}
+}
+
+func (b *localCacheGCBackend) list() (entries []cacheGCEntry, err error) {
+	err = filepath.Walk(b.dir, func(filePath string, fi os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		} else if err != nil {
+			return err
+		} else if !fi.Mode().IsRegular() || !isLocalCacheArchive(fi.Name()) {
+			return nil
+		}
+
+		entries = append(entries, cacheGCEntry{
+			name:     filePath,
+			size:     fi.Size(),
+			lastUsed: fi.ModTime(),
+		})
+		return nil
+	})
+	return
+}
+
+func (b *localCacheGCBackend) remove(entry cacheGCEntry) error {
+	return os.Remove(entry.name)
+}
+
+// dockerCacheDirGCBackend removes the host caches stored in the cache_dir of the docker executor,
+// named <cache_dir>/<project unique name>/<hash of the container path>
+type dockerCacheDirGCBackend struct {
+	dir string
+}
+
+func (b *dockerCacheDirGCBackend) description() string {
+	return "docker host cache in " + b.dir
+}
+
+// getUniqueNameRunner returns the runner of the unique names runner-<runner>-project-<id>-...
+func getUniqueNameRunner(uniqueName string) string {
+	if !strings.HasPrefix(uniqueName, "runner-") {
+		return ""
+	}
+	uniqueName = strings.TrimPrefix(uniqueName, "runner-")
+	if idx := strings.Index(uniqueName, "-project-"); idx > 0 {
+		return uniqueName[:idx]
+	}
+	return ""
+}
+
+// readCacheDir returns the total size of the files and the time of the latest change in the directory
+func readCacheDir(dir string) (entry cacheGCEntry, err error) {
+	entry.name = dir
+	err = filepath.Walk(dir, func(filePath string, fi os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if fi.Mode().IsRegular() {
+			entry.size += fi.Size()
+		}
+		if fi.ModTime().After(entry.lastUsed) {
+			entry.lastUsed = fi.ModTime()
+		}
+		return nil
+	})
+	return
+}
+
+func (b *dockerCacheDirGCBackend) list() (entries []cacheGCEntry, err error) {
+	projects, err := ioutil.ReadDir(b.dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	for _, project := range projects {
+		if !project.IsDir() {
+			continue
+		}
+
+		projectDir := filepath.Join(b.dir, project.Name())
+		caches, err := ioutil.ReadDir(projectDir)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, cache := range caches {
+			if !cache.IsDir() {
+				continue
+			}
+
+			entry, err := readCacheDir(filepath.Join(projectDir, cache.Name()))
+			if err != nil {
+				return nil, err
+			}
+			entry.runner = getUniqueNameRunner(project.Name())
+			entries = append(entries, entry)
+		}
+	}
+	return
+}
+
+func (b *dockerCacheDirGCBackend) remove(entry cacheGCEntry) error {
+	err := os.RemoveAll(entry.name)
+	if err != nil {
+		return err
+	}
+
+	// the project directory is removed with its last cache
+	os.Remove(filepath.Dir(entry.name))
+	return nil
+}
+
+// dockerCacheGCBackend removes the cache containers, Docker doesn't report the size of
+// their volumes. The executor writes the time of each use to the cache containers
+type dockerCacheGCBackend struct {
+	credentials docker_helpers.DockerCredentials
+	client      docker_helpers.Client
+}
+
+func (b *dockerCacheGCBackend) description() string {
+	if b.credentials.Host != "" {
+		return "docker cache containers on " + b.credentials.Host
+	}
+	return "docker cache containers"
+}
+
+func (b *dockerCacheGCBackend) list() (entries []cacheGCEntry, err error) {
+	if b.client == nil {
+		b.client, err = docker_helpers.New(b.credentials, dockerExecutor.DockerAPIVersion)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	containers, err := b.client.ListContainers(docker.ListContainersOptions{
+		All: true,
+		Filters: map[string][]string{
+			"label": {dockerExecutor.DockerLabelPrefix + ".type=cache"},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, container := range containers {
+		lastUsed := time.Unix(container.Created, 0)
+		if used, err := b.readLastUse(container.ID); err == nil && used.After(lastUsed) {
+			lastUsed = used
+		}
+
+		entries = append(entries, cacheGCEntry{
+			name:        container.ID,
+			runner:      container.Labels[dockerExecutor.DockerLabelPrefix+".runner.id"],
+			lastUsed:    lastUsed,
+			sizeUnknown: true,
+		})
+	}
+	return
+}
+
+// readLastUse returns the time of the last use, the containers created by older runners don't have it
+func (b *dockerCacheGCBackend) readLastUse(id string) (time.Time, error) {
+	var buffer bytes.Buffer
+	err := b.client.DownloadFromContainer(id, docker.DownloadFromContainerOptions{
+		OutputStream: &buffer,
+		Path:         "/" + dockerExecutor.DockerCacheLastUseFile,
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	header, err := tar.NewReader(&buffer).Next()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return header.ModTime, nil
+}
+
+func (b *dockerCacheGCBackend) remove(entry cacheGCEntry) error {
+	return b.client.RemoveContainer(docker.RemoveContainerOptions{
+		ID:            entry.name,
+		RemoveVolumes: true,
+		Force:         true,
+	})
+}
+
+func getCacheGCBackends(config *common.Config) (backends []cacheGCBackend) {
+	seen := make(map[string]bool)
+	add := func(key string, backend cacheGCBackend) {
+		if !seen[key] {
+			seen[key] = true
+			backends = append(backends, backend)
+		}
+	}
+
+	for _, runner := range config.Runners {
+		if cache := runner.Cache; cache != nil && cache.Type != "" {
+			storage, err := common.CreateCacheStorage(cache)
+			if err != nil {
+				runner.Log().Warningln(err)
+			} else {
+				key := strings.Join([]string{"cache", cache.Type, cache.ServerAddress, cache.BucketName, cache.Path}, ":")
+				add(key, &distributedCacheGCBackend{config: cache, storage: storage})
+			}
+		}
+
+		switch runner.Executor {
+		case "shell":
+			if runner.CacheDir != "" {
+				add("local:"+runner.CacheDir, &localCacheGCBackend{dir: runner.CacheDir})
+			}
+		case "docker":
+			if runner.Docker != nil {
+				add("docker:"+runner.Docker.Host, &dockerCacheGCBackend{credentials: runner.Docker.DockerCredentials})
+			}
+			if runner.Docker != nil && runner.Docker.CacheDir != "" {
+				add("docker-cache-dir:"+runner.Docker.CacheDir, &dockerCacheDirGCBackend{dir: runner.Docker.CacheDir})
+			}
+		}
+	}
+	return
+}
@@ -0,0 +1,169 @@
+package commands
+
+import (
+	"archive/tar"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-ci-multi-runner/helpers/docker"
+)
+
+func selectedNames(entries []cacheGCEntry) (names []string) {
+	for _, entry := range entries {
+		names = append(names, entry.name)
+	}
+	return
+}
+
+func TestCacheGCSelectsOldEntries(t *testing.T) {
+	now := time.Now()
+	cmd := CacheGCCommand{MaxAge: 24 * time.Hour}
+
+	selected := cmd.selectEntries([]cacheGCEntry{
+		{name: "old", lastUsed: now.Add(-48 * time.Hour)},
+		{name: "new", lastUsed: now.Add(-time.Hour)},
+	}, now)
+	assert.Equal(t, []string{"old"}, selectedNames(selected))
+}
+
+func TestCacheGCKeepsNewestEntriesWithinBudget(t *testing.T) {
+	now := time.Now()
+	cmd := CacheGCCommand{MaxSize: 1}
+
+	selected := cmd.selectEntries([]cacheGCEntry{
+		{name: "oldest", size: 512 * 1024, lastUsed: now.Add(-3 * time.Hour)},
+		{name: "newest", size: 1024 * 1024, lastUsed: now.Add(-time.Hour)},
+		{name: "older", size: 1024 * 1024, lastUsed: now.Add(-2 * time.Hour)},
+	}, now)
+	assert.Equal(t, []string{"older", "oldest"}, selectedNames(selected))
+}
+
+func TestCacheGCSelectsUnknownRunners(t *testing.T) {
+	now := time.Now()
+	cmd := CacheGCCommand{
+		RemoveUnknownRunners: true,
+		knownRunners:         map[string]bool{"known": true},
+	}
+
+	selected := cmd.selectEntries([]cacheGCEntry{
+		{name: "known", runner: "known", lastUsed: now},
+		{name: "unknown", runner: "unknown", lastUsed: now},
+		{name: "local", lastUsed: now},
+	}, now)
+	assert.Equal(t, []string{"unknown"}, selectedNames(selected))
+}
+
+func TestCacheGCSelectsRemovedRunners(t *testing.T) {
+	now := time.Now()
+	cmd := CacheGCCommand{
+		knownRunners:   map[string]bool{"known": true},
+		removedRunners: map[string]bool{"removed": true},
+	}
+
+	selected := cmd.selectEntries([]cacheGCEntry{
+		{name: "known", runner: "known", lastUsed: now},
+		{name: "removed", runner: "removed", lastUsed: now},
+		{name: "other-host", runner: "other-host", lastUsed: now},
+		{name: "local", lastUsed: now},
+	}, now)
+	assert.Equal(t, []string{"removed"}, selectedNames(selected))
+}
+
+func TestCacheGCDockerCacheDirBackend(t *testing.T) {
+	dir, err := ioutil.TempDir("", "docker-cache-dir")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	cacheDir := filepath.Join(dir, "runner-abcdef12-project-10-concurrent-0", "0123456789abcdef")
+	require.NoError(t, os.MkdirAll(filepath.Join(cacheDir, "vendor"), 0700))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(cacheDir, "vendor", "file"), []byte("content"), 0600))
+
+	lastUsed := time.Now().Add(-time.Hour).Truncate(time.Second)
+	require.NoError(t, os.Chtimes(filepath.Join(cacheDir, "vendor", "file"), lastUsed, lastUsed))
+	require.NoError(t, os.Chtimes(filepath.Join(cacheDir, "vendor"), lastUsed, lastUsed))
+	require.NoError(t, os.Chtimes(cacheDir, lastUsed.Add(-time.Hour), lastUsed.Add(-time.Hour)))
+
+	backend := &dockerCacheDirGCBackend{dir: dir}
+	entries, err := backend.list()
+	require.NoError(t, err)
+	require.Equal(t, 1, len(entries))
+	assert.Equal(t, cacheDir, entries[0].name)
+	assert.Equal(t, "abcdef12", entries[0].runner)
+	assert.Equal(t, int64(len("content")), entries[0].size)
+	assert.True(t, lastUsed.Equal(entries[0].lastUsed), "the latest change is the last use")
+
+	assert.NoError(t, backend.remove(entries[0]))
+	_, err = os.Stat(filepath.Dir(cacheDir))
+	assert.True(t, os.IsNotExist(err), "the empty project directory should be removed")
+}
+
+// lastUseDockerClient serves the last use files of the cache containers
+type lastUseDockerClient struct {
+	docker_helpers.MockClient
+	lastUse map[string]time.Time
+}
+
+func (c *lastUseDockerClient) DownloadFromContainer(id string, opts docker.DownloadFromContainerOptions) error {
+	lastUse, ok := c.lastUse[id]
+	if !ok || opts.Path != "/gitlab-runner-cache-last-use" {
+		return &docker.Error{Status: 404}
+	}
+
+	archive := tar.NewWriter(opts.OutputStream)
+	archive.WriteHeader(&tar.Header{Name: "gitlab-runner-cache-last-use", ModTime: lastUse, Typeflag: tar.TypeReg})
+	return archive.Close()
+}
+
+func TestCacheGCDockerBackend(t *testing.T) {
+	client := &lastUseDockerClient{
+		lastUse: map[string]time.Time{"used-id": time.Unix(2000, 0)},
+	}
+	defer client.AssertExpectations(t)
+
+	client.On("ListContainers", mock.Anything).Return([]docker.APIContainers{
+		{
+			ID:      "used-id",
+			Created: 1000,
+			Labels:  map[string]string{"com.gitlab.gitlab-runner.runner.id": "abcdef"},
+		},
+		{
+			ID:      "created-id",
+			Created: 1000,
+		},
+	}, nil).Once()
+	client.On("RemoveContainer", docker.RemoveContainerOptions{
+		ID:            "used-id",
+		RemoveVolumes: true,
+		Force:         true,
+	}).Return(nil).Once()
+
+	backend := &dockerCacheGCBackend{client: client}
+	entries, err := backend.list()
+	require.NoError(t, err)
+	require.Equal(t, 2, len(entries))
+	assert.Equal(t, "abcdef", entries[0].runner)
+	assert.Equal(t, time.Unix(2000, 0), entries[0].lastUsed, "the recorded time is the last use")
+	assert.Equal(t, time.Unix(1000, 0), entries[1].lastUsed, "the creation is the last use without the record")
+	assert.True(t, entries[0].sizeUnknown)
+
+	assert.NoError(t, backend.remove(entries[0]))
+}
+
+func TestCacheGCDoesntSelectEntriesOfUnknownSizeByBudget(t *testing.T) {
+	now := time.Now()
+	cmd := CacheGCCommand{MaxSize: 1, MaxAge: 24 * time.Hour}
+
+	selected := cmd.selectEntries([]cacheGCEntry{
+		{name: "old", sizeUnknown: true, lastUsed: now.Add(-48 * time.Hour)},
+		{name: "new", sizeUnknown: true, lastUsed: now.Add(-time.Hour)},
+	}, now)
+	assert.Equal(t, []string{"old"}, selectedNames(selected))
+}
@@ -0,0 +1,58 @@
+package commands
+
+import (
+	"net/http"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/codegangsta/cli"
+
+	"gitlab.com/gitlab-org/gitlab-ci-multi-runner/cache/server"
+	"gitlab.com/gitlab-org/gitlab-ci-multi-runner/common"
+)
+
+type CacheServerCommand struct {
+	ListenAddress string `long:"listen-address" env:"CACHE_SERVER_LISTEN_ADDRESS" description:"Address the cache server listens on"`
+	Dir           string `long:"dir" env:"CACHE_SERVER_DIR" description:"Directory where the caches are stored"`
+	Secret        string `long:"secret" env:"CACHE_SERVER_SECRET" description:"Secret shared with the runners to sign the cache URLs"`
+	StatsToken    string `long:"stats-token" env:"CACHE_SERVER_STATS_TOKEN" description:"Token required to read the usage statistics under /stats, they are disabled when empty"`
+	MaxSize       int64  `long:"max-size" env:"CACHE_SERVER_MAX_SIZE" description:"Maximum size of all caches in megabytes, the least recently used are removed first (0 for unlimited)"`
+	MaxObjectSize int64  `long:"max-object-size" env:"CACHE_SERVER_MAX_OBJECT_SIZE" description:"Maximum size of a single cache archive in megabytes (0 for unlimited)"`
+}
+
+func (c *CacheServerCommand) Execute(context *cli.Context) {
+	if c.Dir == "" {
+		log.Fatalln("Missing --dir")
+	}
+	if c.Secret == "" {
+		log.Fatalln("Missing --secret")
+	}
+
+	storage, err := server.NewStorage(c.Dir, c.MaxSize*1024*1024, c.MaxObjectSize*1024*1024)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	stats := storage.Stats()
+	log.WithFields(log.Fields{
+		"Dir":     c.Dir,
+		"Objects": stats.Objects,
+		"Size":    stats.Size,
+	}).Println("Starting cache server on", c.ListenAddress)
+
+	handler := &server.Handler{
+		Storage:    storage,
+		Secret:     c.Secret,
+		StatsToken: c.StatsToken,
+	}
+	err = http.ListenAndServe(c.ListenAddress, handler)
+	if err != nil {
+		log.Fatalln(err)
+	}
+}
+
+func init() {
+	common.RegisterCommand2("cache-server", "serve the distributed cache from a local directory", &CacheServerCommand{
+		ListenAddress: ":8093",
+		MaxSize:       10240,
+	})
+}
@@ -36,23 +36,23 @@ func (c *ExecCommand) runCommand(name string, arg ...string) (string, error) {
 	return string(result), err
 }
 
-func (c *ExecCommand) getCommands(commands interface{}) (string, error) {
+func (c *ExecCommand) getCommands(commands interface{}) ([]string, error) {
 	if lines, ok := commands.([]interface{}); ok {
-		text := ""
+		var list []string
 		for _, line := range lines {
 			if lineText, ok := line.(string); ok {
-				text += lineText + "\n"
+				list = append(list, lineText)
 			} else {
-				return "", errors.New("unsupported script")
+				return nil, errors.New("unsupported script")
 			}
 		}
-		return text + "\n", nil
+		return list, nil
 	} else if text, ok := commands.(string); ok {
-		return text + "\n", nil
+		return []string{text}, nil
 	} else if commands != nil {
-		return "", errors.New("unsupported script")
+		return nil, errors.New("unsupported script")
 	}
-	return "", nil
+	return nil, nil
 }
 
 func (c *ExecCommand) supportedOption(key string, _ interface{}) bool {
@@ -64,23 +64,21 @@ func (c *ExecCommand) supportedOption(key string, _ interface{}) bool {
 	}
 }
 
-func (c *ExecCommand) buildCommands(configBeforeScript, jobScript interface{}) (commands string, err error) {
+func (c *ExecCommand) buildCommands(configBeforeScript, jobScript interface{}) (beforeScript, script []string, err error) {
 	// get before_script
-	beforeScript, err := c.getCommands(configBeforeScript)
+	beforeScript, err = c.getCommands(configBeforeScript)
 	if err != nil {
 		return
 	}
-	commands += beforeScript
 
 	// get script
-	script, err := c.getCommands(jobScript)
+	script, err = c.getCommands(jobScript)
 	if err != nil {
 		return
 	} else if jobScript == nil {
 		err = fmt.Errorf("missing 'script' for job")
 		return
 	}
-	commands += script
 	return
 }
 
@@ -163,10 +161,11 @@ func (c *ExecCommand) parseYaml(job string, build *common.GetBuildResponse) erro
 		return fmt.Errorf("no job named %q", job)
 	}
 
-	build.Commands, err = c.buildCommands(config["before_script"], jobConfig["script"])
+	beforeScript, script, err := c.buildCommands(config["before_script"], jobConfig["script"])
 	if err != nil {
 		return err
 	}
+	build.Commands = strings.Join(append(beforeScript, script...), "\n")
 
 	build.Variables, err = c.buildGlobalAndJobVariables(config["variables"], jobConfig["variables"])
 	if err != nil {
@@ -177,6 +176,8 @@ func (c *ExecCommand) parseYaml(job string, build *common.GetBuildResponse) erro
 	if err != nil {
 		return err
 	}
+	build.Options["before_script"] = beforeScript
+	build.Options["script"] = script
 
 	if stage, ok := jobConfig.GetString("stage"); ok {
 		build.Stage = stage
@@ -0,0 +1,50 @@
+package helpers
+
+import (
+	"runtime"
+
+	"gitlab.com/gitlab-org/gitlab-ci-multi-runner/helpers/archives"
+)
+
+type archiveOptionsHelper struct {
+	Format             string `long:"format" description:"Format of the archive: zip or tar.gz"`
+	CompressionLevel   string `long:"compression-level" description:"Compression level: none, fastest, fast, default, slow or slowest"`
+	CompressionWorkers int    `long:"compression-workers" description:"Number of blocks compressed in parallel (tar.gz only), by default the number of CPUs"`
+}
+
+func (h *archiveOptionsHelper) archiveOptions() (options archives.ArchiveOptions, err error) {
+	options.Format, err = archives.ParseArchiveFormat(h.Format)
+	if err != nil {
+		return
+	}
+
+	options.CompressionLevel, err = archives.ParseCompressionLevel(h.CompressionLevel)
+	if err != nil {
+		return
+	}
+
+	options.CompressionWorkers = h.CompressionWorkers
+	if options.CompressionWorkers <= 0 {
+		options.CompressionWorkers = runtime.NumCPU()
+	}
+	return
+}
+
+type extractionLimitsHelper struct {
+	MaxExtractedSize int64 `long:"max-extracted-size" env:"ARCHIVE_MAX_EXTRACTED_SIZE" description:"Maximum size of the extracted archive in MB, 0 means no limit"`
+	MaxEntries       int   `long:"max-entries" env:"ARCHIVE_MAX_ENTRIES" description:"Maximum number of the archive entries, 0 means no limit"`
+}
+
+func (h *extractionLimitsHelper) extractionLimits() archives.ExtractionLimits {
+	return archives.ExtractionLimits{
+		MaxSize:    h.MaxExtractedSize * 1024 * 1024,
+		MaxEntries: h.MaxEntries,
+	}
+}
+
+func defaultExtractionLimitsHelper() extractionLimitsHelper {
+	return extractionLimitsHelper{
+		MaxExtractedSize: 50 * 1024,
+		MaxEntries:       1000000,
+	}
+}
@@ -1,8 +1,16 @@
 package helpers
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/Sirupsen/logrus"
@@ -17,11 +25,119 @@ import (
 type ArtifactsDownloaderCommand struct {
 	common.BuildCredentials
 	retryHelper
+	extractionLimitsHelper
 	network common.Network
+
+	Dependencies    []string `long:"dependency" description:"Download the artifacts of the build given as <id>:<token>:<name>, can be repeated"`
+	DependencyPaths []string `long:"dependency-path" description:"Extract only the matching files of the build given as <id>:<pattern>, can be repeated"`
+	Parallel        int      `long:"parallel" description:"How many artifacts are downloaded at the same time"`
+
+	failed int32
+}
+
+var errDownloadSkipped = errors.New("download skipped after the previous failure")
+
+// dependencyLogs holds the log entries of the background downloads, which have the id field
+// of their dependency, so they are printed with its extraction and don't interleave
+type dependencyLogs struct {
+	lock    sync.Mutex
+	output  io.Writer
+	buffers map[int]*bytes.Buffer
+}
+
+func (l *dependencyLogs) Levels() []logrus.Level {
+	return []logrus.Level{
+		logrus.PanicLevel,
+		logrus.FatalLevel,
+		logrus.ErrorLevel,
+		logrus.WarnLevel,
+		logrus.InfoLevel,
+		logrus.DebugLevel,
+	}
+}
+
+func (l *dependencyLogs) Fire(entry *logrus.Entry) error {
+	data, err := entry.Logger.Formatter.Format(entry)
+	if err != nil {
+		return err
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if id, ok := entry.Data["id"].(int); ok && l.buffers[id] != nil {
+		_, err = l.buffers[id].Write(data)
+	} else {
+		_, err = l.output.Write(data)
+	}
+	return err
+}
+
+// flush prints the held entries of the dependency, its next entries are printed directly
+func (l *dependencyLogs) flush(id int) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if buffer := l.buffers[id]; buffer != nil {
+		l.output.Write(buffer.Bytes())
+		delete(l.buffers, id)
+	}
+}
+
+// holdDependencyLogs replaces the output of the logger until the returned function is called
+func holdDependencyLogs(dependencies []*artifactsDependency) (*dependencyLogs, func()) {
+	logger := logrus.StandardLogger()
+	logs := &dependencyLogs{
+		output:  logger.Out,
+		buffers: make(map[int]*bytes.Buffer),
+	}
+	for _, dependency := range dependencies {
+		logs.buffers[dependency.ID] = new(bytes.Buffer)
+	}
+
+	hooks := logger.Hooks
+	logger.Hooks = make(logrus.LevelHooks)
+	for level, levelHooks := range hooks {
+		logger.Hooks[level] = levelHooks
+	}
+	logger.Hooks.Add(logs)
+	logger.Out = ioutil.Discard
+
+	return logs, func() {
+		logger.Out = logs.output
+		logger.Hooks = hooks
+	}
+}
+
+type artifactsDependency struct {
+	common.BuildCredentials
+	name  string
+	paths []string
+
+	file string
+	err  error
+	done chan struct{}
+}
+
+func (d *artifactsDependency) String() string {
+	if d.name == "" {
+		return strconv.Itoa(d.ID)
+	}
+	return fmt.Sprintf("%s (%d)", d.name, d.ID)
+}
+
+// selected accepts the archive entries matching the paths of the dependency
+func (d *artifactsDependency) selected(name string) bool {
+	for _, pattern := range d.paths {
+		if matched, _ := matchGlob(pattern, name); matched {
+			return true
+		}
+	}
+	return false
 }
 
-func (c *ArtifactsDownloaderCommand) download(file string) (bool, error) {
-	switch c.network.DownloadArtifacts(c.BuildCredentials, file) {
+func (c *ArtifactsDownloaderCommand) download(credentials common.BuildCredentials, file string) (bool, error) {
+	switch c.network.DownloadArtifacts(credentials, file) {
 	case common.DownloadSucceeded:
 		return false, nil
 	case common.DownloadNotFound:
@@ -35,34 +151,193 @@ func (c *ArtifactsDownloaderCommand) download(file string) (bool, error) {
 	}
 }
 
-func (c *ArtifactsDownloaderCommand) Execute(context *cli.Context) {
-	formatter.SetRunnerFormatter()
+// parseDependencies returns the builds to download, the build given by
+// the credentials is used if no dependency is given
+func (c *ArtifactsDownloaderCommand) parseDependencies() ([]*artifactsDependency, error) {
+	var dependencies []*artifactsDependency
+	byID := make(map[int]*artifactsDependency)
 
-	if len(c.URL) == 0 || len(c.Token) == 0 {
-		logrus.Fatalln("Missing runner credentials")
+	add := func(dependency *artifactsDependency) {
+		dependency.URL = c.URL
+		dependency.TLSCAFile = c.TLSCAFile
+		dependency.TLSCertFile = c.TLSCertFile
+		dependency.TLSKeyFile = c.TLSKeyFile
+		dependency.Proxy = c.Proxy
+		dependency.done = make(chan struct{})
+		dependencies = append(dependencies, dependency)
+		byID[dependency.ID] = dependency
+	}
+
+	for _, value := range c.Dependencies {
+		parts := strings.SplitN(value, ":", 3)
+		if len(parts) < 2 {
+			return nil, fmt.Errorf("invalid dependency %q", value)
+		}
+
+		id, err := strconv.Atoi(parts[0])
+		if err != nil || id <= 0 || parts[1] == "" {
+			return nil, fmt.Errorf("invalid dependency %q", value)
+		}
+
+		dependency := &artifactsDependency{}
+		dependency.ID = id
+		dependency.Token = parts[1]
+		if len(parts) > 2 {
+			dependency.name = parts[2]
+		}
+		add(dependency)
 	}
-	if c.ID <= 0 {
-		logrus.Fatalln("Missing build ID")
+
+	if len(dependencies) == 0 {
+		if len(c.Token) == 0 {
+			return nil, fmt.Errorf("Missing runner credentials")
+		} else if c.ID <= 0 {
+			return nil, fmt.Errorf("Missing build ID")
+		}
+
+		dependency := &artifactsDependency{}
+		dependency.ID = c.ID
+		dependency.Token = c.Token
+		add(dependency)
+	}
+
+	for _, value := range c.DependencyPaths {
+		parts := strings.SplitN(value, ":", 2)
+		if len(parts) < 2 {
+			return nil, fmt.Errorf("invalid dependency path %q", value)
+		}
+
+		id, err := strconv.Atoi(parts[0])
+		dependency := byID[id]
+		if err != nil || dependency == nil {
+			return nil, fmt.Errorf("unknown dependency of path %q", value)
+		}
+
+		if _, err := matchGlob(parts[1], ""); err != nil {
+			return nil, fmt.Errorf("%s: %v", parts[1], err)
+		}
+		dependency.paths = append(dependency.paths, parts[1])
+	}
+	return dependencies, nil
+}
+
+func (c *ArtifactsDownloaderCommand) fail() {
+	atomic.StoreInt32(&c.failed, 1)
+}
+
+func (c *ArtifactsDownloaderCommand) hasFailed() bool {
+	return atomic.LoadInt32(&c.failed) != 0
+}
+
+func (c *ArtifactsDownloaderCommand) downloadDependency(dependency *artifactsDependency) {
+	defer close(dependency.done)
+
+	if c.hasFailed() {
+		dependency.err = errDownloadSkipped
+		return
 	}
 
-	// Create temporary file
 	file, err := ioutil.TempFile("", "artifacts")
 	if err != nil {
-		logrus.Fatalln(err)
+		dependency.err = err
+		c.fail()
+		return
 	}
 	file.Close()
-	defer os.Remove(file.Name())
+	dependency.file = file.Name()
 
-	// Download artifacts file
-	err = c.doRetry(func() (bool, error) {
-		return c.download(file.Name())
+	dependency.err = c.doRetryWithLog(logrus.WithField("id", dependency.ID), func() (bool, error) {
+		return c.download(dependency.BuildCredentials, dependency.file)
 	})
+	if dependency.err != nil {
+		c.fail()
+	}
+}
+
+// downloadAll downloads the artifacts in the background, at most Parallel at once
+func (c *ArtifactsDownloaderCommand) downloadAll(dependencies []*artifactsDependency) {
+	parallel := c.Parallel
+	if parallel <= 0 {
+		parallel = 1
+	}
+
+	slots := make(chan struct{}, parallel)
+	for _, dependency := range dependencies {
+		go func(dependency *artifactsDependency) {
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			c.downloadDependency(dependency)
+		}(dependency)
+	}
+}
+
+func (c *ArtifactsDownloaderCommand) extract(dependency *artifactsDependency) error {
+	if len(dependency.paths) == 0 {
+		return archives.ExtractZipFile(dependency.file, c.extractionLimits())
+	}
+
+	logrus.Infoln("Extracting only", strings.Join(dependency.paths, ", "))
+	return archives.ExtractZipFileSelected(dependency.file, c.extractionLimits(), dependency.selected)
+}
+
+// extractAll extracts the artifacts in the order of dependencies, so the files of the latter
+// ones overwrite the files of the former ones, and the output is grouped by the dependency
+func (c *ArtifactsDownloaderCommand) extractAll(dependencies []*artifactsDependency, logs *dependencyLogs) (err error) {
+	for _, dependency := range dependencies {
+		<-dependency.done
+		if err != nil {
+			continue
+		}
+
+		if len(dependencies) > 1 {
+			logrus.Infoln("Artifacts of", dependency.String()+":")
+		}
+		if logs != nil {
+			logs.flush(dependency.ID)
+		}
+
+		err = dependency.err
+		if err == nil {
+			err = c.extract(dependency)
+		}
+		if err != nil {
+			c.fail()
+			err = fmt.Errorf("%s: %v", dependency, err)
+		}
+	}
+	return
+}
+
+func (c *ArtifactsDownloaderCommand) Execute(context *cli.Context) {
+	formatter.SetRunnerFormatter()
+
+	if len(c.URL) == 0 {
+		logrus.Fatalln("Missing runner credentials")
+	}
+
+	dependencies, err := c.parseDependencies()
 	if err != nil {
 		logrus.Fatalln(err)
 	}
 
-	// Extract artifacts file
-	err = archives.ExtractZipFile(file.Name())
+	// the logs of the downloads are printed with the extraction of their dependency
+	var logs *dependencyLogs
+	if len(dependencies) > 1 {
+		var restore func()
+		logs, restore = holdDependencyLogs(dependencies)
+		defer restore()
+	}
+
+	c.downloadAll(dependencies)
+	err = c.extractAll(dependencies, logs)
+
+	for _, dependency := range dependencies {
+		if dependency.file != "" {
+			os.Remove(dependency.file)
+		}
+	}
+
 	if err != nil {
 		logrus.Fatalln(err)
 	}
@@ -75,5 +350,7 @@ func init() {
 			Retry:     2,
 			RetryTime: time.Second,
 		},
+		extractionLimitsHelper: defaultExtractionLimitsHelper(),
+		Parallel:               4,
 	})
 }
@@ -1,8 +1,11 @@
 package helpers
 
 import (
+	"bytes"
+	"strings"
 	"testing"
 
+	"github.com/Sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 
 	"gitlab.com/gitlab-org/gitlab-ci-multi-runner/common"
@@ -93,3 +96,112 @@ func TestArtifactsDownloaderSucceeded(t *testing.T) {
 	fi, _ = os.Stat(artifactsTestArchivedFile)
 	assert.NotNil(t, fi)
 }
+
+func TestArtifactsDownloaderParallelDependencies(t *testing.T) {
+	network := &testNetwork{
+		downloadState: common.DownloadSucceeded,
+	}
+	cmd := ArtifactsDownloaderCommand{
+		BuildCredentials: common.BuildCredentials{URL: "test"},
+		Dependencies:     []string{"1:first:build", "2:second:test", "3:third"},
+		Parallel:         2,
+		network:          network,
+	}
+
+	os.Remove(artifactsTestArchivedFile)
+	defer os.Remove(artifactsTestArchivedFile)
+	cmd.Execute(nil)
+	assert.Equal(t, 3, network.downloadCalled)
+	_, err := os.Stat(artifactsTestArchivedFile)
+	assert.NoError(t, err)
+}
+
+func TestArtifactsDownloaderSelectedPaths(t *testing.T) {
+	network := &testNetwork{
+		downloadState: common.DownloadSucceeded,
+	}
+	cmd := ArtifactsDownloaderCommand{
+		BuildCredentials: common.BuildCredentials{URL: "test"},
+		Dependencies:     []string{"1:first:build"},
+		DependencyPaths:  []string{"1:public/**"},
+		network:          network,
+	}
+
+	os.Remove(artifactsTestArchivedFile)
+	cmd.Execute(nil)
+	assert.Equal(t, 1, network.downloadCalled)
+	_, err := os.Stat(artifactsTestArchivedFile)
+	assert.True(t, os.IsNotExist(err), "not matching files should not be extracted")
+}
+
+func TestArtifactsDownloaderPathOfUnknownDependency(t *testing.T) {
+	helpers.MakeFatalToPanic()
+
+	cmd := ArtifactsDownloaderCommand{
+		BuildCredentials: common.BuildCredentials{URL: "test"},
+		Dependencies:     []string{"1:first:build"},
+		DependencyPaths:  []string{"2:public/**"},
+		network:          &testNetwork{},
+	}
+	assert.Panics(t, func() {
+		cmd.Execute(nil)
+	})
+}
+
+type loggingTestNetwork struct {
+	testNetwork
+}
+
+func (m *loggingTestNetwork) DownloadArtifacts(config common.BuildCredentials, artifactsFile string) common.DownloadState {
+	logrus.WithField("id", config.ID).Infoln("Downloading artifacts of", config.ID)
+	return m.testNetwork.DownloadArtifacts(config, artifactsFile)
+}
+
+func TestArtifactsDownloaderGroupsLogsByDependency(t *testing.T) {
+	output := new(bytes.Buffer)
+	logrus.SetOutput(output)
+	defer logrus.SetOutput(os.Stderr)
+
+	network := &loggingTestNetwork{}
+	network.downloadState = common.DownloadSucceeded
+	cmd := ArtifactsDownloaderCommand{
+		BuildCredentials: common.BuildCredentials{URL: "test"},
+		Dependencies:     []string{"1:first:build", "2:second:test", "3:third"},
+		Parallel:         3,
+		network:          network,
+	}
+
+	defer os.Remove(artifactsTestArchivedFile)
+	cmd.Execute(nil)
+	assert.Equal(t, 3, network.downloadCalled)
+
+	var lines []string
+	for _, line := range strings.Split(output.String(), "\n") {
+		if strings.Contains(line, "Artifacts of") || strings.Contains(line, "Downloading artifacts of") {
+			lines = append(lines, strings.TrimSpace(strings.SplitN(line, "  ", 2)[0]))
+		}
+	}
+	assert.Equal(t, []string{
+		"Artifacts of build (1):", "Downloading artifacts of 1",
+		"Artifacts of test (2):", "Downloading artifacts of 2",
+		"Artifacts of 3:", "Downloading artifacts of 3",
+	}, lines)
+}
+
+func TestArtifactsDownloaderStopsAfterFirstError(t *testing.T) {
+	helpers.MakeFatalToPanic()
+
+	network := &testNetwork{
+		downloadState: common.DownloadForbidden,
+	}
+	cmd := ArtifactsDownloaderCommand{
+		BuildCredentials: common.BuildCredentials{URL: "test"},
+		Dependencies:     []string{"1:first:build", "2:second:test", "3:third"},
+		Parallel:         1,
+		network:          network,
+	}
+	assert.Panics(t, func() {
+		cmd.Execute(nil)
+	})
+	assert.Equal(t, 1, network.downloadCalled, "no download should start after the failure")
+}
@@ -3,8 +3,10 @@ package helpers
 import (
 	"archive/zip"
 	"bytes"
+	"compress/gzip"
 	"io"
 	"os"
+	"sync"
 
 	"github.com/Sirupsen/logrus"
 
@@ -15,6 +17,7 @@ const artifactsTestArchivedFile = "archive_file"
 
 type testNetwork struct {
 	common.MockNetwork
+	lock           sync.Mutex
 	downloadState  common.DownloadState
 	downloadCalled int
 	uploadState    common.UploadState
@@ -22,7 +25,9 @@ type testNetwork struct {
 }
 
 func (m *testNetwork) DownloadArtifacts(config common.BuildCredentials, artifactsFile string) common.DownloadState {
+	m.lock.Lock()
 	m.downloadCalled++
+	m.lock.Unlock()
 
 	if m.downloadState == common.DownloadSucceeded {
 		file, err := os.Create(artifactsFile)
@@ -39,7 +44,7 @@ func (m *testNetwork) DownloadArtifacts(config common.BuildCredentials, artifact
 	return m.downloadState
 }
 
-func (m *testNetwork) UploadRawArtifacts(config common.BuildCredentials, reader io.Reader, baseName string, expireIn string) common.UploadState {
+func (m *testNetwork) UploadRawArtifacts(config common.BuildCredentials, reader io.Reader, metadata io.Reader, baseName string, expireIn string) common.UploadState {
 	m.uploadCalled++
 
 	if m.uploadState == common.UploadSucceeded {
@@ -55,6 +60,11 @@ func (m *testNetwork) UploadRawArtifacts(config common.BuildCredentials, reader
 			logrus.Warningln("Invalid archive:", len(archive.File))
 			return common.UploadForbidden
 		}
+
+		if _, err := gzip.NewReader(metadata); err != nil {
+			logrus.Warningln("Invalid metadata:", err)
+			return common.UploadForbidden
+		}
 	}
 	return m.uploadState
 }
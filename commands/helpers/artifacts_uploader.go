@@ -1,6 +1,7 @@
 package helpers
 
 import (
+	"bytes"
 	"errors"
 	"io"
 	"os"
@@ -22,24 +23,34 @@ type ArtifactsUploaderCommand struct {
 	retryHelper
 	network common.Network
 
-	Name     string `long:"name" description:"The name of the archive"`
-	ExpireIn string `long:"expire-in" description:"When to expire artifacts"`
+	Name             string `long:"name" description:"The name of the archive"`
+	ExpireIn         string `long:"expire-in" description:"When to expire artifacts"`
+	CompressionLevel string `long:"compression-level" description:"Compression level: none, fastest, fast, default, slow or slowest"`
 }
 
 func (c *ArtifactsUploaderCommand) createAndUpload() (bool, error) {
+	compressionLevel, err := archives.ParseCompressionLevel(c.CompressionLevel)
+	if err != nil {
+		return false, err
+	}
+
 	pr, pw := io.Pipe()
 	defer pr.Close()
 
-	// Create the archive
+	// The metadata is written before the archive stream is closed,
+	// so it's complete when the archive is uploaded
+	metadata := new(bytes.Buffer)
+
+	// Create the archive, GitLab accepts only zip archives as artifacts
 	go func() {
-		err := archives.CreateZipArchive(pw, c.sortedFiles())
+		err := archives.CreateZipArchiveWithMetadata(pw, metadata, c.sortedFiles(), compressionLevel)
 		pw.CloseWithError(err)
 	}()
 
 	artifactsName := path.Base(c.Name) + ".zip"
 
 	// Upload the data
-	switch c.network.UploadRawArtifacts(c.BuildCredentials, pr, artifactsName, c.ExpireIn) {
+	switch c.network.UploadRawArtifacts(c.BuildCredentials, pr, metadata, artifactsName, c.ExpireIn) {
 	case common.UploadSucceeded:
 		return false, nil
 	case common.UploadForbidden:
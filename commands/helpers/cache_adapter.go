@@ -0,0 +1,39 @@
+package helpers
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"gitlab.com/gitlab-org/gitlab-ci-multi-runner/common"
+)
+
+// cacheAdapterHelper generates the addresses of the key computed by the helper,
+// the storage configuration is passed in the environment, so it's not visible in the arguments
+type cacheAdapterHelper struct {
+	CacheConfig       string        `long:"cache-config" env:"CACHE_CONFIG" description:"JSON configuration of the distributed cache storage, used with the computed key"`
+	CacheObjectPrefix string        `long:"cache-object-prefix" description:"Where the caches of the project are stored in the distributed cache"`
+	CacheURLTimeout   time.Duration `long:"cache-url-timeout" description:"How long the generated cache addresses are valid"`
+}
+
+func (h *cacheAdapterHelper) hasCacheAdapter() bool {
+	return h.CacheConfig != "" && h.CacheObjectPrefix != ""
+}
+
+func (h *cacheAdapterHelper) getCacheAdapter(key string) (common.CacheAdapter, error) {
+	var config common.CacheConfig
+	err := json.Unmarshal([]byte(h.CacheConfig), &config)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cache configuration: %v", err)
+	}
+
+	// The key can't point to the caches of other projects
+	objectName := path.Join(h.CacheObjectPrefix, key)
+	if !strings.HasPrefix(objectName, path.Clean(h.CacheObjectPrefix)+"/") {
+		return nil, fmt.Errorf("invalid cache key: %s", key)
+	}
+
+	return common.CreateCacheAdapter(&config, h.CacheURLTimeout, objectName)
+}
@@ -0,0 +1,89 @@
+package helpers
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	_ "gitlab.com/gitlab-org/gitlab-ci-multi-runner/cache/filesystem"
+	"gitlab.com/gitlab-org/gitlab-ci-multi-runner/common"
+	"gitlab.com/gitlab-org/gitlab-ci-multi-runner/helpers"
+)
+
+func testCacheAdapterHelper(t *testing.T, dir string) cacheAdapterHelper {
+	config, err := json.Marshal(common.CacheConfig{Type: "filesystem", Path: dir})
+	require.NoError(t, err)
+
+	return cacheAdapterHelper{
+		CacheConfig:       string(config),
+		CacheObjectPrefix: "project/10",
+		CacheURLTimeout:   time.Hour,
+	}
+}
+
+func TestCacheOfComputedKeyIsShared(t *testing.T) {
+	ioutil.WriteFile(cacheArchiverTestArchivedFile, nil, 0600)
+	defer os.Remove(cacheArchiverTestArchivedFile)
+
+	dir, err := ioutil.TempDir("", "shared-cache")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	localDir, err := ioutil.TempDir("", "local-cache")
+	require.NoError(t, err)
+	defer os.RemoveAll(localDir)
+
+	key := cacheKeyHelper{
+		KeyFiles: []string{"not-existing.lock"},
+		CacheDir: filepath.Join(localDir, "archiver"),
+	}
+	computedKey, err := key.computeKey()
+	require.NoError(t, err)
+
+	helpers.MakeFatalToPanic()
+	archiver := CacheArchiverCommand{
+		fileArchiver: fileArchiver{
+			Paths: []string{
+				cacheArchiverTestArchivedFile,
+			},
+		},
+		cacheKeyHelper:     key,
+		cacheAdapterHelper: testCacheAdapterHelper(t, dir),
+	}
+	assert.NotPanics(t, func() {
+		archiver.Execute(nil)
+	})
+
+	_, err = os.Stat(filepath.Join(dir, "project", "10", computedKey))
+	assert.NoError(t, err, "the cache should be stored in the distributed cache")
+
+	os.Remove(cacheArchiverTestArchivedFile)
+	key.CacheDir = filepath.Join(localDir, "extractor")
+	extractor := CacheExtractorCommand{
+		cacheKeyHelper:     key,
+		cacheAdapterHelper: testCacheAdapterHelper(t, dir),
+	}
+	assert.NotPanics(t, func() {
+		extractor.Execute(nil)
+	})
+
+	_, err = os.Stat(cacheArchiverTestArchivedFile)
+	assert.NoError(t, err, "the cache should be restored from the distributed cache")
+}
+
+func TestCacheAdapterRejectsKeyOutsideOfProject(t *testing.T) {
+	h := testCacheAdapterHelper(t, "/cache")
+
+	adapter, err := h.getCacheAdapter("key")
+	require.NoError(t, err)
+	assert.Equal(t, "file:///cache/project/10/key", adapter.GetDownloadURL().String())
+
+	_, err = h.getCacheAdapter("../11/key")
+	assert.Error(t, err)
+}
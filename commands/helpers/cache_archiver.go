@@ -1,8 +1,12 @@
 package helpers
 
 import (
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"time"
@@ -15,34 +19,76 @@ import (
 	"gitlab.com/gitlab-org/gitlab-ci-multi-runner/helpers/url"
 )
 
+// errUploadSizeRequired is returned when the server rejects the upload of unknown size, eg. the S3 presigned URLs
+var errUploadSizeRequired = errors.New("the storage requires the size of the upload, the archive is stored locally before it's uploaded")
+
 type CacheArchiverCommand struct {
 	fileArchiver
 	retryHelper
-	File string `long:"file" description:"The path to file"`
-	URL  string `long:"url" description:"Download artifacts instead of uploading them"`
+	cacheKeyHelper
+	cacheAdapterHelper
+	cacheEncryptionHelper
+	archiveOptionsHelper
+	File   string `long:"file" description:"The path to file"`
+	URL    string `long:"url" description:"Download artifacts instead of uploading them"`
+	Stream bool   `long:"stream" description:"Upload the archive while it's created, without storing it locally. It's stored locally if the storage requires the size of the upload (eg. S3)"`
+
+	options archives.ArchiveOptions
 }
 
-func (c *CacheArchiverCommand) upload() (bool, error) {
-	logrus.Infoln("Uploading", filepath.Base(c.File), "to", url_helpers.CleanURL(c.URL))
+// openReader returns the stream that is stored remotely, the archive is encrypted while it's read.
+// The size is -1 if it's not known upfront.
+func (c *CacheArchiverCommand) openReader(reader io.Reader, size int64) (io.Reader, int64, error) {
+	if !c.isEncrypted() {
+		return reader, size, nil
+	}
 
-	file, err := os.Open(c.File)
+	encrypted, err := c.encrypt(reader)
+	if err != nil {
+		return nil, 0, err
+	}
+	if size < 0 {
+		return encrypted, -1, nil
+	}
+	return encrypted, c.encryptedSize(size), nil
+}
+
+// copy stores the archive in the shared directory, other builds see it only when it's complete
+func (c *CacheArchiverCommand) copy(targetFile string, reader io.Reader, modTime time.Time) (bool, error) {
+	err := os.MkdirAll(filepath.Dir(targetFile), 0700)
 	if err != nil {
 		return false, err
 	}
-	defer file.Close()
 
-	fi, err := file.Stat()
+	target, err := ioutil.TempFile(filepath.Dir(targetFile), "cache")
+	if err != nil {
+		return false, err
+	}
+	defer target.Close()
+	defer os.Remove(target.Name())
+
+	_, err = io.Copy(target, reader)
 	if err != nil {
 		return false, err
 	}
+	target.Close()
+	os.Chtimes(target.Name(), time.Now(), modTime)
+
+	return false, os.Rename(target.Name(), targetFile)
+}
+
+func (c *CacheArchiverCommand) send(reader io.Reader, size int64, modTime time.Time) (bool, error) {
+	if u, err := url.Parse(c.URL); err == nil && u.Scheme == "file" {
+		return c.copy(url_helpers.FilePath(u), reader, modTime)
+	}
 
-	req, err := http.NewRequest("PUT", c.URL, file)
+	req, err := http.NewRequest("PUT", c.URL, reader)
 	if err != nil {
 		return true, err
 	}
 	req.Header.Set("Content-Type", "application/octet-stream")
-	req.Header.Set("Last-Modified", fi.ModTime().Format(http.TimeFormat))
-	req.ContentLength = fi.Size()
+	req.Header.Set("Last-Modified", modTime.Format(http.TimeFormat))
+	req.ContentLength = size
 
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
@@ -50,7 +96,9 @@ func (c *CacheArchiverCommand) upload() (bool, error) {
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode/100 != 2 {
+	if size < 0 && (resp.StatusCode == http.StatusLengthRequired || resp.StatusCode == http.StatusNotImplemented) {
+		return false, errUploadSizeRequired
+	} else if resp.StatusCode/100 != 2 {
 		// Retry on server errors
 		retry := resp.StatusCode/100 == 5
 		return retry, fmt.Errorf("Received: %s", resp.Status)
@@ -59,25 +107,113 @@ func (c *CacheArchiverCommand) upload() (bool, error) {
 	return false, nil
 }
 
+func (c *CacheArchiverCommand) upload() (bool, error) {
+	logrus.Infoln("Uploading", filepath.Base(c.File), "to", url_helpers.CleanURL(c.URL))
+
+	file, err := os.Open(c.File)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	fi, err := file.Stat()
+	if err != nil {
+		return false, err
+	}
+
+	reader, size, err := c.openReader(file, fi.Size())
+	if err != nil {
+		return false, err
+	}
+	return c.send(reader, size, fi.ModTime())
+}
+
+// createAndUpload uploads the archive while it's created, the size is not known upfront
+func (c *CacheArchiverCommand) createAndUpload() (bool, error) {
+	logrus.Infoln("Uploading archive to", url_helpers.CleanURL(c.URL))
+
+	pr, pw := io.Pipe()
+	defer pr.Close()
+
+	go func() {
+		err := archives.CreateArchive(pw, c.sortedFiles(), c.options)
+		pw.CloseWithError(err)
+	}()
+
+	reader, size, err := c.openReader(pr, -1)
+	if err != nil {
+		return false, err
+	}
+	return c.send(reader, size, time.Now())
+}
+
+// useCacheAdapter uploads the archive of the computed key to the distributed cache
+func (c *CacheArchiverCommand) useCacheAdapter(cacheKey string) {
+	adapter, err := c.getCacheAdapter(cacheKey)
+	if err != nil {
+		logrus.Warningln(err)
+		return
+	}
+
+	url := adapter.GetUploadURL()
+	if url == nil {
+		return
+	}
+	c.URL = url.String()
+
+	// The archive is uploaded while it's created, when the size doesn't have to be known upfront
+	if streaming, ok := adapter.(common.StreamingCacheAdapter); ok && streaming.IsStreamingSupported() {
+		c.Stream = true
+	}
+}
+
 func (c *CacheArchiverCommand) Execute(*cli.Context) {
+	var err error
+	c.options, err = c.archiveOptions()
+	if err != nil {
+		logrus.Fatalln(err)
+	}
+
+	if c.hasComputedKey() {
+		cacheKey, cacheFile, err := c.computeCacheFile(c.options.Format)
+		if err != nil {
+			logrus.Fatalln(err)
+		}
+		c.File = cacheFile
+
+		if c.hasCacheAdapter() {
+			c.useCacheAdapter(cacheKey)
+		}
+	}
+
 	if c.File == "" {
 		logrus.Fatalln("Missing --file")
 	}
 
 	// Enumerate files
-	err := c.enumerate()
+	err = c.enumerate()
 	if err != nil {
 		logrus.Fatalln(err)
 	}
 
-	// Check if list of files changed
-	if !c.isFileChanged(c.File) {
+	// The archive is not stored locally, so it's always uploaded
+	if c.Stream && c.URL != "" {
+		err := c.doRetry(c.createAndUpload)
+		if err != errUploadSizeRequired {
+			if err != nil {
+				logrus.Warningln(err)
+			}
+			return
+		}
+		logrus.Infoln(err)
+	} else if !c.isFileChanged(c.File) {
+		// The list of files didn't change
 		logrus.Infoln("Archive is up to date!")
 		return
 	}
 
 	// Create archive
-	err = archives.CreateZipFile(c.File, c.sortedFiles())
+	err = archives.CreateArchiveFile(c.File, c.sortedFiles(), c.options)
 	if err != nil {
 		logrus.Fatalln(err)
 	}
@@ -9,6 +9,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"time"
 )
 
@@ -106,3 +107,140 @@ func TestCacheArchiverRemoteServerDoesntFailOnInvalidServer(t *testing.T) {
 	_, err := os.Stat(cacheExtractorTestArchivedFile)
 	assert.Error(t, err)
 }
+
+func TestCacheArchiverSharedDirectory(t *testing.T) {
+	ioutil.WriteFile(cacheArchiverTestArchivedFile, nil, 0600)
+	defer os.Remove(cacheArchiverTestArchivedFile)
+	defer os.Remove(cacheArchiverArchive)
+	os.Remove(cacheArchiverArchive)
+
+	dir, err := ioutil.TempDir("", "shared-cache")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	helpers.MakeFatalToPanic()
+	cmd := CacheArchiverCommand{
+		File: cacheArchiverArchive,
+		URL:  "file://" + filepath.ToSlash(filepath.Join(dir, "project", "cache.zip")),
+		fileArchiver: fileArchiver{
+			Paths: []string{
+				cacheArchiverTestArchivedFile,
+			},
+		},
+	}
+	assert.NotPanics(t, func() {
+		cmd.Execute(nil)
+	})
+
+	_, err = os.Stat(filepath.Join(dir, "project", "cache.zip"))
+	assert.NoError(t, err)
+}
+
+func TestCacheArchiverEncryptsSharedDirectory(t *testing.T) {
+	ioutil.WriteFile(cacheArchiverTestArchivedFile, nil, 0600)
+	defer os.Remove(cacheArchiverTestArchivedFile)
+	defer os.Remove(cacheArchiverArchive)
+	os.Remove(cacheArchiverArchive)
+
+	dir, err := ioutil.TempDir("", "shared-cache")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	encryption := cacheEncryptionHelper{EncryptionKey: "key"}
+	helpers.MakeFatalToPanic()
+	cmd := CacheArchiverCommand{
+		File: cacheArchiverArchive,
+		URL:  "file://" + filepath.ToSlash(filepath.Join(dir, "cache.zip")),
+		fileArchiver: fileArchiver{
+			Paths: []string{
+				cacheArchiverTestArchivedFile,
+			},
+		},
+		cacheEncryptionHelper: encryption,
+	}
+	assert.NotPanics(t, func() {
+		cmd.Execute(nil)
+	})
+
+	archive, err := ioutil.ReadFile(cacheArchiverArchive)
+	assert.NoError(t, err)
+	encrypted, err := ioutil.ReadFile(filepath.Join(dir, "cache.zip"))
+	assert.NoError(t, err)
+
+	decrypted, err := decryptTestData(encryption, encrypted)
+	assert.NoError(t, err)
+	assert.Equal(t, archive, decrypted)
+}
+
+func TestCacheArchiverStreamsTarGzipArchive(t *testing.T) {
+	ioutil.WriteFile(cacheArchiverTestArchivedFile, nil, 0600)
+	defer os.Remove(cacheArchiverTestArchivedFile)
+	defer os.Remove(cacheArchiverArchive)
+	os.Remove(cacheArchiverArchive)
+
+	dir, err := ioutil.TempDir("", "shared-cache")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	helpers.MakeFatalToPanic()
+	cmd := CacheArchiverCommand{
+		File:   cacheArchiverArchive,
+		URL:    "file://" + filepath.ToSlash(filepath.Join(dir, "cache.tar.gz")),
+		Stream: true,
+		fileArchiver: fileArchiver{
+			Paths: []string{
+				cacheArchiverTestArchivedFile,
+			},
+		},
+		archiveOptionsHelper: archiveOptionsHelper{
+			Format: "tar.gz",
+		},
+	}
+	assert.NotPanics(t, func() {
+		cmd.Execute(nil)
+	})
+
+	_, err = os.Stat(cacheArchiverArchive)
+	assert.True(t, os.IsNotExist(err), "streamed archive should not be stored locally")
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "cache.tar.gz"))
+	assert.NoError(t, err)
+	assert.True(t, len(data) > 2 && data[0] == 0x1f && data[1] == 0x8b, "archive should be gzipped")
+}
+
+func TestCacheArchiverStoresArchiveIfSizeIsRequired(t *testing.T) {
+	ioutil.WriteFile(cacheArchiverTestArchivedFile, nil, 0600)
+	defer os.Remove(cacheArchiverTestArchivedFile)
+	defer os.Remove(cacheArchiverArchive)
+	os.Remove(cacheArchiverArchive)
+
+	// The S3 presigned URLs reject the uploads without the Content-Length
+	var uploaded []byte
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength < 0 {
+			w.WriteHeader(http.StatusLengthRequired)
+			return
+		}
+		uploaded, _ = ioutil.ReadAll(r.Body)
+	}))
+	defer ts.Close()
+
+	helpers.MakeFatalToPanic()
+	cmd := CacheArchiverCommand{
+		File:   cacheArchiverArchive,
+		URL:    ts.URL + "/cache.zip",
+		Stream: true,
+		fileArchiver: fileArchiver{
+			Paths: []string{
+				cacheArchiverTestArchivedFile,
+			},
+		},
+	}
+	assert.NotPanics(t, func() {
+		cmd.Execute(nil)
+	})
+
+	archive, err := ioutil.ReadFile(cacheArchiverArchive)
+	assert.NoError(t, err, "archive should be stored locally")
+	assert.Equal(t, archive, uploaded)
+}
@@ -0,0 +1,227 @@
+package helpers
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// The archive is encrypted in chunks, so it can be streamed without keeping it in memory.
+// Each chunk is sealed with AES-GCM using a nonce made of the random prefix from the header,
+// the chunk number and the flag marking the last chunk, which detects reordered or truncated archives.
+const cacheEncryptionMagic = "GLRCACHE"
+const cacheEncryptionVersion = 1
+const cacheEncryptionNoncePrefixSize = 7
+const cacheEncryptionHeaderSize = len(cacheEncryptionMagic) + 1 + cacheEncryptionNoncePrefixSize
+const cacheEncryptionChunkSize = 64 * 1024
+const cacheEncryptionLengthSize = 4
+
+var errCacheVerification = errors.New("cache archive failed verification")
+
+type cacheEncryptionHelper struct {
+	EncryptionKey string `long:"encryption-key" env:"CACHE_ENCRYPTION_KEY" description:"Encrypt the remote cache archive with this key"`
+}
+
+func (h *cacheEncryptionHelper) isEncrypted() bool {
+	return h.EncryptionKey != ""
+}
+
+func (h *cacheEncryptionHelper) newAEAD() (cipher.AEAD, error) {
+	key := sha256.Sum256([]byte(h.EncryptionKey))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (h *cacheEncryptionHelper) encryptedSize(size int64) int64 {
+	// The last chunk is always present, even if it's empty
+	chunks := size/cacheEncryptionChunkSize + 1
+	return int64(cacheEncryptionHeaderSize) + size + chunks*int64(cacheEncryptionLengthSize+16)
+}
+
+func (h *cacheEncryptionHelper) encrypt(reader io.Reader) (io.Reader, error) {
+	aead, err := h.newAEAD()
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, cacheEncryptionHeaderSize)
+	copy(header, cacheEncryptionMagic)
+	header[len(cacheEncryptionMagic)] = cacheEncryptionVersion
+	_, err = io.ReadFull(rand.Reader, header[len(cacheEncryptionMagic)+1:])
+	if err != nil {
+		return nil, err
+	}
+
+	encrypter := &cacheEncryptingReader{
+		cacheChunkCipher: cacheChunkCipher{aead: aead, header: header},
+		reader:           reader,
+		chunk:            make([]byte, cacheEncryptionChunkSize),
+	}
+	encrypter.buffer.Write(header)
+	return encrypter, nil
+}
+
+func (h *cacheEncryptionHelper) decrypt(reader io.Reader) (*cacheDecryptingReader, error) {
+	aead, err := h.newAEAD()
+	if err != nil {
+		return nil, err
+	}
+
+	decrypter := &cacheDecryptingReader{
+		cacheChunkCipher: cacheChunkCipher{aead: aead},
+		reader:           reader,
+	}
+	return decrypter, nil
+}
+
+type cacheChunkCipher struct {
+	aead    cipher.AEAD
+	header  []byte
+	counter uint32
+}
+
+func (c *cacheChunkCipher) nonce(last bool) []byte {
+	nonce := make([]byte, c.aead.NonceSize())
+	copy(nonce, c.header[len(cacheEncryptionMagic)+1:])
+	binary.BigEndian.PutUint32(nonce[cacheEncryptionNoncePrefixSize:], c.counter)
+	if last {
+		nonce[len(nonce)-1] = 1
+	}
+	return nonce
+}
+
+type cacheEncryptingReader struct {
+	cacheChunkCipher
+	reader io.Reader
+	chunk  []byte
+	buffer bytes.Buffer
+	done   bool
+}
+
+func (r *cacheEncryptingReader) sealChunk() error {
+	n, err := io.ReadFull(r.reader, r.chunk)
+	last := err == io.EOF || err == io.ErrUnexpectedEOF
+	if err != nil && !last {
+		return err
+	}
+
+	ciphertext := r.aead.Seal(nil, r.nonce(last), r.chunk[:n], r.header)
+	binary.Write(&r.buffer, binary.BigEndian, uint32(len(ciphertext)))
+	r.buffer.Write(ciphertext)
+	r.counter++
+	r.done = last
+	return nil
+}
+
+func (r *cacheEncryptingReader) Read(p []byte) (int, error) {
+	for r.buffer.Len() == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.sealChunk(); err != nil {
+			return 0, err
+		}
+	}
+	return r.buffer.Read(p)
+}
+
+type cacheDecryptingReader struct {
+	cacheChunkCipher
+	reader io.Reader
+	buffer bytes.Buffer
+	done   bool
+	failed bool
+}
+
+func (r *cacheDecryptingReader) readHeader() error {
+	header := make([]byte, cacheEncryptionHeaderSize)
+	_, err := io.ReadFull(r.reader, header)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return errCacheVerification
+	} else if err != nil {
+		return err
+	}
+
+	if string(header[:len(cacheEncryptionMagic)]) != cacheEncryptionMagic ||
+		header[len(cacheEncryptionMagic)] != cacheEncryptionVersion {
+		return errCacheVerification
+	}
+	r.header = header
+	return nil
+}
+
+func (r *cacheDecryptingReader) openChunk() error {
+	var length uint32
+	err := binary.Read(r.reader, binary.BigEndian, &length)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		// The archive ended before the last chunk
+		return errCacheVerification
+	} else if err != nil {
+		return err
+	}
+	if length > uint32(cacheEncryptionChunkSize+r.aead.Overhead()) {
+		return errCacheVerification
+	}
+
+	ciphertext := make([]byte, length)
+	_, err = io.ReadFull(r.reader, ciphertext)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return errCacheVerification
+	} else if err != nil {
+		return err
+	}
+
+	last := false
+	plaintext, err := r.aead.Open(nil, r.nonce(false), ciphertext, r.header)
+	if err != nil {
+		last = true
+		plaintext, err = r.aead.Open(nil, r.nonce(true), ciphertext, r.header)
+	}
+	if err != nil {
+		return errCacheVerification
+	}
+
+	r.buffer.Write(plaintext)
+	r.counter++
+	r.done = last
+	return nil
+}
+
+// Read returns only the verified data, failed is set if the archive failed the verification
+func (r *cacheDecryptingReader) Read(p []byte) (n int, err error) {
+	n, err = r.read(p)
+	if err == errCacheVerification {
+		r.failed = true
+	}
+	return
+}
+
+func (r *cacheDecryptingReader) read(p []byte) (int, error) {
+	if r.header == nil {
+		if err := r.readHeader(); err != nil {
+			return 0, err
+		}
+	}
+
+	for r.buffer.Len() == 0 {
+		if r.done {
+			// Nothing is allowed after the last chunk
+			if n, _ := r.reader.Read(make([]byte, 1)); n > 0 {
+				return 0, errCacheVerification
+			}
+			return 0, io.EOF
+		}
+		if err := r.openChunk(); err != nil {
+			return 0, err
+		}
+	}
+	return r.buffer.Read(p)
+}
@@ -0,0 +1,68 @@
+package helpers
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func encryptTestData(t *testing.T, helper cacheEncryptionHelper, data []byte) []byte {
+	reader, err := helper.encrypt(bytes.NewReader(data))
+	require.NoError(t, err)
+	encrypted, err := ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	return encrypted
+}
+
+func decryptTestData(helper cacheEncryptionHelper, data []byte) ([]byte, error) {
+	reader, err := helper.decrypt(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(reader)
+}
+
+func TestCacheEncryptionRoundTrip(t *testing.T) {
+	helper := cacheEncryptionHelper{EncryptionKey: "key"}
+
+	for _, size := range []int{0, 1, cacheEncryptionChunkSize, cacheEncryptionChunkSize + 1, 3 * cacheEncryptionChunkSize} {
+		data := bytes.Repeat([]byte{'a'}, size)
+
+		encrypted := encryptTestData(t, helper, data)
+		assert.Equal(t, helper.encryptedSize(int64(size)), int64(len(encrypted)), "size of %d bytes", size)
+
+		decrypted, err := decryptTestData(helper, encrypted)
+		require.NoError(t, err, "size of %d bytes", size)
+		assert.True(t, bytes.Equal(data, decrypted), "size of %d bytes", size)
+	}
+}
+
+func TestCacheEncryptionVerification(t *testing.T) {
+	helper := cacheEncryptionHelper{EncryptionKey: "key"}
+	data := bytes.Repeat([]byte{'a'}, 2*cacheEncryptionChunkSize)
+	encrypted := encryptTestData(t, helper, data)
+
+	tampered := append([]byte{}, encrypted...)
+	tampered[len(tampered)/2] ^= 1
+
+	truncated := encrypted[:cacheEncryptionHeaderSize+cacheEncryptionLengthSize+cacheEncryptionChunkSize+16]
+
+	tests := map[string][]byte{
+		"tampered":    tampered,
+		"truncated":   truncated,
+		"trailing":    append(append([]byte{}, encrypted...), 'x'),
+		"unencrypted": data,
+		"empty":       []byte{},
+	}
+
+	for name, test := range tests {
+		_, err := decryptTestData(helper, test)
+		assert.Equal(t, errCacheVerification, err, name)
+	}
+
+	_, err := decryptTestData(cacheEncryptionHelper{EncryptionKey: "other"}, encrypted)
+	assert.Equal(t, errCacheVerification, err, "other key")
+}
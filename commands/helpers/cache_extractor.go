@@ -1,10 +1,12 @@
 package helpers
 
 import (
+	"bufio"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"time"
@@ -20,72 +22,207 @@ import (
 
 type CacheExtractorCommand struct {
 	retryHelper
-	File string `long:"file" description:"The file containing your cache artifacts"`
-	URL  string `long:"url" description:"Download artifacts instead of uploading them"`
+	cacheKeyHelper
+	cacheAdapterHelper
+	cacheEncryptionHelper
+	extractionLimitsHelper
+	File   []string `long:"file" description:"The file containing your cache artifacts, following files are used as fallbacks"`
+	URL    []string `long:"url" description:"Download artifacts instead of uploading them, one for each file"`
+	Format string   `long:"format" description:"Format of the archive for the computed key: zip or tar.gz, any format is extracted"`
 }
 
-func (c *CacheExtractorCommand) download() (bool, error) {
-	os.MkdirAll(filepath.Dir(c.File), 0600)
+func (c *CacheExtractorCommand) isUpToDate(cacheFile string, date time.Time) bool {
+	fi, _ := os.Lstat(cacheFile)
+	if fi != nil && !date.After(fi.ModTime()) {
+		logrus.Infoln(filepath.Base(cacheFile), "is up to date")
+		return true
+	}
+	return false
+}
+
+func (c *CacheExtractorCommand) save(cacheFile string, reader io.Reader, date time.Time) (bool, error) {
+	os.MkdirAll(filepath.Dir(cacheFile), 0600)
 
-	file, err := ioutil.TempFile(filepath.Dir(c.File), "cache")
+	file, err := ioutil.TempFile(filepath.Dir(cacheFile), "cache")
 	if err != nil {
 		return false, err
 	}
 	defer file.Close()
 	defer os.Remove(file.Name())
 
-	resp, err := http.Get(c.URL)
-	if err != nil {
+	_, err = io.Copy(file, reader)
+	if err == errCacheVerification {
+		return false, err
+	} else if err != nil {
 		return true, err
 	}
+	os.Chtimes(file.Name(), time.Now(), date)
+
+	err = os.Rename(file.Name(), cacheFile)
+	if err != nil {
+		return false, err
+	}
+	return false, nil
+}
+
+// receive extracts the tar archives while they are read, the zip archives are saved to the cache file first.
+// The encrypted archives are verified in chunks, so only the verified data is extracted.
+func (c *CacheExtractorCommand) receive(cacheFile string, reader io.Reader, date time.Time) (retry bool, extracted bool, err error) {
+	var decrypter *cacheDecryptingReader
+	if c.isEncrypted() {
+		decrypter, err = c.decrypt(reader)
+		if err != nil {
+			return false, false, err
+		}
+		reader = decrypter
+	}
+
+	buffered := bufio.NewReader(reader)
+	switch format, _ := archives.DetectArchiveFormat(buffered); format {
+	case archives.TarGzipArchive:
+		err = archives.ExtractTarGzipArchive(buffered, c.extractionLimits())
+		retry, extracted = !archives.IsExtractionLimitError(err), true
+	default:
+		// The zip archives are read from their end
+		retry, err = c.save(cacheFile, buffered, date)
+	}
+
+	if decrypter != nil && decrypter.failed {
+		return false, extracted, errCacheVerification
+	} else if err != nil {
+		return retry, extracted, err
+	}
+	return false, extracted, nil
+}
+
+// copy reads the cache from the shared directory, without going through HTTP
+func (c *CacheExtractorCommand) copy(cacheFile, sourceFile string) (retry bool, extracted bool, err error) {
+	source, err := os.Open(sourceFile)
+	if err != nil {
+		return false, false, err
+	}
+	defer source.Close()
+
+	fi, err := source.Stat()
+	if err != nil {
+		return false, false, err
+	}
+	if c.isUpToDate(cacheFile, fi.ModTime()) {
+		return false, false, nil
+	}
+
+	logrus.Infoln("Copying", filepath.Base(cacheFile), "from", sourceFile)
+	return c.receive(cacheFile, source, fi.ModTime())
+}
+
+// download returns if the archive was already extracted while it was downloaded
+func (c *CacheExtractorCommand) download(cacheFile, cacheURL string) (retry bool, extracted bool, err error) {
+	if u, err := url.Parse(cacheURL); err == nil && u.Scheme == "file" {
+		return c.copy(cacheFile, url_helpers.FilePath(u))
+	}
+
+	resp, err := http.Get(cacheURL)
+	if err != nil {
+		return true, false, err
+	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode == 404 {
-		return false, os.ErrNotExist
+		return false, false, os.ErrNotExist
 	} else if resp.StatusCode/100 != 2 {
 		// Retry on server errors
 		retry := resp.StatusCode/100 == 5
-		return retry, fmt.Errorf("Received: %s", resp.Status)
+		return retry, false, fmt.Errorf("Received: %s", resp.Status)
 	}
 
-	fi, _ := os.Lstat(c.File)
 	date, _ := time.Parse(http.TimeFormat, resp.Header.Get("Last-Modified"))
-	if fi != nil && !date.After(fi.ModTime()) {
-		logrus.Infoln(filepath.Base(c.File), "is up to date")
-		return false, nil
+	if c.isUpToDate(cacheFile, date) {
+		return false, false, nil
 	}
 
-	logrus.Infoln("Downloading", filepath.Base(c.File), "from", url_helpers.CleanURL(c.URL))
-	_, err = io.Copy(file, resp.Body)
-	if err != nil {
-		return true, err
-	}
-	os.Chtimes(file.Name(), time.Now(), date)
+	logrus.Infoln("Downloading", filepath.Base(cacheFile), "from", url_helpers.CleanURL(cacheURL))
+	return c.receive(cacheFile, resp.Body, date)
+}
 
-	err = os.Rename(file.Name(), c.File)
-	if err != nil {
-		return false, err
+func (c *CacheExtractorCommand) extract(cacheFile, cacheURL string) error {
+	if cacheURL != "" {
+		var extracted bool
+		err := c.doRetry(func() (retry bool, err error) {
+			retry, extracted, err = c.download(cacheFile, cacheURL)
+			return
+		})
+		if err == nil && extracted {
+			return nil
+		} else if err == errCacheVerification {
+			logrus.Warningln("Failed to verify", url_helpers.CleanURL(cacheURL)+", treating it as a cache miss")
+		} else if err != nil && !os.IsNotExist(err) {
+			logrus.Warningln(err)
+		}
 	}
-	return false, nil
+
+	return archives.ExtractArchiveFile(cacheFile, c.extractionLimits())
 }
 
 func (c *CacheExtractorCommand) Execute(context *cli.Context) {
 	formatter.SetRunnerFormatter()
 
+	// The computed key is tried first, the other files are fallbacks
+	if c.hasComputedKey() {
+		format, err := archives.ParseArchiveFormat(c.Format)
+		if err != nil {
+			logrus.Fatalln(err)
+		}
+
+		cacheKey, cacheFile, err := c.computeCacheFile(format)
+		if err != nil {
+			logrus.Fatalln(err)
+		}
+
+		var cacheURL string
+		if c.hasCacheAdapter() {
+			adapter, err := c.getCacheAdapter(cacheKey)
+			if err != nil {
+				logrus.Warningln(err)
+			} else if url := adapter.GetDownloadURL(); url != nil {
+				cacheURL = url.String()
+			}
+		}
+
+		// Each of the fallback files needs an URL too
+		if cacheURL != "" || len(c.URL) != 0 {
+			if len(c.URL) == 0 {
+				c.URL = make([]string, len(c.File))
+			}
+			c.URL = append([]string{cacheURL}, c.URL...)
+		}
+		c.File = append([]string{cacheFile}, c.File...)
+	}
+
 	if len(c.File) == 0 {
 		logrus.Fatalln("Missing cache file")
 	}
+	if len(c.URL) != 0 && len(c.URL) != len(c.File) {
+		logrus.Fatalln("Each cache file requires an URL")
+	}
 
-	if c.URL != "" {
-		err := c.doRetry(c.download)
-		if err != nil && !os.IsNotExist(err) {
-			logrus.Warningln(err)
+	// Use the first cache that is available locally or remotely
+	for idx, cacheFile := range c.File {
+		var cacheURL string
+		if len(c.URL) != 0 {
+			cacheURL = c.URL[idx]
+		}
+
+		err := c.extract(cacheFile, cacheURL)
+		if err == nil {
+			logrus.Infoln("Successfully extracted cache from", cacheFile)
+			return
+		} else if !os.IsNotExist(err) {
+			logrus.Fatalln(err)
 		}
 	}
 
-	err := archives.ExtractZipFile(c.File)
-	if err != nil && !os.IsNotExist(err) {
-		logrus.Fatalln(err)
+	if len(c.File) > 1 {
+		logrus.Infoln("No cache found for any of the keys")
 	}
 }
 
@@ -95,5 +232,6 @@ func init() {
 			Retry:     2,
 			RetryTime: time.Second,
 		},
+		extractionLimitsHelper: defaultExtractionLimitsHelper(),
 	})
 }
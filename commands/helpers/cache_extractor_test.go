@@ -2,15 +2,18 @@ package helpers
 
 import (
 	"archive/zip"
+	"bytes"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 
 	"gitlab.com/gitlab-org/gitlab-ci-multi-runner/helpers"
+	"gitlab.com/gitlab-org/gitlab-ci-multi-runner/helpers/archives"
 	"time"
 )
 
@@ -32,7 +35,7 @@ func TestCacheExtractorValidArchive(t *testing.T) {
 	assert.Error(t, err)
 
 	cmd := CacheExtractorCommand{
-		File: cacheExtractorArchive,
+		File: []string{cacheExtractorArchive},
 	}
 	assert.NotPanics(t, func() {
 		cmd.Execute(nil)
@@ -48,7 +51,7 @@ func TestCacheExtractorForInvalidArchive(t *testing.T) {
 	defer os.Remove(cacheExtractorArchive)
 
 	cmd := CacheExtractorCommand{
-		File: cacheExtractorArchive,
+		File: []string{cacheExtractorArchive},
 	}
 	assert.Panics(t, func() {
 		cmd.Execute(nil)
@@ -66,7 +69,7 @@ func TestCacheExtractorForIfNoFileDefined(t *testing.T) {
 func TestCacheExtractorForNotExistingFile(t *testing.T) {
 	helpers.MakeFatalToPanic()
 	cmd := CacheExtractorCommand{
-		File: "/../../../test.zip",
+		File: []string{"/../../../test.zip"},
 	}
 	assert.NotPanics(t, func() {
 		cmd.Execute(nil)
@@ -95,8 +98,8 @@ func TestCacheExtractorRemoteServerNotFound(t *testing.T) {
 
 	helpers.MakeFatalToPanic()
 	cmd := CacheExtractorCommand{
-		File: "non-existing-test.zip",
-		URL:  ts.URL + "/invalid-file.zip",
+		File: []string{"non-existing-test.zip"},
+		URL:  []string{ts.URL + "/invalid-file.zip"},
 	}
 	assert.NotPanics(t, func() {
 		cmd.Execute(nil)
@@ -116,8 +119,8 @@ func TestCacheExtractorRemoteServer(t *testing.T) {
 
 	helpers.MakeFatalToPanic()
 	cmd := CacheExtractorCommand{
-		File: cacheExtractorArchive,
-		URL:  ts.URL + "/cache.zip",
+		File: []string{cacheExtractorArchive},
+		URL:  []string{ts.URL + "/cache.zip"},
 	}
 	assert.NotPanics(t, func() {
 		cmd.Execute(nil)
@@ -136,8 +139,8 @@ func TestCacheExtractorRemoteServerDoesntFailOnInvalidServer(t *testing.T) {
 	helpers.MakeFatalToPanic()
 	os.Remove(cacheExtractorArchive)
 	cmd := CacheExtractorCommand{
-		File: cacheExtractorArchive,
-		URL:  "http://localhost:65333/cache.zip",
+		File: []string{cacheExtractorArchive},
+		URL:  []string{"http://localhost:65333/cache.zip"},
 	}
 	assert.NotPanics(t, func() {
 		cmd.Execute(nil)
@@ -146,3 +149,174 @@ func TestCacheExtractorRemoteServerDoesntFailOnInvalidServer(t *testing.T) {
 	_, err := os.Stat(cacheExtractorTestArchivedFile)
 	assert.Error(t, err)
 }
+
+func TestCacheExtractorFallbackFile(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(testServeCache))
+	defer ts.Close()
+
+	defer os.Remove(cacheExtractorArchive)
+	defer os.Remove(cacheExtractorTestArchivedFile)
+	os.Remove(cacheExtractorArchive)
+	os.Remove(cacheExtractorTestArchivedFile)
+
+	helpers.MakeFatalToPanic()
+	cmd := CacheExtractorCommand{
+		File: []string{"non-existing-test.zip", cacheExtractorArchive},
+		URL:  []string{ts.URL + "/invalid-file.zip", ts.URL + "/cache.zip"},
+	}
+	assert.NotPanics(t, func() {
+		cmd.Execute(nil)
+	})
+
+	_, err := os.Stat(cacheExtractorTestArchivedFile)
+	assert.NoError(t, err)
+}
+
+func TestCacheExtractorForMismatchedURLs(t *testing.T) {
+	helpers.MakeFatalToPanic()
+	cmd := CacheExtractorCommand{
+		File: []string{"first.zip", "second.zip"},
+		URL:  []string{"http://localhost:65333/cache.zip"},
+	}
+	assert.Panics(t, func() {
+		cmd.Execute(nil)
+	})
+}
+
+func TestCacheExtractorSharedDirectory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "shared-cache")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	file, err := os.Create(filepath.Join(dir, "cache.zip"))
+	assert.NoError(t, err)
+	archive := zip.NewWriter(file)
+	archive.Create(cacheExtractorTestArchivedFile)
+	archive.Close()
+	file.Close()
+
+	defer os.Remove(cacheExtractorArchive)
+	defer os.Remove(cacheExtractorTestArchivedFile)
+	os.Remove(cacheExtractorArchive)
+	os.Remove(cacheExtractorTestArchivedFile)
+
+	helpers.MakeFatalToPanic()
+	cmd := CacheExtractorCommand{
+		File: []string{cacheExtractorArchive},
+		URL:  []string{"file://" + filepath.ToSlash(filepath.Join(dir, "cache.zip"))},
+	}
+	assert.NotPanics(t, func() {
+		cmd.Execute(nil)
+	})
+
+	_, err = os.Stat(cacheExtractorTestArchivedFile)
+	assert.NoError(t, err)
+}
+
+func TestCacheExtractorUnverifiedArchiveIsCacheMiss(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(testServeCache))
+	defer ts.Close()
+
+	defer os.Remove(cacheExtractorArchive)
+	defer os.Remove(cacheExtractorTestArchivedFile)
+	os.Remove(cacheExtractorArchive)
+	os.Remove(cacheExtractorTestArchivedFile)
+
+	helpers.MakeFatalToPanic()
+	cmd := CacheExtractorCommand{
+		File: []string{cacheExtractorArchive},
+		URL:  []string{ts.URL + "/cache.zip"},
+		cacheEncryptionHelper: cacheEncryptionHelper{
+			EncryptionKey: "key",
+		},
+	}
+	assert.NotPanics(t, func() {
+		cmd.Execute(nil)
+	})
+
+	_, err := os.Stat(cacheExtractorTestArchivedFile)
+	assert.True(t, os.IsNotExist(err), "unencrypted archive should not be extracted")
+}
+
+func testCacheExtractorStreamsArchive(t *testing.T, format archives.ArchiveFormat, encryption cacheEncryptionHelper) {
+	ioutil.WriteFile(cacheExtractorTestArchivedFile, nil, 0600)
+	options := archives.DefaultArchiveOptions()
+	options.Format = format
+
+	archive := bytes.NewBuffer(nil)
+	err := archives.CreateArchive(archive, []string{cacheExtractorTestArchivedFile}, options)
+	assert.NoError(t, err)
+	os.Remove(cacheExtractorTestArchivedFile)
+
+	data := archive.Bytes()
+	if encryption.isEncrypted() {
+		data = encryptTestData(t, encryption, data)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(data)
+	}))
+	defer ts.Close()
+
+	defer os.Remove(cacheExtractorArchive)
+	defer os.Remove(cacheExtractorTestArchivedFile)
+	os.Remove(cacheExtractorArchive)
+
+	helpers.MakeFatalToPanic()
+	cmd := CacheExtractorCommand{
+		File:                  []string{cacheExtractorArchive},
+		URL:                   []string{ts.URL + "/cache." + string(format)},
+		cacheEncryptionHelper: encryption,
+	}
+	assert.NotPanics(t, func() {
+		cmd.Execute(nil)
+	})
+
+	_, err = os.Stat(cacheExtractorTestArchivedFile)
+	assert.NoError(t, err)
+	_, err = os.Stat(cacheExtractorArchive)
+	assert.True(t, os.IsNotExist(err), "archive should be extracted while it's downloaded")
+}
+
+func TestCacheExtractorStreamsTarGzipArchive(t *testing.T) {
+	testCacheExtractorStreamsArchive(t, archives.TarGzipArchive, cacheEncryptionHelper{})
+}
+
+func TestCacheExtractorStreamsEncryptedArchive(t *testing.T) {
+	testCacheExtractorStreamsArchive(t, archives.TarGzipArchive, cacheEncryptionHelper{EncryptionKey: "key"})
+}
+
+func TestCacheExtractorTruncatedEncryptedArchiveIsCacheMiss(t *testing.T) {
+	encryption := cacheEncryptionHelper{EncryptionKey: "key"}
+	options := archives.DefaultArchiveOptions()
+	options.Format = archives.TarGzipArchive
+
+	ioutil.WriteFile(cacheExtractorTestArchivedFile, nil, 0600)
+	archive := bytes.NewBuffer(nil)
+	err := archives.CreateArchive(archive, []string{cacheExtractorTestArchivedFile}, options)
+	assert.NoError(t, err)
+	os.Remove(cacheExtractorTestArchivedFile)
+
+	// The archive misses its last chunk
+	data := encryptTestData(t, encryption, archive.Bytes())
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(data[:len(data)-1])
+	}))
+	defer ts.Close()
+
+	defer os.Remove(cacheExtractorArchive)
+	defer os.Remove(cacheExtractorTestArchivedFile)
+	os.Remove(cacheExtractorArchive)
+
+	helpers.MakeFatalToPanic()
+	cmd := CacheExtractorCommand{
+		File:                  []string{cacheExtractorArchive},
+		URL:                   []string{ts.URL + "/cache.tar.gz"},
+		cacheEncryptionHelper: encryption,
+	}
+	assert.NotPanics(t, func() {
+		cmd.Execute(nil)
+	})
+
+	_, err = os.Stat(cacheExtractorTestArchivedFile)
+	assert.True(t, os.IsNotExist(err), "unverified data should not be extracted")
+}
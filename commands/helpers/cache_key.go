@@ -0,0 +1,75 @@
+package helpers
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+
+	"github.com/Sirupsen/logrus"
+
+	"gitlab.com/gitlab-org/gitlab-ci-multi-runner/helpers/archives"
+)
+
+type cacheKeyHelper struct {
+	KeyFiles  []string `long:"key-file" description:"Compute the cache key from the checksum of this file, can be repeated"`
+	KeyPrefix string   `long:"key-prefix" description:"Prefix prepended to the computed cache key"`
+	CacheDir  string   `long:"cache-dir" description:"Directory where the cache for the computed key is stored"`
+}
+
+func (h *cacheKeyHelper) hasComputedKey() bool {
+	return len(h.KeyFiles) > 0
+}
+
+func (h *cacheKeyHelper) checksumFile(fileName string) (string, error) {
+	file, err := os.Open(fileName)
+	if os.IsNotExist(err) {
+		// Missing files are part of the key too
+		return "missing", nil
+	} else if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	_, err = io.Copy(hash, file)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", hash.Sum(nil)), nil
+}
+
+func (h *cacheKeyHelper) computeKey() (string, error) {
+	hash := sha256.New()
+	for _, fileName := range h.KeyFiles {
+		checksum, err := h.checksumFile(fileName)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(hash, "%s %s\n", fileName, checksum)
+	}
+
+	key := fmt.Sprintf("%x", hash.Sum(nil))
+	if h.KeyPrefix != "" {
+		key = h.KeyPrefix + "-" + key
+	}
+	return key, nil
+}
+
+// computeCacheFile returns the cache archive for the computed key,
+// it uses the same <key>/cache.<format> layout as the caches with a key known upfront
+func (h *cacheKeyHelper) computeCacheFile(format archives.ArchiveFormat) (key string, file string, err error) {
+	if h.CacheDir == "" {
+		return "", "", errors.New("missing --cache-dir for the computed cache key")
+	}
+
+	key, err = h.computeKey()
+	if err != nil {
+		return "", "", err
+	}
+
+	logrus.Infoln("Using cache key", key)
+	return key, path.Join(h.CacheDir, key, "cache."+string(format)), nil
+}
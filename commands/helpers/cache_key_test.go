@@ -0,0 +1,66 @@
+package helpers
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-ci-multi-runner/helpers/archives"
+)
+
+func TestCacheKeyFromFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cache-key")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	lockFile := filepath.Join(dir, "Gemfile.lock")
+	require.NoError(t, ioutil.WriteFile(lockFile, []byte("rake (10.0)"), 0600))
+
+	h := cacheKeyHelper{
+		KeyFiles:  []string{lockFile},
+		KeyPrefix: "rspec",
+		CacheDir:  "cache",
+	}
+
+	key, err := h.computeKey()
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(key, "rspec-"))
+
+	sameKey, err := h.computeKey()
+	require.NoError(t, err)
+	assert.Equal(t, key, sameKey)
+
+	require.NoError(t, ioutil.WriteFile(lockFile, []byte("rake (11.0)"), 0600))
+	changedKey, err := h.computeKey()
+	require.NoError(t, err)
+	assert.NotEqual(t, key, changedKey)
+
+	cacheKey, cacheFile, err := h.computeCacheFile(archives.ZipArchive)
+	require.NoError(t, err)
+	assert.Equal(t, changedKey, cacheKey)
+	assert.Equal(t, "cache/"+changedKey+"/cache.zip", cacheFile)
+}
+
+func TestCacheKeyFromMissingFile(t *testing.T) {
+	h := cacheKeyHelper{
+		KeyFiles: []string{"not-existing.lock"},
+	}
+
+	key, err := h.computeKey()
+	assert.NoError(t, err)
+	assert.NotEmpty(t, key)
+}
+
+func TestCacheKeyRequiresCacheDir(t *testing.T) {
+	h := cacheKeyHelper{
+		KeyFiles: []string{"not-existing.lock"},
+	}
+
+	_, _, err := h.computeCacheFile(archives.ZipArchive)
+	assert.Error(t, err)
+}
@@ -17,12 +17,14 @@ import (
 )
 
 type fileArchiver struct {
-	Paths     []string `long:"path" description:"Add paths to archive"`
+	Paths     []string `long:"path" description:"Add paths to archive, ** matches any number of directories"`
+	Exclude   []string `long:"exclude" description:"Exclude paths from archive, ** matches any number of directories"`
 	Untracked bool     `long:"untracked" description:"Add git untracked files"`
 	Verbose   bool     `long:"verbose" description:"Detailed information"`
 
-	wd    string
-	files map[string]os.FileInfo
+	wd       string
+	files    map[string]os.FileInfo
+	excluded map[string]int
 }
 
 func (c *fileArchiver) isChanged(modTime time.Time) bool {
@@ -71,7 +73,21 @@ func (c *fileArchiver) add(path string) (err error) {
 	return
 }
 
-func (c *fileArchiver) process(match string) bool {
+// isExcluded checks the path and its parent directories, so excluding a directory excludes its content
+func (c *fileArchiver) isExcluded(path string) bool {
+	for _, pattern := range c.Exclude {
+		for name := path; name != "." && name != "/"; name = filepath.Dir(name) {
+			if matched, _ := matchGlob(pattern, name); matched {
+				c.excluded[pattern]++
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// process adds the path to the archive, it returns if the path was added and if it was excluded
+func (c *fileArchiver) process(match string) (added bool, excluded bool) {
 	var absolute, relative string
 	var err error
 
@@ -83,36 +99,51 @@ func (c *fileArchiver) process(match string) bool {
 	if err == nil {
 		// Process path only if it lives in our build directory
 		if !strings.HasPrefix(relative, ".."+string(filepath.Separator)) {
+			if c.isExcluded(relative) {
+				return false, true
+			}
 			err = c.add(relative)
 		} else {
 			err = errors.New("not supported: outside build directory")
 		}
 	}
 	if err == nil {
-		return true
+		return true, false
 	} else if os.IsNotExist(err) {
 		// We hide the error that file doesn't exist
-		return false
+		return false, false
 	}
 
 	logrus.Warningf("%s: %v", match, err)
-	return false
+	return false, false
+}
+
+func (c *fileArchiver) excludedCount() (count int) {
+	for _, excluded := range c.excluded {
+		count += excluded
+	}
+	return
 }
 
 func (c *fileArchiver) processPaths() {
 	for _, path := range c.Paths {
-		matches, err := filepath.Glob(path)
+		matches, err := expandGlob(path)
 		if err != nil {
 			logrus.Warningf("%s: %v", path, err)
 			continue
 		}
 
 		found := 0
+		excluded := c.excludedCount()
 
 		for _, match := range matches {
 			err := filepath.Walk(match, func(path string, info os.FileInfo, err error) error {
-				if c.process(path) {
+				added, excluded := c.process(path)
+				if added {
 					found++
+				} else if excluded && info != nil && info.IsDir() {
+					// The content of the excluded directory is excluded too, there is no need to walk it
+					return filepath.SkipDir
 				}
 				return nil
 			})
@@ -121,14 +152,27 @@ func (c *fileArchiver) processPaths() {
 			}
 		}
 
-		if found == 0 {
+		excluded = c.excludedCount() - excluded
+		if found == 0 && excluded == 0 {
 			logrus.Warningf("%s: no matching files", path)
+		} else if excluded > 0 {
+			logrus.Infof("%s: found %d matching files, %d excluded", path, found, excluded)
 		} else {
 			logrus.Infof("%s: found %d matching files", path, found)
 		}
 	}
 }
 
+func (c *fileArchiver) processExcludes() {
+	for _, pattern := range c.Exclude {
+		if _, err := matchGlob(pattern, ""); err != nil {
+			logrus.Warningf("%s: %v", pattern, err)
+		} else {
+			logrus.Infof("%s: excluded %d paths", pattern, c.excluded[pattern])
+		}
+	}
+}
+
 func (c *fileArchiver) processUntracked() {
 	if !c.Untracked {
 		return
@@ -153,7 +197,7 @@ func (c *fileArchiver) processUntracked() {
 				logrus.Warningln(err)
 				break
 			}
-			if c.process(string(line)) {
+			if added, _ := c.process(string(line)); added {
 				found++
 			}
 		}
@@ -176,8 +220,10 @@ func (c *fileArchiver) enumerate() error {
 
 	c.wd = wd
 	c.files = make(map[string]os.FileInfo)
+	c.excluded = make(map[string]int)
 
 	c.processPaths()
 	c.processUntracked()
+	c.processExcludes()
 	return nil
 }
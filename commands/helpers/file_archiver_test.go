@@ -3,6 +3,7 @@ package helpers
 import (
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -90,3 +91,29 @@ func TestFileArchiverChanged(t *testing.T) {
 	assert.True(t, f.isFileChanged(fileArchiverOtherFile), "should return true if file was modified")
 	assert.True(t, f.isFileChanged(fileArchiverNotExistingFile), "should return true if file doesn't exist")
 }
+
+func TestFileArchiverRecursiveGlobAndExclude(t *testing.T) {
+	dir, err := ioutil.TempDir("", "file-archiver")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	wd, err := os.Getwd()
+	assert.NoError(t, err)
+	assert.NoError(t, os.Chdir(dir))
+	defer os.Chdir(wd)
+
+	for _, file := range []string{"build/app", "build/obj/main.o", "build/obj/x86/util.o", "build/tmp/cache", "src/main.c"} {
+		os.MkdirAll(filepath.Dir(file), 0700)
+		ioutil.WriteFile(file, nil, 0600)
+	}
+
+	f := fileArchiver{
+		Paths:   []string{"build/", "**/*.c"},
+		Exclude: []string{"build/**/*.o", "build/tmp"},
+	}
+	err = f.enumerate()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"build", "build/app", "build/obj", "build/obj/x86", "src/main.c"}, f.sortedFiles())
+	assert.Equal(t, 2, f.excluded["build/**/*.o"])
+	assert.Equal(t, 1, f.excluded["build/tmp"], "the excluded directory should not be walked")
+}
@@ -0,0 +1,161 @@
+package helpers
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/codegangsta/cli"
+
+	"gitlab.com/gitlab-org/gitlab-ci-multi-runner/common"
+	"gitlab.com/gitlab-org/gitlab-ci-multi-runner/helpers/formatter"
+	"gitlab.com/gitlab-org/gitlab-ci-multi-runner/helpers/url"
+)
+
+// Locks older than that are left by builds that were killed during the update
+const gitMirrorStaleLockAge = time.Hour
+
+// The held lock is touched more often than it gets stale, so a long fetch keeps it
+var gitMirrorLockRefreshInterval = gitMirrorStaleLockAge / 4
+
+type GitMirrorUpdaterCommand struct {
+	Dir         string        `long:"dir" description:"The directory containing the bare mirror of the repository"`
+	URL         string        `long:"url" description:"The URL of the mirrored repository"`
+	LockTimeout time.Duration `long:"lock-timeout" description:"How long to wait for other builds updating the mirror"`
+
+	stopRefresh chan struct{}
+	refreshDone chan struct{}
+}
+
+func (c *GitMirrorUpdaterCommand) lockFile() string {
+	return c.Dir + ".lock"
+}
+
+func (c *GitMirrorUpdaterCommand) lock() error {
+	os.MkdirAll(filepath.Dir(c.Dir), 0700)
+
+	started := time.Now()
+	for {
+		file, err := os.OpenFile(c.lockFile(), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			file.Close()
+			c.stopRefresh = make(chan struct{})
+			c.refreshDone = make(chan struct{})
+			go c.refreshLock()
+			return nil
+		} else if !os.IsExist(err) {
+			return err
+		}
+
+		if fi, err := os.Stat(c.lockFile()); err == nil && time.Since(fi.ModTime()) > gitMirrorStaleLockAge {
+			logrus.Warningln("Removing stale lock", c.lockFile())
+			os.Remove(c.lockFile())
+			continue
+		}
+
+		if time.Since(started) >= c.LockTimeout {
+			return errors.New("timed out waiting for " + c.lockFile())
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// refreshLock updates the modification time of the held lock, until it's unlocked
+func (c *GitMirrorUpdaterCommand) refreshLock() {
+	defer close(c.refreshDone)
+
+	ticker := time.NewTicker(gitMirrorLockRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.stopRefresh:
+			return
+		case now := <-ticker.C:
+			os.Chtimes(c.lockFile(), now, now)
+		}
+	}
+}
+
+func (c *GitMirrorUpdaterCommand) unlock() {
+	close(c.stopRefresh)
+	<-c.refreshDone
+	os.Remove(c.lockFile())
+}
+
+func (c *GitMirrorUpdaterCommand) git(args ...string) error {
+	cmd := exec.Command("git", args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+func (c *GitMirrorUpdaterCommand) fetch(gitDir string) error {
+	// Fetch from the URL directly, so credentials are not stored in the mirror
+	return c.git("--git-dir", gitDir, "fetch", "--quiet", "--prune", c.URL,
+		"+refs/heads/*:refs/heads/*", "+refs/tags/*:refs/tags/*")
+}
+
+func (c *GitMirrorUpdaterCommand) create() error {
+	tempDir, err := ioutil.TempDir(filepath.Dir(c.Dir), "mirror")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tempDir)
+
+	err = c.git("init", "--quiet", "--bare", tempDir)
+	if err != nil {
+		return err
+	}
+
+	err = c.fetch(tempDir)
+	if err != nil {
+		return err
+	}
+
+	// Make the mirror visible to other builds only when it's complete
+	return os.Rename(tempDir, c.Dir)
+}
+
+func (c *GitMirrorUpdaterCommand) update() error {
+	err := c.lock()
+	if err != nil {
+		return err
+	}
+	defer c.unlock()
+
+	if _, err := os.Stat(c.Dir); os.IsNotExist(err) {
+		logrus.Infoln("Creating mirror of", url_helpers.CleanURL(c.URL))
+		return c.create()
+	}
+
+	logrus.Infoln("Fetching changes from", url_helpers.CleanURL(c.URL))
+	return c.fetch(c.Dir)
+}
+
+func (c *GitMirrorUpdaterCommand) Execute(context *cli.Context) {
+	formatter.SetRunnerFormatter()
+
+	if len(c.Dir) == 0 {
+		logrus.Fatalln("Missing mirror directory")
+	}
+	if len(c.URL) == 0 {
+		logrus.Fatalln("Missing repository URL")
+	}
+
+	// The build can still clone without the mirror
+	err := c.update()
+	if err != nil {
+		logrus.Warningln("Failed to update git mirror:", err)
+	}
+}
+
+func init() {
+	common.RegisterCommand2("git-mirror-updater", "update the bare mirror of the repository (internal)", &GitMirrorUpdaterCommand{
+		LockTimeout: 10 * time.Minute,
+	})
+}
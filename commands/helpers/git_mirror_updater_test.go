@@ -0,0 +1,117 @@
+package helpers
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-ci-multi-runner/helpers"
+)
+
+func TestGitMirrorUpdaterLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "git-mirror")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	cmd := GitMirrorUpdaterCommand{
+		Dir: filepath.Join(dir, "mirror.git"),
+	}
+	require.NoError(t, cmd.lock())
+
+	other := cmd
+	assert.Error(t, other.lock(), "lock should be held by the first command")
+
+	cmd.unlock()
+	assert.NoError(t, other.lock())
+	other.unlock()
+}
+
+func TestGitMirrorUpdaterStaleLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "git-mirror")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	cmd := GitMirrorUpdaterCommand{
+		Dir: filepath.Join(dir, "mirror.git"),
+	}
+	require.NoError(t, ioutil.WriteFile(cmd.lockFile(), nil, 0600))
+
+	staleTime := time.Now().Add(-gitMirrorStaleLockAge - time.Minute)
+	require.NoError(t, os.Chtimes(cmd.lockFile(), staleTime, staleTime))
+
+	assert.NoError(t, cmd.lock())
+	cmd.unlock()
+}
+
+func TestGitMirrorUpdaterRefreshesHeldLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "git-mirror")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	defaultInterval := gitMirrorLockRefreshInterval
+	gitMirrorLockRefreshInterval = 10 * time.Millisecond
+	defer func() { gitMirrorLockRefreshInterval = defaultInterval }()
+
+	cmd := GitMirrorUpdaterCommand{
+		Dir: filepath.Join(dir, "mirror.git"),
+	}
+	require.NoError(t, cmd.lock())
+	defer cmd.unlock()
+
+	// Pretend the fetch is running for longer than the stale lock age
+	staleTime := time.Now().Add(-gitMirrorStaleLockAge - time.Minute)
+	require.NoError(t, os.Chtimes(cmd.lockFile(), staleTime, staleTime))
+	time.Sleep(100 * time.Millisecond)
+
+	other := GitMirrorUpdaterCommand{
+		Dir: cmd.Dir,
+	}
+	assert.Error(t, other.lock(), "the held lock shouldn't be removed as stale")
+}
+
+func TestGitMirrorUpdaterForMissingDir(t *testing.T) {
+	helpers.MakeFatalToPanic()
+	cmd := GitMirrorUpdaterCommand{
+		URL: "https://gitlab.com/gitlab-org/gitlab-test.git",
+	}
+	assert.Panics(t, func() {
+		cmd.Execute(nil)
+	})
+}
+
+func TestGitMirrorUpdaterCreateAndFetch(t *testing.T) {
+	if helpers.SkipIntegrationTests(t, "git", "--version") {
+		return
+	}
+
+	dir, err := ioutil.TempDir("", "git-mirror")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	repoDir := filepath.Join(dir, "repo")
+	for _, args := range [][]string{
+		{"init", "--quiet", repoDir},
+		{"-C", repoDir, "-c", "user.name=test", "-c", "user.email=test@example.com",
+			"commit", "--quiet", "--allow-empty", "-m", "initial"},
+	} {
+		require.NoError(t, exec.Command("git", args...).Run())
+	}
+
+	cmd := GitMirrorUpdaterCommand{
+		Dir: filepath.Join(dir, "mirror.git"),
+		URL: repoDir,
+	}
+	require.NoError(t, cmd.update())
+	_, err = os.Stat(filepath.Join(cmd.Dir, "HEAD"))
+	assert.NoError(t, err)
+
+	assert.NoError(t, cmd.update())
+	_, err = os.Stat(cmd.lockFile())
+	assert.True(t, os.IsNotExist(err), "lock should be released")
+}
@@ -0,0 +1,99 @@
+package helpers
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// globRecursive is the path segment matching any number of directories
+const globRecursive = "**"
+
+func hasGlobMeta(segment string) bool {
+	return strings.ContainsAny(segment, "*?[")
+}
+
+func matchGlobSegments(patterns, names []string) (bool, error) {
+	for len(patterns) > 0 {
+		if patterns[0] == globRecursive {
+			for i := 0; i <= len(names); i++ {
+				matched, err := matchGlobSegments(patterns[1:], names[i:])
+				if matched || err != nil {
+					return matched, err
+				}
+			}
+			return false, nil
+		}
+
+		if len(names) == 0 {
+			return false, nil
+		}
+
+		matched, err := path.Match(patterns[0], names[0])
+		if !matched || err != nil {
+			return false, err
+		}
+		patterns, names = patterns[1:], names[1:]
+	}
+	return len(names) == 0, nil
+}
+
+// matchGlob works like path.Match, but the ** segment matches any number of directories
+func matchGlob(pattern, name string) (bool, error) {
+	pattern = path.Clean(filepath.ToSlash(pattern))
+	name = path.Clean(filepath.ToSlash(name))
+	return matchGlobSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+// expandGlob works like filepath.Glob, but supports the ** segment. The directories
+// matched by the ** patterns are returned without their content.
+func expandGlob(pattern string) ([]string, error) {
+	if !strings.Contains(pattern, globRecursive) {
+		return filepath.Glob(pattern)
+	}
+
+	// Validate the pattern before walking the files
+	if _, err := matchGlob(pattern, ""); err != nil {
+		return nil, err
+	}
+
+	// Walk only the directory before the first segment with the wildcards
+	segments := strings.Split(path.Clean(filepath.ToSlash(pattern)), "/")
+	var base []string
+	for _, segment := range segments {
+		if hasGlobMeta(segment) {
+			break
+		}
+		base = append(base, segment)
+	}
+
+	root := strings.Join(base, "/")
+	if root == "" && len(base) > 0 {
+		root = "/"
+	} else if root == "" {
+		root = "."
+	}
+
+	var matches []string
+	err := filepath.Walk(filepath.FromSlash(root), func(fileName string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+
+		matched, _ := matchGlob(pattern, fileName)
+		if !matched {
+			return nil
+		}
+
+		matches = append(matches, fileName)
+		if info.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		err = nil
+	}
+	return matches, err
+}
@@ -0,0 +1,34 @@
+package helpers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		matched bool
+	}{
+		{"build/*.o", "build/main.o", true},
+		{"build/*.o", "build/obj/main.o", false},
+		{"build/**/*.o", "build/main.o", true},
+		{"build/**/*.o", "build/obj/x86/main.o", true},
+		{"build/**/*.o", "src/main.o", false},
+		{"**/node_modules", "node_modules", true},
+		{"**/node_modules", "web/app/node_modules", true},
+		{"build/**", "build/bin/app", true},
+		{"./build/**", "build/bin/app", true},
+	}
+
+	for _, test := range tests {
+		matched, err := matchGlob(test.pattern, test.name)
+		assert.NoError(t, err)
+		assert.Equal(t, test.matched, matched, "%s for %s", test.pattern, test.name)
+	}
+
+	_, err := matchGlob("build/[", "build/main.o")
+	assert.Error(t, err)
+}
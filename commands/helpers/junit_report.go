@@ -0,0 +1,207 @@
+package helpers
+
+import (
+	"encoding/xml"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/codegangsta/cli"
+
+	"gitlab.com/gitlab-org/gitlab-ci-multi-runner/common"
+	"gitlab.com/gitlab-org/gitlab-ci-multi-runner/helpers/formatter"
+)
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      float64       `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure"`
+	Error     *junitFailure `xml:"error"`
+	Skipped   *struct{}     `xml:"skipped"`
+}
+
+func (c *junitTestCase) String() string {
+	if c.ClassName == "" {
+		return c.Name
+	}
+	return c.ClassName + "." + c.Name
+}
+
+// junitTestSuite is used for both <testsuites> and <testsuite>, they can be nested
+type junitTestSuite struct {
+	Name       string           `xml:"name,attr"`
+	TestCases  []junitTestCase  `xml:"testcase"`
+	TestSuites []junitTestSuite `xml:"testsuite"`
+}
+
+type junitReport struct {
+	tests   []junitTestCase
+	failed  []junitTestCase
+	errors  int
+	skipped int
+	time    float64
+}
+
+func (r *junitReport) addSuite(suite *junitTestSuite) {
+	for _, testCase := range suite.TestCases {
+		r.tests = append(r.tests, testCase)
+		r.time += testCase.Time
+
+		if testCase.Error != nil {
+			r.errors++
+			r.failed = append(r.failed, testCase)
+		} else if testCase.Failure != nil {
+			r.failed = append(r.failed, testCase)
+		} else if testCase.Skipped != nil {
+			r.skipped++
+		}
+	}
+
+	for idx := range suite.TestSuites {
+		r.addSuite(&suite.TestSuites[idx])
+	}
+}
+
+func (r *junitReport) addFile(fileName string) error {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var suite junitTestSuite
+	err = xml.NewDecoder(file).Decode(&suite)
+	if err != nil {
+		return err
+	}
+
+	r.addSuite(&suite)
+	return nil
+}
+
+func (r *junitReport) slowest(count int) []junitTestCase {
+	tests := make([]junitTestCase, len(r.tests))
+	copy(tests, r.tests)
+	sort.Stable(junitTestCasesByTime(tests))
+
+	if len(tests) > count {
+		tests = tests[:count]
+	}
+	return tests
+}
+
+type junitTestCasesByTime []junitTestCase
+
+func (s junitTestCasesByTime) Len() int           { return len(s) }
+func (s junitTestCasesByTime) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s junitTestCasesByTime) Less(i, j int) bool { return s[i].Time > s[j].Time }
+
+// failureLines returns the first lines of the failure message and its details
+func failureLines(failure *junitFailure, count int) []string {
+	var lines []string
+	for _, text := range []string{failure.Message, failure.Text} {
+		for _, line := range strings.Split(strings.TrimSpace(text), "\n") {
+			line = strings.TrimRight(line, " \t\r")
+			if line == "" || len(lines) > 0 && lines[len(lines)-1] == line {
+				continue
+			}
+			lines = append(lines, line)
+		}
+	}
+
+	if len(lines) > count {
+		lines = append(lines[:count], "...")
+	}
+	return lines
+}
+
+type JUnitReportCommand struct {
+	Paths        []string `long:"path" description:"JUnit XML reports to parse, ** matches any number of directories"`
+	MaxFailures  int      `long:"max-failures" description:"How many failed tests are listed"`
+	FailureLines int      `long:"failure-lines" description:"How many lines of the failure message are shown"`
+	Slowest      int      `long:"slowest" description:"How many of the slowest tests are listed"`
+}
+
+func (c *JUnitReportCommand) parse() (report junitReport, files int) {
+	for _, path := range c.Paths {
+		matches, err := expandGlob(path)
+		if err != nil {
+			logrus.Warningf("%s: %v", path, err)
+			continue
+		} else if len(matches) == 0 {
+			logrus.Warningf("%s: no matching files", path)
+			continue
+		}
+
+		for _, match := range matches {
+			err := report.addFile(match)
+			if err != nil {
+				logrus.Warningf("%s: %v", match, err)
+				continue
+			}
+			files++
+		}
+	}
+	return
+}
+
+func (c *JUnitReportCommand) print(report *junitReport, files int) {
+	logrus.Infof("JUnit report: %d tests, %d failed, %d errors, %d skipped in %.2fs (%d files)",
+		len(report.tests), len(report.failed)-report.errors, report.errors, report.skipped, report.time, files)
+
+	if len(report.failed) > 0 {
+		logrus.Infoln("Failed tests:")
+	}
+	for idx, testCase := range report.failed {
+		if idx >= c.MaxFailures {
+			logrus.Infof("  ... and %d more", len(report.failed)-idx)
+			break
+		}
+
+		failure := testCase.Failure
+		if testCase.Error != nil {
+			failure = testCase.Error
+		}
+
+		logrus.Warningf("%s (%.2fs)", testCase.String(), testCase.Time)
+		for _, line := range failureLines(failure, c.FailureLines) {
+			logrus.Infoln("    " + line)
+		}
+	}
+
+	if c.Slowest > 0 && len(report.tests) > 0 {
+		logrus.Infoln("Slowest tests:")
+		for _, testCase := range report.slowest(c.Slowest) {
+			logrus.Infof("  %8.2fs %s", testCase.Time, testCase.String())
+		}
+	}
+}
+
+// Execute never fails, the reports only give feedback about the build
+func (c *JUnitReportCommand) Execute(context *cli.Context) {
+	formatter.SetRunnerFormatter()
+
+	report, files := c.parse()
+	if files == 0 {
+		logrus.Warningln("No JUnit reports found")
+		return
+	}
+
+	c.print(&report, files)
+}
+
+func init() {
+	common.RegisterCommand2("junit-report", "print the summary of JUnit reports (internal)", &JUnitReportCommand{
+		MaxFailures:  20,
+		FailureLines: 5,
+		Slowest:      5,
+	})
+}
@@ -0,0 +1,68 @@
+package helpers
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const junitTestReport = `<?xml version="1.0" encoding="UTF-8"?>
+<testsuites>
+  <testsuite name="math">
+    <testcase classname="math" name="TestAdd" time="0.5"/>
+    <testcase classname="math" name="TestSub" time="2.25">
+      <failure message="expected 1, got 2">math_test.go:10
+math_test.go:10
+math_test.go:12</failure>
+    </testcase>
+    <testsuite name="nested">
+      <testcase classname="math.nested" name="TestDiv" time="1">
+        <error message="panic: division by zero"/>
+      </testcase>
+      <testcase classname="math.nested" name="TestMul" time="0">
+        <skipped/>
+      </testcase>
+    </testsuite>
+  </testsuite>
+</testsuites>
+`
+
+func writeTestJUnitReport(t *testing.T, fileName string, data string) {
+	err := ioutil.WriteFile(fileName, []byte(data), 0600)
+	require.NoError(t, err)
+}
+
+func TestJUnitReportParsing(t *testing.T) {
+	writeTestJUnitReport(t, "junit_test_report.xml", junitTestReport)
+	defer os.Remove("junit_test_report.xml")
+	writeTestJUnitReport(t, "junit_test_invalid.xml", "<testsuite>")
+	defer os.Remove("junit_test_invalid.xml")
+
+	cmd := JUnitReportCommand{
+		Paths: []string{"junit_test_*.xml", "missing.xml"},
+	}
+	report, files := cmd.parse()
+	assert.Equal(t, 1, files, "invalid and missing reports should be skipped")
+	assert.Equal(t, 4, len(report.tests))
+	assert.Equal(t, 2, len(report.failed))
+	assert.Equal(t, 1, report.errors)
+	assert.Equal(t, 1, report.skipped)
+	assert.Equal(t, 3.75, report.time)
+
+	slowest := report.slowest(2)
+	require.Equal(t, 2, len(slowest))
+	assert.Equal(t, "math.TestSub", slowest[0].String())
+	assert.Equal(t, "math.nested.TestDiv", slowest[1].String())
+}
+
+func TestJUnitReportFailureLines(t *testing.T) {
+	failure := &junitFailure{
+		Message: "expected 1, got 2",
+		Text:    "\nline 1\nline 1\n\nline 2\nline 3\nline 4\n",
+	}
+	assert.Equal(t, []string{"expected 1, got 2", "line 1", "line 2", "line 3", "..."}, failureLines(failure, 4))
+	assert.Equal(t, []string{"expected 1, got 2"}, failureLines(&junitFailure{Message: "expected 1, got 2"}, 4))
+}
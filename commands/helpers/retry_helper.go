@@ -3,20 +3,33 @@ package helpers
 import (
 	"github.com/Sirupsen/logrus"
 	"time"
+
+	"gitlab.com/gitlab-org/gitlab-ci-multi-runner/network"
 )
 
 type retryHelper struct {
-	Retry     int           `long:"retry" description:"How many times to retry upload"`
-	RetryTime time.Duration `long:"retry-time" description:"How long to wait between retries"`
+	Retry           int           `long:"retry" description:"How many times to retry upload"`
+	RetryTime       time.Duration `long:"retry-time" description:"How long to wait before the first retry, it's doubled for every next retry"`
+	RetryMaxBackoff time.Duration `long:"retry-max-backoff" description:"Maximum time to wait between retries"`
+	RetryMaxTime    time.Duration `long:"retry-max-time" description:"How long to retry"`
 }
 
-func (r *retryHelper) doRetry(handler func() (bool, error)) (err error) {
-	retry, err := handler()
-	for i := 0; retry && i < r.Retry; i++ {
-		// wait one second to retry
-		logrus.Warningln("Retrying...")
-		time.Sleep(r.RetryTime)
-		retry, err = handler()
+func (r *retryHelper) doRetry(handler func() (bool, error)) error {
+	return r.doRetryWithLog(logrus.NewEntry(logrus.StandardLogger()), handler)
+}
+
+func (r *retryHelper) doRetryWithLog(log *logrus.Entry, handler func() (bool, error)) (err error) {
+	policy := network.RetryPolicy{
+		MaxAttempts:    r.Retry + 1,
+		MaxTime:        r.RetryMaxTime,
+		InitialBackoff: r.RetryTime,
+		MaxBackoff:     r.RetryMaxBackoff,
 	}
+
+	policy.Do(log, func() (bool, time.Duration) {
+		var retry bool
+		retry, err = handler()
+		return retry, 0
+	})
 	return
 }
@@ -119,7 +119,7 @@ func (mr *RunCommand) processRunner(id int, runner *common.RunnerConfig, runners
 		ID:    buildData.ID,
 		Token: buildData.Token,
 	}
-	trace := mr.network.ProcessBuild(*runner, buildCredentials)
+	trace := mr.network.ProcessBuild(buildData.TraceConfig(*runner), buildCredentials)
 	defer trace.Fail(err)
 
 	// Create a new build
@@ -302,9 +302,18 @@ func (mr *RunCommand) runWait() {
 	mr.stopSignal = <-mr.stopSignals
 }
 
+// resendPendingTraces sends the traces that failed to be sent when their builds finished
+func (mr *RunCommand) resendPendingTraces() {
+	for mr.stopSignal == nil {
+		network.ResendPendingTraces(mr.network, mr.config.Runners)
+		time.Sleep(common.PendingTracesResendInterval)
+	}
+}
+
 func (mr *RunCommand) Run() {
 	runners := make(chan *common.RunnerConfig)
 	go mr.feedRunners(runners)
+	go mr.resendPendingTraces()
 
 	signal.Notify(mr.stopSignals, syscall.SIGQUIT, syscall.SIGTERM, os.Interrupt, os.Kill)
 	signal.Notify(mr.reloadSignal, syscall.SIGHUP)
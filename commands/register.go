@@ -223,6 +223,9 @@ func (s *RegisterCommand) Execute(context *cli.Context) {
 		log.Warningf("Specified limit (%d) larger then current concurrent limit (%d). Concurrent limit will not be enlarged.", s.Limit, s.config.Concurrent)
 	}
 	s.Machine = nil
+	if s.Retry != nil && s.Retry.IsEmpty() {
+		s.Retry = nil
+	}
 
 	s.askExecutorOptions()
 	s.addRunner(&s.RunnerConfig)
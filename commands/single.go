@@ -82,7 +82,7 @@ func (r *RunSingleCommand) processBuild(data common.ExecutorData, abortSignal ch
 		ID:    buildData.ID,
 		Token: buildData.Token,
 	}
-	trace := r.network.ProcessBuild(r.RunnerConfig, buildCredentials)
+	trace := r.network.ProcessBuild(buildData.TraceConfig(r.RunnerConfig), buildCredentials)
 	defer trace.Fail(err)
 
 	err = newBuild.Run(config, trace)
@@ -40,7 +40,7 @@ func (c *UnregisterCommand) Execute(context *cli.Context) {
 
 	runners := []*common.RunnerConfig{}
 	for _, otherRunner := range c.config.Runners {
-		if otherRunner.RunnerCredentials == c.RunnerCredentials {
+		if otherRunner.UniqueID() == c.UniqueID() {
 			continue
 		}
 		runners = append(runners, otherRunner)
@@ -6,6 +6,7 @@ import (
 	"net/url"
 	"os"
 	"path"
+	"path/filepath"
 	"strconv"
 	"strings"
 
@@ -21,6 +22,16 @@ type GitStrategy int
 const (
 	GitClone GitStrategy = iota
 	GitFetch
+	GitNone
+)
+
+type SubmoduleStrategy int
+
+const (
+	SubmoduleInvalid SubmoduleStrategy = iota
+	SubmoduleNone
+	SubmoduleNormal
+	SubmoduleRecursive
 )
 
 const (
@@ -102,6 +113,19 @@ func (b *Build) FullProjectDir() string {
 	return helpers.ToSlash(b.BuildDir)
 }
 
+// GitMirrorDir returns the bare mirror of the repository shared by all builds of the project,
+// or an empty string when mirroring is disabled or the cache directory is not absolute
+func (b *Build) GitMirrorDir() string {
+	if b.Runner == nil || !b.Runner.GitMirror {
+		return ""
+	}
+
+	if !path.IsAbs(b.CacheDir) && !filepath.IsAbs(b.CacheDir) {
+		return ""
+	}
+	return path.Join(b.CacheDir, "mirror", "repo.git")
+}
+
 func (b *Build) StartBuild(rootDir, cacheDir string, sharedDir bool) {
 	b.RootDir = rootDir
 	b.BuildDir = path.Join(rootDir, b.ProjectUniqueDir(sharedDir))
@@ -142,16 +166,20 @@ func (b *Build) executeShellScript(scriptType ShellScriptType, executor Executor
 func (b *Build) executeUploadArtifacts(state error, executor Executor, abort chan interface{}) (err error) {
 	when, _ := b.Options.GetString("artifacts", "when")
 
+	var upload bool
 	if state == nil {
 		// Previous stages were successful
-		if when == "" || when == "on_success" || when == "always" {
-			err = b.executeShellScript(ShellUploadArtifacts, executor, abort)
-		}
+		upload = when == "" || when == "on_success" || when == "always"
 	} else {
 		// Previous stage did fail
-		if when == "on_failure" || when == "always" {
-			err = b.executeShellScript(ShellUploadArtifacts, executor, abort)
-		}
+		upload = when == "on_failure" || when == "always"
+	}
+
+	if upload {
+		err = b.executeShellScript(ShellUploadArtifacts, executor, abort)
+	} else if _, ok := b.Options.Get("artifacts", "reports", "junit"); ok {
+		// The test reports are summarized and uploaded regardless of artifacts:when
+		err = b.executeShellScript(ShellUploadReports, executor, abort)
 	}
 
 	// Use previous error if set
@@ -333,6 +361,9 @@ func (b *Build) GetGitStrategy() GitStrategy {
 	case "fetch":
 		return GitFetch
 
+	case "none":
+		return GitNone
+
 	default:
 		if b.AllowGitFetch {
 			return GitFetch
@@ -341,3 +372,50 @@ func (b *Build) GetGitStrategy() GitStrategy {
 		return GitClone
 	}
 }
+
+func (b *Build) IsGitCheckout() bool {
+	checkout := b.GetAllVariables().Get("GIT_CHECKOUT")
+	if checkout == "" {
+		return true
+	}
+
+	value, err := strconv.ParseBool(checkout)
+	if err != nil {
+		return true
+	}
+	return value
+}
+
+func (b *Build) GetGitCleanFlags() []string {
+	flags := b.GetAllVariables().Get("GIT_CLEAN_FLAGS")
+	if flags == "" {
+		flags = "-ffdx"
+	}
+
+	if flags == "none" {
+		return []string{}
+	}
+	return strings.Fields(flags)
+}
+
+func (b *Build) GetSubmoduleStrategy() SubmoduleStrategy {
+	// Submodules can't be set up without the repository or its working tree
+	if b.GetGitStrategy() == GitNone || !b.IsGitCheckout() {
+		return SubmoduleNone
+	}
+
+	switch b.GetAllVariables().Get("GIT_SUBMODULE_STRATEGY") {
+	case "normal":
+		return SubmoduleNormal
+
+	case "recursive":
+		return SubmoduleRecursive
+
+	case "none", "":
+		// Default (legacy) behavior is to not update/init submodules
+		return SubmoduleNone
+
+	default:
+		return SubmoduleInvalid
+	}
+}
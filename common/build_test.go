@@ -151,3 +151,158 @@ func TestRunFailure(t *testing.T) {
 	err := build.Run(&Config{}, &Trace{Writer: os.Stdout})
 	assert.EqualError(t, err, "build fail")
 }
+
+func TestUploadReportsOfFailedBuild(t *testing.T) {
+	s := MockShell{}
+	s.On("GetName").Return("reports-shell")
+	s.On("GenerateScript", ShellScriptType(ShellUploadArtifacts), mock.Anything).Return("upload artifacts", nil)
+	s.On("GenerateScript", ShellScriptType(ShellUploadReports), mock.Anything).Return("upload reports", nil)
+	RegisterShell(&s)
+
+	e := MockExecutor{}
+	defer e.AssertExpectations(t)
+	e.On("Shell").Return(&ShellScriptInfo{Shell: "reports-shell"})
+	e.On("Run", ExecutorCommand{Script: "upload reports", Predefined: true}).Return(nil).Once()
+
+	build := &Build{
+		GetBuildResponse: GetBuildResponse{
+			Options: BuildOptions{
+				"artifacts": map[string]interface{}{
+					"paths": []interface{}{"binaries/"},
+					"reports": map[string]interface{}{
+						"junit": "report.xml",
+					},
+				},
+			},
+		},
+		Runner: &RunnerConfig{},
+	}
+
+	err := build.executeUploadArtifacts(errors.New("build fail"), &e, nil)
+	assert.EqualError(t, err, "build fail")
+
+	e.On("Run", ExecutorCommand{Script: "upload artifacts", Predefined: true}).Return(nil).Once()
+	err = build.executeUploadArtifacts(nil, &e, nil)
+	assert.NoError(t, err)
+}
+
+func TestGetSubmoduleStrategy(t *testing.T) {
+	tests := map[string]SubmoduleStrategy{
+		"":          SubmoduleNone,
+		"none":      SubmoduleNone,
+		"normal":    SubmoduleNormal,
+		"recursive": SubmoduleRecursive,
+		"invalid":   SubmoduleInvalid,
+	}
+
+	for value, expected := range tests {
+		build := &Build{
+			GetBuildResponse: GetBuildResponse{
+				Variables: BuildVariables{
+					{Key: "GIT_SUBMODULE_STRATEGY", Value: value},
+				},
+			},
+			Runner: &RunnerConfig{},
+		}
+		assert.Equal(t, expected, build.GetSubmoduleStrategy(), "for %q", value)
+	}
+}
+
+func TestGetGitStrategy(t *testing.T) {
+	tests := map[string]GitStrategy{
+		"":      GitClone,
+		"clone": GitClone,
+		"fetch": GitFetch,
+		"none":  GitNone,
+	}
+
+	for value, expected := range tests {
+		build := &Build{
+			GetBuildResponse: GetBuildResponse{
+				Variables: BuildVariables{
+					{Key: "GIT_STRATEGY", Value: value},
+				},
+			},
+			Runner: &RunnerConfig{},
+		}
+		assert.Equal(t, expected, build.GetGitStrategy(), "for %q", value)
+	}
+}
+
+func TestGitNoneDisablesSubmodules(t *testing.T) {
+	build := &Build{
+		GetBuildResponse: GetBuildResponse{
+			Variables: BuildVariables{
+				{Key: "GIT_STRATEGY", Value: "none"},
+				{Key: "GIT_SUBMODULE_STRATEGY", Value: "recursive"},
+			},
+		},
+		Runner: &RunnerConfig{},
+	}
+	assert.Equal(t, SubmoduleNone, build.GetSubmoduleStrategy())
+}
+
+func TestNoGitCheckoutDisablesSubmodules(t *testing.T) {
+	build := &Build{
+		GetBuildResponse: GetBuildResponse{
+			Variables: BuildVariables{
+				{Key: "GIT_CHECKOUT", Value: "false"},
+				{Key: "GIT_SUBMODULE_STRATEGY", Value: "recursive"},
+			},
+		},
+		Runner: &RunnerConfig{},
+	}
+	assert.Equal(t, SubmoduleNone, build.GetSubmoduleStrategy())
+}
+
+func TestIsGitCheckout(t *testing.T) {
+	tests := map[string]bool{
+		"":      true,
+		"true":  true,
+		"false": false,
+		"0":     false,
+		"other": true,
+	}
+
+	for value, expected := range tests {
+		build := &Build{
+			GetBuildResponse: GetBuildResponse{
+				Variables: BuildVariables{
+					{Key: "GIT_CHECKOUT", Value: value},
+				},
+			},
+			Runner: &RunnerConfig{},
+		}
+		assert.Equal(t, expected, build.IsGitCheckout(), "for %q", value)
+	}
+}
+
+func TestGetGitCleanFlags(t *testing.T) {
+	tests := map[string][]string{
+		"":             {"-ffdx"},
+		"none":         {},
+		"-ffdx -e foo": {"-ffdx", "-e", "foo"},
+	}
+
+	for value, expected := range tests {
+		build := &Build{
+			GetBuildResponse: GetBuildResponse{
+				Variables: BuildVariables{
+					{Key: "GIT_CLEAN_FLAGS", Value: value},
+				},
+			},
+			Runner: &RunnerConfig{},
+		}
+		assert.Equal(t, expected, build.GetGitCleanFlags(), "for %q", value)
+	}
+}
+
+func TestTraceConfigDoesntUploadFullLogOverArtifacts(t *testing.T) {
+	runner := RunnerConfig{OutputFullLog: true}
+
+	build := &GetBuildResponse{Options: BuildOptions{}}
+	assert.True(t, build.TraceConfig(runner).OutputFullLog)
+
+	build.Options["artifacts"] = map[string]interface{}{"paths": []interface{}{"public/"}}
+	assert.False(t, build.TraceConfig(runner).OutputFullLog, "the artifacts of the build shouldn't be replaced")
+}
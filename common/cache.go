@@ -0,0 +1,108 @@
+package common
+
+import (
+	"errors"
+	"net/url"
+	"path"
+	"strconv"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// CacheAdapter gives the build access to a single object in the cache storage
+type CacheAdapter interface {
+	// GetDownloadURL returns the address the helper downloads the object from
+	GetDownloadURL() *url.URL
+	// GetUploadURL returns the address the helper uploads the object to
+	GetUploadURL() *url.URL
+}
+
+// StreamingCacheAdapter is implemented by the adapters accepting uploads of unknown size,
+// the cache can be then uploaded while it's being archived
+type StreamingCacheAdapter interface {
+	CacheAdapter
+	IsStreamingSupported() bool
+}
+
+type CacheAdapterFactory func(config *CacheConfig, timeout time.Duration, objectName string) (CacheAdapter, error)
+
+var cacheAdapters map[string]CacheAdapterFactory
+
+func RegisterCacheAdapter(typeName string, factory CacheAdapterFactory) {
+	log.Debugln("Registering", typeName, "cache adapter...")
+
+	if cacheAdapters == nil {
+		cacheAdapters = make(map[string]CacheAdapterFactory)
+	}
+	if _, ok := cacheAdapters[typeName]; ok {
+		panic("Cache adapter already exist: " + typeName)
+	}
+	cacheAdapters[typeName] = factory
+}
+
+func GetCacheAdapters() []string {
+	names := []string{}
+	for name := range cacheAdapters {
+		names = append(names, name)
+	}
+	return names
+}
+
+func CreateCacheAdapter(config *CacheConfig, timeout time.Duration, objectName string) (CacheAdapter, error) {
+	factory, ok := cacheAdapters[config.Type]
+	if !ok {
+		return nil, errors.New("cache adapter not found: " + config.Type)
+	}
+
+	return factory(config, timeout, objectName)
+}
+
+// GetCacheProjectPath returns where the caches of the project are kept in the storage,
+// the caches are separated per runner unless they're shared
+func GetCacheProjectPath(build *Build, cache *CacheConfig) string {
+	projectPath := path.Join("project", strconv.Itoa(build.ProjectID))
+	if !cache.Shared {
+		return path.Join("runner", build.Runner.ShortDescription(), projectPath)
+	} else if cache.Namespace != "" {
+		return path.Join("namespace", cache.Namespace, projectPath)
+	}
+	return projectPath
+}
+
+type CacheObject struct {
+	Name         string
+	Size         int64
+	LastModified time.Time
+}
+
+// CacheStorage gives access to all objects in the cache storage, it's used to clean up the cache
+type CacheStorage interface {
+	ListObjects(prefix string) ([]CacheObject, error)
+	RemoveObject(objectName string) error
+}
+
+type CacheStorageFactory func(config *CacheConfig) (CacheStorage, error)
+
+var cacheStorages map[string]CacheStorageFactory
+
+func RegisterCacheStorage(typeName string, factory CacheStorageFactory) {
+	log.Debugln("Registering", typeName, "cache storage...")
+
+	if cacheStorages == nil {
+		cacheStorages = make(map[string]CacheStorageFactory)
+	}
+	if _, ok := cacheStorages[typeName]; ok {
+		panic("Cache storage already exist: " + typeName)
+	}
+	cacheStorages[typeName] = factory
+}
+
+func CreateCacheStorage(config *CacheConfig) (CacheStorage, error) {
+	factory, ok := cacheStorages[config.Type]
+	if !ok {
+		return nil, errors.New("listing objects is not supported by the cache: " + config.Type)
+	}
+
+	return factory(config)
+}
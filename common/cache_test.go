@@ -0,0 +1,45 @@
+package common
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testCacheAdapter struct {
+	objectName string
+}
+
+func (a *testCacheAdapter) GetDownloadURL() *url.URL {
+	return &url.URL{Scheme: "test", Path: a.objectName}
+}
+
+func (a *testCacheAdapter) GetUploadURL() *url.URL {
+	return &url.URL{Scheme: "test", Path: a.objectName}
+}
+
+func init() {
+	RegisterCacheAdapter("test", func(config *CacheConfig, timeout time.Duration, objectName string) (CacheAdapter, error) {
+		return &testCacheAdapter{objectName: objectName}, nil
+	})
+}
+
+func TestCreateCacheAdapter(t *testing.T) {
+	adapter, err := CreateCacheAdapter(&CacheConfig{Type: "test"}, time.Hour, "key")
+	require.NoError(t, err)
+	assert.Equal(t, "test://key", adapter.GetDownloadURL().String())
+}
+
+func TestCreateUnknownCacheAdapter(t *testing.T) {
+	_, err := CreateCacheAdapter(&CacheConfig{Type: "unknown"}, time.Hour, "key")
+	assert.Error(t, err)
+}
+
+func TestRegisterCacheAdapterTwice(t *testing.T) {
+	assert.Panics(t, func() {
+		RegisterCacheAdapter("test", nil)
+	})
+}
@@ -7,7 +7,6 @@ const DefaultExecTimeout = 1800
 const CheckInterval = 3 * time.Second
 const NotHealthyCheckInterval = 300
 const UpdateInterval = 3 * time.Second
-const UpdateRetryInterval = 3 * time.Second
 const ReloadConfigInterval = 3
 const HealthyChecks = 3
 const HealthCheckInterval = 3600
@@ -15,6 +14,7 @@ const DefaultWaitForServicesTimeout = 30
 const ShutdownTimeout = 30
 const DefaultOutputLimit = 4096 // 4MB in kilobytes
 const ForceTraceSentInterval = 30 * time.Second
+const PendingTracesResendInterval = 10 * time.Minute
 const PreparationRetries = 3
 
 var PreparationRetryInterval = 3 * time.Second
@@ -64,8 +64,8 @@ func (m *MockNetwork) DownloadArtifacts(config BuildCredentials, artifactsFile s
 
 	return r0
 }
-func (m *MockNetwork) UploadRawArtifacts(config BuildCredentials, reader io.Reader, baseName string, expireIn string) UploadState {
-	ret := m.Called(config, reader, baseName, expireIn)
+func (m *MockNetwork) UploadRawArtifacts(config BuildCredentials, reader io.Reader, metadata io.Reader, baseName string, expireIn string) UploadState {
+	ret := m.Called(config, reader, metadata, baseName, expireIn)
 
 	r0 := ret.Get(0).(UploadState)
 
@@ -38,6 +38,9 @@ type FeaturesInfo struct {
 	Services  bool `json:"services"`
 	Artifacts bool `json:"features"`
 	Cache     bool `json:"cache"`
+
+	// TraceCompression tells GitLab that the runner can send gzip-encoded traces
+	TraceCompression bool `json:"trace_compression"`
 }
 
 type VersionInfo struct {
@@ -87,8 +90,20 @@ type GetBuildResponse struct {
 	Name            string         `json:"name"`
 	Stage           string         `json:"stage"`
 	Tag             bool           `json:"tag"`
+	Protected       bool           `json:"protected"`
 	DependsOnBuilds []BuildInfo    `json:"depends_on_builds"`
 	TLSCAChain      string         `json:"-"`
+	TLSAuthCert     string         `json:"-"`
+	TLSAuthKey      string         `json:"-"`
+}
+
+// TraceConfig returns the runner configuration used by the build trace, the untruncated trace
+// isn't uploaded as the artifacts of the build uploading its own artifacts, it would replace them
+func (b *GetBuildResponse) TraceConfig(runner RunnerConfig) RunnerConfig {
+	if _, ok := b.Options.Get("artifacts"); ok {
+		runner.OutputFullLog = false
+	}
+	return runner
 }
 
 func (b *GetBuildResponse) RepoCleanURL() (ret string) {
@@ -122,10 +137,25 @@ type UpdateBuildRequest struct {
 }
 
 type BuildCredentials struct {
-	ID        int    `long:"id" env:"CI_BUILD_ID" description:"The build ID to upload artifacts for"`
-	Token     string `long:"token" env:"CI_BUILD_TOKEN" required:"true" description:"Build token"`
-	URL       string `long:"url" env:"CI_SERVER_URL" required:"true" description:"GitLab CI URL"`
-	TLSCAFile string `long:"tls-ca-file" env:"CI_SERVER_TLS_CA_FILE" description:"File containing the certificates to verify the peer when using HTTPS"`
+	ID          int    `long:"id" env:"CI_BUILD_ID" description:"The build ID to upload artifacts for"`
+	Token       string `long:"token" env:"CI_BUILD_TOKEN" required:"true" description:"Build token"`
+	URL         string `long:"url" env:"CI_SERVER_URL" required:"true" description:"GitLab CI URL"`
+	TLSCAFile   string `long:"tls-ca-file" env:"CI_SERVER_TLS_CA_FILE" description:"File containing the certificates to verify the peer when using HTTPS"`
+	TLSCertFile string `long:"tls-cert-file" env:"CI_SERVER_TLS_CERT_FILE" description:"File containing the client certificate presented to the peer when using HTTPS"`
+	TLSKeyFile  string `long:"tls-key-file" env:"CI_SERVER_TLS_KEY_FILE" description:"File containing the private key of the client certificate"`
+	Proxy       string `long:"proxy" env:"CI_SERVER_PROXY" description:"Proxy URL used to connect to GitLab"`
+}
+
+// RunnerCredentials returns the credentials used to connect to GitLab on behalf of the build
+func (b *BuildCredentials) RunnerCredentials() RunnerCredentials {
+	return RunnerCredentials{
+		URL:         b.URL,
+		Token:       b.Token,
+		TLSCAFile:   b.TLSCAFile,
+		TLSCertFile: b.TLSCertFile,
+		TLSKeyFile:  b.TLSKeyFile,
+		Proxy:       b.Proxy,
+	}
 }
 
 type BuildTrace interface {
@@ -151,7 +181,7 @@ type Network interface {
 	UpdateBuild(config RunnerConfig, id int, state BuildState, trace *string) UpdateState
 	PatchTrace(config RunnerConfig, buildCredentials *BuildCredentials, tracePart BuildTracePatch) UpdateState
 	DownloadArtifacts(config BuildCredentials, artifactsFile string) DownloadState
-	UploadRawArtifacts(config BuildCredentials, reader io.Reader, baseName string, expireIn string) UploadState
+	UploadRawArtifacts(config BuildCredentials, reader io.Reader, metadata io.Reader, baseName string, expireIn string) UploadState
 	UploadArtifacts(config BuildCredentials, artifactsFile string) UploadState
 	ProcessBuild(config RunnerConfig, buildCredentials *BuildCredentials) BuildTrace
 }
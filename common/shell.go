@@ -30,6 +30,7 @@ const (
 	ShellAfterScript                     = "after_script"
 	ShellArchiveCache                    = "archive_cache"
 	ShellUploadArtifacts                 = "upload_artifacts"
+	ShellUploadReports                   = "upload_reports"
 )
 
 func (s *ShellConfiguration) GetCommandWithArguments() []string {
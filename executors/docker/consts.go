@@ -1,7 +1,10 @@
 package docker
 
 const DockerAPIVersion = "1.18"
-const dockerLabelPrefix = "com.gitlab.gitlab-runner"
+const DockerLabelPrefix = "com.gitlab.gitlab-runner"
+
+// DockerCacheLastUseFile is written to the cache containers on each use, its modification time is read by cache-gc
+const DockerCacheLastUseFile = "gitlab-runner-cache-last-use"
 
 const prebuiltImageName = "gitlab-runner-prebuilt"
 const prebuiltImageExtension = ".tar.xz"
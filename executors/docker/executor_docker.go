@@ -1,6 +1,7 @@
 package docker
 
 import (
+	"archive/tar"
 	"bytes"
 	"crypto/md5"
 	"errors"
@@ -213,18 +214,18 @@ func (s *executor) addHostVolume(hostPath, containerPath string) error {
 
 func (s *executor) getLabels(containerType string, otherLabels ...string) map[string]string {
 	labels := make(map[string]string)
-	labels[dockerLabelPrefix+".build.id"] = strconv.Itoa(s.Build.ID)
-	labels[dockerLabelPrefix+".build.sha"] = s.Build.Sha
-	labels[dockerLabelPrefix+".build.before_sha"] = s.Build.BeforeSha
-	labels[dockerLabelPrefix+".build.ref_name"] = s.Build.RefName
-	labels[dockerLabelPrefix+".project.id"] = strconv.Itoa(s.Build.ProjectID)
-	labels[dockerLabelPrefix+".runner.id"] = s.Build.Runner.ShortDescription()
-	labels[dockerLabelPrefix+".runner.local_id"] = strconv.Itoa(s.Build.RunnerID)
-	labels[dockerLabelPrefix+".type"] = containerType
+	labels[DockerLabelPrefix+".build.id"] = strconv.Itoa(s.Build.ID)
+	labels[DockerLabelPrefix+".build.sha"] = s.Build.Sha
+	labels[DockerLabelPrefix+".build.before_sha"] = s.Build.BeforeSha
+	labels[DockerLabelPrefix+".build.ref_name"] = s.Build.RefName
+	labels[DockerLabelPrefix+".project.id"] = strconv.Itoa(s.Build.ProjectID)
+	labels[DockerLabelPrefix+".runner.id"] = s.Build.Runner.ShortDescription()
+	labels[DockerLabelPrefix+".runner.local_id"] = strconv.Itoa(s.Build.RunnerID)
+	labels[DockerLabelPrefix+".type"] = containerType
 	for _, label := range otherLabels {
 		keyValue := strings.SplitN(label, "=", 2)
 		if len(keyValue) == 2 {
-			labels[dockerLabelPrefix+"."+keyValue[0]] = keyValue[1]
+			labels[DockerLabelPrefix+"."+keyValue[0]] = keyValue[1]
 		}
 	}
 	return labels
@@ -286,7 +287,34 @@ func (s *executor) createCacheVolume(containerName, containerPath string) (*dock
 	return container, nil
 }
 
+// markCacheUsed writes the time of the use to the file system of the cache container, not to the cache volume
+func (s *executor) markCacheUsed(container *docker.Container) error {
+	var buffer bytes.Buffer
+	archive := tar.NewWriter(&buffer)
+	err := archive.WriteHeader(&tar.Header{
+		Name:     DockerCacheLastUseFile,
+		Mode:     0644,
+		ModTime:  time.Now(),
+		Typeflag: tar.TypeReg,
+	})
+	if err == nil {
+		err = archive.Close()
+	}
+	if err != nil {
+		return err
+	}
+
+	return s.client.UploadToContainer(container.ID, docker.UploadToContainerOptions{
+		InputStream: &buffer,
+		Path:        "/",
+	})
+}
+
 func (s *executor) addCacheVolume(containerPath string) error {
+	return s.addNamedCacheVolume(s.Build.ProjectUniqueName(), containerPath)
+}
+
+func (s *executor) addNamedCacheVolume(uniqueName, containerPath string) error {
 	var err error
 	containerPath = s.getAbsoluteContainerPath(containerPath)
 
@@ -300,7 +328,7 @@ func (s *executor) addCacheVolume(containerPath string) error {
 
 	// use host-based cache
 	if cacheDir := s.Config.Docker.CacheDir; cacheDir != "" {
-		hostPath := fmt.Sprintf("%s/%s/%x", cacheDir, s.Build.ProjectUniqueName(), hash)
+		hostPath := fmt.Sprintf("%s/%s/%x", cacheDir, uniqueName, hash)
 		hostPath, err := filepath.Abs(hostPath)
 		if err != nil {
 			return err
@@ -311,7 +339,7 @@ func (s *executor) addCacheVolume(containerPath string) error {
 	}
 
 	// get existing cache container
-	containerName := fmt.Sprintf("%s-cache-%x", s.Build.ProjectUniqueName(), hash)
+	containerName := fmt.Sprintf("%s-cache-%x", uniqueName, hash)
 	container, _ := s.client.InspectContainer(containerName)
 
 	// check if we have valid cache, if not remove the broken container
@@ -320,6 +348,14 @@ func (s *executor) addCacheVolume(containerPath string) error {
 		container = nil
 	}
 
+	// the cache is still used even if the use can't be recorded, cache-gc falls back to the time it was created
+	if container != nil {
+		err = s.markCacheUsed(container)
+		if err != nil {
+			s.Warningln("Failed to record the use of cache container", container.ID, err)
+		}
+	}
+
 	// create new cache container for that project
 	if container == nil {
 		container, err = s.createCacheVolume(containerName, containerPath)
@@ -382,6 +418,18 @@ func (s *executor) createBuildVolume() (err error) {
 	return
 }
 
+func (s *executor) createGitMirrorVolume() error {
+	mirrorDir := s.Build.GitMirrorDir()
+	if mirrorDir == "" {
+		return nil
+	}
+
+	// the mirror is shared by all concurrent builds of the project,
+	// its parent directory holds the lock and temporary files, so it's mounted too
+	uniqueName := fmt.Sprintf("runner-%s-project-%d-mirror", s.Build.Runner.ShortDescription(), s.Build.ProjectID)
+	return s.addNamedCacheVolume(uniqueName, path.Dir(mirrorDir))
+}
+
 func (s *executor) createUserVolumes() (err error) {
 	for _, volume := range s.Config.Docker.Volumes {
 		err = s.addVolume(volume)
@@ -841,6 +889,56 @@ func (s *executor) connectDocker() (err error) {
 	return
 }
 
+// getCachePolicies returns the policies of the caches used by the build, nothing if it doesn't use the cache
+func (s *executor) getCachePolicies() (policies []string) {
+	type cacheOptions struct {
+		Policy string `json:"policy"`
+	}
+
+	var caches []cacheOptions
+	if err := s.Build.Options.Decode(&caches, "cache"); err != nil {
+		var single cacheOptions
+		if s.Build.Options.Decode(&single, "cache") != nil {
+			return nil
+		}
+		caches = append(caches, single)
+	}
+
+	for _, cache := range caches {
+		policies = append(policies, cache.Policy)
+	}
+	return
+}
+
+func (s *executor) createSharedCacheVolume() error {
+	cache := s.Config.Cache
+	if cache == nil || cache.Type != "filesystem" || cache.Path == "" {
+		return nil
+	}
+
+	policies := s.getCachePolicies()
+	if len(policies) == 0 {
+		return nil
+	}
+
+	readOnly := true
+	for _, policy := range policies {
+		if policy != "pull" {
+			readOnly = false
+		}
+	}
+
+	// only the caches of the project are available to the build, and the helper reads and writes
+	// them directly, so they have to be at the same path
+	projectPath := filepath.Join(cache.Path, filepath.FromSlash(common.GetCacheProjectPath(s.Build, cache)))
+	if readOnly {
+		s.Debugln("Using host-based", projectPath, "for", projectPath, "(read-only) ...")
+		s.binds = append(s.binds, fmt.Sprintf("%v:%v:ro", projectPath, projectPath))
+		return nil
+	}
+	return s.addHostVolume(projectPath, projectPath)
+}
+
 func (s *executor) createDependencies() (err error) {
 	err = s.bindDevices()
 	if err != nil {
@@ -853,6 +951,18 @@ func (s *executor) createDependencies() (err error) {
 		return err
 	}
 
+	s.Debugln("Creating git mirror volume...")
+	err = s.createGitMirrorVolume()
+	if err != nil {
+		return err
+	}
+
+	s.Debugln("Creating shared cache volume...")
+	err = s.createSharedCacheVolume()
+	if err != nil {
+		return err
+	}
+
 	s.Debugln("Creating services...")
 	err = s.createServices()
 	if err != nil {
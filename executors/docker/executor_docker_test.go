@@ -1,11 +1,13 @@
 package docker
 
 import (
+	"errors"
 	"os"
 	"testing"
 
 	"github.com/fsouza/go-dockerclient"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 
 	"gitlab.com/gitlab-org/gitlab-ci-multi-runner/common"
 	"gitlab.com/gitlab-org/gitlab-ci-multi-runner/helpers/docker"
@@ -338,3 +340,72 @@ func TestHostMountedBuildsDirectory(t *testing.T) {
 		assert.Equal(t, i.result, e.SharedBuildsDir)
 	}
 }
+
+func TestSharedCacheVolume(t *testing.T) {
+	tests := []struct {
+		cache interface{}
+		binds []string
+	}{
+		{nil, nil},
+		{map[string]interface{}{"paths": []interface{}{"vendor"}}, []string{"/mnt/cache/runner/abc/project/10:/mnt/cache/runner/abc/project/10"}},
+		{map[string]interface{}{"policy": "pull"}, []string{"/mnt/cache/runner/abc/project/10:/mnt/cache/runner/abc/project/10:ro"}},
+		{[]interface{}{
+			map[string]interface{}{"policy": "pull"},
+			map[string]interface{}{"policy": "push"},
+		}, []string{"/mnt/cache/runner/abc/project/10:/mnt/cache/runner/abc/project/10"}},
+	}
+
+	for _, test := range tests {
+		runner := &common.RunnerConfig{
+			RunnerCredentials: common.RunnerCredentials{
+				Token: "abc",
+			},
+			RunnerSettings: common.RunnerSettings{
+				Cache: &common.CacheConfig{
+					Type: "filesystem",
+					Path: "/mnt/cache",
+				},
+			},
+		}
+
+		build := &common.Build{
+			GetBuildResponse: common.GetBuildResponse{
+				ProjectID: 10,
+				Options:   common.BuildOptions{},
+			},
+			Runner: runner,
+		}
+		if test.cache != nil {
+			build.Options["cache"] = test.cache
+		}
+
+		e := &executor{}
+		e.Config = *runner
+		e.Build = build
+
+		err := e.createSharedCacheVolume()
+		assert.NoError(t, err)
+		assert.Equal(t, test.binds, e.binds, "only the caches of the project should be mounted")
+	}
+}
+
+func TestReusedCacheContainerUseIsRecorded(t *testing.T) {
+	for _, uploadErr := range []error{nil, errors.New("upload failed")} {
+		var c docker_helpers.MockClient
+
+		e := executor{client: &c}
+		e.Config.Docker = &common.DockerConfig{}
+
+		container := &docker.Container{
+			ID:      "cache-id",
+			Volumes: map[string]string{"/cache": "/var/lib/docker/volumes/cache"},
+		}
+		c.On("InspectContainer", "project-cache-3c3f060a0374fc8bc39395164f415a70").Return(container, nil).Once()
+		c.On("UploadToContainer", "cache-id", mock.Anything).Return(uploadErr).Once()
+
+		err := e.addNamedCacheVolume("project", "/cache")
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"cache-id"}, e.volumesFrom, "the cache should be used even if its use isn't recorded")
+		c.AssertExpectations(t)
+	}
+}
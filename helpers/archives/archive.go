@@ -0,0 +1,164 @@
+package archives
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"github.com/Sirupsen/logrus"
+)
+
+type ArchiveFormat string
+
+const (
+	ZipArchive     ArchiveFormat = "zip"
+	TarGzipArchive ArchiveFormat = "tar.gz"
+)
+
+var ErrUnknownArchiveFormat = errors.New("unknown archive format")
+
+func ParseArchiveFormat(name string) (ArchiveFormat, error) {
+	switch ArchiveFormat(name) {
+	case "", ZipArchive:
+		return ZipArchive, nil
+	case TarGzipArchive:
+		return TarGzipArchive, nil
+	default:
+		return "", errors.New("unknown archive format: " + name + " (supported: zip, tar.gz)")
+	}
+}
+
+func ParseCompressionLevel(name string) (int, error) {
+	switch name {
+	case "", "default":
+		return flate.DefaultCompression, nil
+	case "none":
+		return flate.NoCompression, nil
+	case "fastest":
+		return flate.BestSpeed, nil
+	case "fast":
+		return 3, nil
+	case "slow":
+		return 7, nil
+	case "slowest":
+		return flate.BestCompression, nil
+	default:
+		return 0, errors.New("unknown compression level: " + name + " (supported: none, fastest, fast, default, slow, slowest)")
+	}
+}
+
+type ArchiveOptions struct {
+	Format             ArchiveFormat
+	CompressionLevel   int
+	CompressionWorkers int
+}
+
+func DefaultArchiveOptions() ArchiveOptions {
+	return ArchiveOptions{
+		Format:             ZipArchive,
+		CompressionLevel:   flate.DefaultCompression,
+		CompressionWorkers: runtime.NumCPU(),
+	}
+}
+
+func CreateArchive(w io.Writer, fileNames []string, options ArchiveOptions) error {
+	switch options.Format {
+	case ZipArchive:
+		return createZipArchive(w, nil, fileNames, options.CompressionLevel)
+
+	case TarGzipArchive:
+		gz, err := newGzipWriter(w, options.CompressionLevel, options.CompressionWorkers)
+		if err != nil {
+			return err
+		}
+
+		err = CreateTarArchive(gz, fileNames)
+		closeErr := gz.Close()
+		if err != nil {
+			return err
+		}
+		return closeErr
+
+	default:
+		return ErrUnknownArchiveFormat
+	}
+}
+
+func CreateArchiveFile(fileName string, fileNames []string, options ArchiveOptions) error {
+	// create directories to store archive
+	os.MkdirAll(filepath.Dir(fileName), 0700)
+
+	tempFile, err := ioutil.TempFile(filepath.Dir(fileName), "archive_")
+	if err != nil {
+		return err
+	}
+	defer tempFile.Close()
+	defer os.Remove(tempFile.Name())
+
+	logrus.Debugln("Temporary file:", tempFile.Name())
+	err = CreateArchive(tempFile, fileNames, options)
+	if err != nil {
+		return err
+	}
+	tempFile.Close()
+
+	return os.Rename(tempFile.Name(), fileName)
+}
+
+// DetectArchiveFormat checks the beginning of the archive without consuming it
+func DetectArchiveFormat(r *bufio.Reader) (ArchiveFormat, error) {
+	header, err := r.Peek(4)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+
+	switch {
+	case bytes.HasPrefix(header, []byte{0x1f, 0x8b}):
+		return TarGzipArchive, nil
+	case bytes.HasPrefix(header, []byte("PK")):
+		return ZipArchive, nil
+	default:
+		return "", ErrUnknownArchiveFormat
+	}
+}
+
+// ExtractTarGzipArchive extracts the archive while it's read, eg. while it's downloaded
+func ExtractTarGzipArchive(r io.Reader, limits ExtractionLimits) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	return ExtractTarArchive(gz, limits)
+}
+
+// ExtractArchiveFile extracts the archive of any supported format
+func ExtractArchiveFile(fileName string, limits ExtractionLimits) error {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	format, err := DetectArchiveFormat(reader)
+	if err != nil {
+		return err
+	}
+
+	switch format {
+	case TarGzipArchive:
+		return ExtractTarGzipArchive(reader, limits)
+	default:
+		file.Close()
+		return ExtractZipFile(fileName, limits)
+	}
+}
@@ -0,0 +1,109 @@
+package archives
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseArchiveFormat(t *testing.T) {
+	format, err := ParseArchiveFormat("")
+	assert.NoError(t, err)
+	assert.Equal(t, ZipArchive, format)
+
+	format, err = ParseArchiveFormat("tar.gz")
+	assert.NoError(t, err)
+	assert.Equal(t, TarGzipArchive, format)
+
+	_, err = ParseArchiveFormat("rar")
+	assert.Error(t, err)
+}
+
+func TestParallelGzipWriter(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), parallelGzipBlockSize/4)
+
+	var buffer bytes.Buffer
+	gz, err := newGzipWriter(&buffer, flate.BestSpeed, 4)
+	require.NoError(t, err)
+	_, err = gz.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+
+	reader, err := gzip.NewReader(&buffer)
+	require.NoError(t, err)
+	decompressed, err := ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(data, decompressed))
+}
+
+func testArchiveFormatRoundTrip(t *testing.T, options ArchiveOptions) {
+	td, err := ioutil.TempDir("", "archive")
+	require.NoError(t, err)
+	defer os.RemoveAll(td)
+
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	defer os.Chdir(wd)
+	require.NoError(t, os.Chdir(td))
+
+	archiveFile := filepath.Join(td, "archive")
+	err = CreateArchiveFile(archiveFile, []string{
+		createTestDirectory(t),
+		createTestFile(t),
+		createSymlinkFile(t),
+		createTestPipe(t),
+		"non_existing_file.txt",
+	}, options)
+	require.NoError(t, err)
+
+	file, err := os.Open(archiveFile)
+	require.NoError(t, err)
+	format, err := DetectArchiveFormat(bufio.NewReader(file))
+	file.Close()
+	require.NoError(t, err)
+	assert.Equal(t, options.Format, format)
+
+	for _, fileName := range []string{"test_directory", "test_file.txt", "new_symlink", "test_pipe"} {
+		os.Remove(fileName)
+	}
+	require.NoError(t, ExtractArchiveFile(archiveFile, ExtractionLimits{}))
+
+	content, err := ioutil.ReadFile("test_file.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, testZipFileContent, content)
+
+	fi, err := os.Stat("test_file.txt")
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0640), fi.Mode().Perm())
+
+	link, err := os.Readlink("new_symlink")
+	assert.NoError(t, err)
+	assert.Equal(t, "old_symlink", link)
+
+	fi, err = os.Stat("test_directory")
+	require.NoError(t, err)
+	assert.True(t, fi.IsDir())
+
+	_, err = os.Lstat("test_pipe")
+	assert.True(t, os.IsNotExist(err), "pipes are not archived")
+}
+
+func TestZipArchiveRoundTrip(t *testing.T) {
+	options := DefaultArchiveOptions()
+	options.CompressionLevel = flate.BestSpeed
+	testArchiveFormatRoundTrip(t, options)
+}
+
+func TestTarGzipArchiveRoundTrip(t *testing.T) {
+	options := DefaultArchiveOptions()
+	options.Format = TarGzipArchive
+	testArchiveFormatRoundTrip(t, options)
+}
@@ -0,0 +1,235 @@
+package archives
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+)
+
+var ErrArchiveTooLarge = errors.New("archive exceeds the maximum extracted size")
+var ErrTooManyEntries = errors.New("archive exceeds the maximum number of entries")
+
+var errPathEscape = errors.New("path escapes the target directory")
+var errSymlinkEscape = errors.New("symlink points outside of the target directory")
+
+// ExtractionLimits protect the build from the archives exhausting its disk, zero means no limit
+type ExtractionLimits struct {
+	MaxSize    int64
+	MaxEntries int
+}
+
+// IsExtractionLimitError returns true if the archive was rejected, retrying it will not help
+func IsExtractionLimitError(err error) bool {
+	return err == ErrArchiveTooLarge || err == ErrTooManyEntries
+}
+
+type rejectedEntry struct {
+	name   string
+	reason error
+}
+
+// extractionGuard keeps the extracted entries inside of the current directory,
+// the archives come from other builds, so they can't be trusted
+type extractionGuard struct {
+	limits   ExtractionLimits
+	root     string
+	size     int64
+	entries  int
+	symlinks []string
+	rejected []rejectedEntry
+}
+
+func newExtractionGuard(limits ExtractionLimits) (*extractionGuard, error) {
+	root, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+
+	root, err = filepath.EvalSymlinks(root)
+	if err != nil {
+		return nil, err
+	}
+
+	return &extractionGuard{
+		limits: limits,
+		root:   root,
+	}, nil
+}
+
+func (g *extractionGuard) isInside(fileName string) bool {
+	rel, err := filepath.Rel(g.root, fileName)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// checkPath verifies that the entry and the directories it's written to don't lead outside,
+// the directories can be symlinks created by the repository or by the previous entries
+func (g *extractionGuard) checkPath(name string) error {
+	if filepath.IsAbs(name) || filepath.VolumeName(name) != "" {
+		return errPathEscape
+	}
+
+	fileName := filepath.Join(g.root, name)
+	if !g.isInside(fileName) {
+		return errPathEscape
+	}
+
+	for dir := filepath.Dir(fileName); ; dir = filepath.Dir(dir) {
+		resolved, err := filepath.EvalSymlinks(dir)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return err
+		} else if !g.isInside(resolved) {
+			return errSymlinkEscape
+		}
+		return nil
+	}
+}
+
+// maxSymlinkDepth limits the symlinks followed when resolving a path, as the system does
+const maxSymlinkDepth = 40
+
+var errSymlinkLoop = errors.New("too many levels of symlinks")
+
+// resolvePath follows the symlinks which already exist on the path, in the same order as
+// the system does, the parts which don't exist yet are appended as they are
+func resolvePath(path string, depth int) (string, error) {
+	if depth > maxSymlinkDepth {
+		return "", errSymlinkLoop
+	}
+
+	volume := filepath.VolumeName(path)
+	current := volume + string(filepath.Separator)
+	for _, part := range strings.Split(filepath.ToSlash(path[len(volume):]), "/") {
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			current = filepath.Dir(current)
+			continue
+		}
+
+		next := filepath.Join(current, part)
+		fi, err := os.Lstat(next)
+		if err != nil || fi.Mode()&os.ModeSymlink == 0 {
+			current = next
+			continue
+		}
+
+		target, err := os.Readlink(next)
+		if err != nil {
+			return "", err
+		}
+		// The target can't be cleaned before its symlinks are resolved, "link/.." is not "."
+		if !filepath.IsAbs(target) {
+			target = current + string(filepath.Separator) + target
+		}
+		current, err = resolvePath(target, depth+1)
+		if err != nil {
+			return "", err
+		}
+	}
+	return current, nil
+}
+
+func (g *extractionGuard) checkSymlink(name, target string) error {
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(g.root, filepath.Dir(name)) + string(filepath.Separator) + target
+	}
+
+	resolved, err := resolvePath(target, 0)
+	if err != nil {
+		return err
+	} else if !g.isInside(resolved) {
+		return errSymlinkEscape
+	}
+	return nil
+}
+
+// checkSymlinks verifies the extracted symlinks again, the entries extracted after them
+// could have replaced the symlinks they go through, the escaping ones are removed
+func (g *extractionGuard) checkSymlinks() {
+	for _, name := range g.symlinks {
+		fi, err := os.Lstat(name)
+		if err != nil || fi.Mode()&os.ModeSymlink == 0 {
+			continue
+		}
+
+		target, err := os.Readlink(name)
+		if err == nil {
+			err = g.checkSymlink(name, target)
+		}
+		if err != nil {
+			os.Remove(name)
+			g.rejected = append(g.rejected, rejectedEntry{name: name, reason: err})
+		}
+	}
+}
+
+// isSymlinked returns true if the entry is a symlink or is reached through one,
+// its metadata can't be changed as that would change the target of the symlink
+func (g *extractionGuard) isSymlinked(name string) bool {
+	for path := filepath.Clean(name); path != "." && path != string(filepath.Separator); path = filepath.Dir(path) {
+		fi, err := os.Lstat(path)
+		if err != nil || fi.Mode()&os.ModeSymlink != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// checkEntry returns false if the entry has to be skipped
+func (g *extractionGuard) checkEntry(name string, mode os.FileMode, linkName string) (bool, error) {
+	g.entries++
+	if g.limits.MaxEntries > 0 && g.entries > g.limits.MaxEntries {
+		return false, ErrTooManyEntries
+	}
+
+	err := g.checkPath(name)
+	if err == nil && mode&os.ModeSymlink != 0 {
+		err = g.checkSymlink(name, linkName)
+		if err == nil {
+			g.symlinks = append(g.symlinks, name)
+		}
+	}
+	if err != nil {
+		g.rejected = append(g.rejected, rejectedEntry{name: name, reason: err})
+		return false, nil
+	}
+	return true, nil
+}
+
+// copy writes the entry data, the size is counted from the data as the headers can lie about it
+func (g *extractionGuard) copy(w io.Writer, r io.Reader) error {
+	if g.limits.MaxSize <= 0 {
+		_, err := io.Copy(w, r)
+		return err
+	}
+
+	n, err := io.Copy(w, io.LimitReader(r, g.limits.MaxSize-g.size+1))
+	g.size += n
+	if err != nil {
+		return err
+	} else if g.size > g.limits.MaxSize {
+		return ErrArchiveTooLarge
+	}
+	return nil
+}
+
+func (g *extractionGuard) report() {
+	if len(g.rejected) == 0 {
+		return
+	}
+
+	logrus.Warningf("Skipped %d entries of the archive:", len(g.rejected))
+	for _, entry := range g.rejected {
+		logrus.Warningf("  %s: %s", entry.name, entry.reason)
+	}
+}
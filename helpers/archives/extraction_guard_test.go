@@ -0,0 +1,227 @@
+package archives
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// withExtractionDir runs the test in the root directory, next to the outside directory
+func withExtractionDir(t *testing.T, f func(outside string)) {
+	dir, err := ioutil.TempDir("", "extraction")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	root := filepath.Join(dir, "root")
+	outside := filepath.Join(dir, "outside")
+	require.NoError(t, os.Mkdir(root, 0700))
+	require.NoError(t, os.Mkdir(outside, 0700))
+
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(root))
+	defer os.Chdir(wd)
+
+	f(outside)
+}
+
+func createTestZipArchive(t *testing.T, entries map[string]string, symlinks map[string]string) *zip.Reader {
+	var buffer bytes.Buffer
+	archive := zip.NewWriter(&buffer)
+	for name, data := range entries {
+		w, err := archive.Create(name)
+		require.NoError(t, err)
+		w.Write([]byte(data))
+	}
+	for name, target := range symlinks {
+		fh := &zip.FileHeader{Name: name}
+		fh.SetMode(os.ModeSymlink | 0777)
+		w, err := archive.CreateHeader(fh)
+		require.NoError(t, err)
+		w.Write([]byte(target))
+	}
+	require.NoError(t, archive.Close())
+
+	reader, err := zip.NewReader(bytes.NewReader(buffer.Bytes()), int64(buffer.Len()))
+	require.NoError(t, err)
+	return reader
+}
+
+func TestExtractZipRejectsEscapingEntries(t *testing.T) {
+	withExtractionDir(t, func(outside string) {
+		archive := createTestZipArchive(t, map[string]string{
+			"inside/file.txt":        "inside",
+			"../outside/escaped.txt": "escaped",
+			"inside/../../escaped":   "escaped",
+		}, map[string]string{
+			"inside/link":    "file.txt",
+			"escaping_link":  "../outside",
+			"absolute_link":  outside,
+			"inside/parent":  "../../outside/escaped.txt",
+			"inside/current": ".",
+		})
+
+		require.NoError(t, ExtractZipArchive(archive, ExtractionLimits{}))
+
+		data, err := ioutil.ReadFile(filepath.Join("inside", "link"))
+		assert.NoError(t, err)
+		assert.Equal(t, "inside", string(data))
+
+		for _, name := range []string{"escaping_link", "absolute_link", "inside/parent"} {
+			_, err := os.Lstat(name)
+			assert.True(t, os.IsNotExist(err), "%s should be rejected", name)
+		}
+
+		files, err := ioutil.ReadDir(outside)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, len(files), "nothing should be written outside")
+	})
+}
+
+func TestExtractZipDoesntWriteThroughSymlinks(t *testing.T) {
+	withExtractionDir(t, func(outside string) {
+		require.NoError(t, os.Symlink(outside, "vendor"))
+
+		archive := createTestZipArchive(t, map[string]string{
+			"vendor/file.txt":        "escaped",
+			"vendor/nested/file.txt": "escaped",
+		}, nil)
+		require.NoError(t, ExtractZipArchive(archive, ExtractionLimits{}))
+
+		files, err := ioutil.ReadDir(outside)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, len(files), "nothing should be written outside")
+	})
+}
+
+func TestExtractZipLimits(t *testing.T) {
+	withExtractionDir(t, func(outside string) {
+		archive := createTestZipArchive(t, map[string]string{
+			"first.txt":  strings.Repeat("0", 100),
+			"second.txt": strings.Repeat("0", 100),
+		}, nil)
+
+		assert.Equal(t, ErrArchiveTooLarge, ExtractZipArchive(archive, ExtractionLimits{MaxSize: 150}))
+		assert.Equal(t, ErrTooManyEntries, ExtractZipArchive(archive, ExtractionLimits{MaxEntries: 1}))
+		assert.NoError(t, ExtractZipArchive(archive, ExtractionLimits{MaxSize: 200, MaxEntries: 2}))
+	})
+}
+
+func createTestTarArchive(t *testing.T, entries map[string]string) *bytes.Buffer {
+	var buffer bytes.Buffer
+	archive := tar.NewWriter(&buffer)
+	for name, data := range entries {
+		require.NoError(t, archive.WriteHeader(&tar.Header{
+			Name:     name,
+			Mode:     0600,
+			Size:     int64(len(data)),
+			Typeflag: tar.TypeReg,
+		}))
+		archive.Write([]byte(data))
+	}
+	require.NoError(t, archive.Close())
+	return &buffer
+}
+
+func TestExtractTarLimits(t *testing.T) {
+	withExtractionDir(t, func(outside string) {
+		entries := map[string]string{
+			"first.txt":  strings.Repeat("0", 100),
+			"second.txt": strings.Repeat("0", 100),
+		}
+
+		err := ExtractTarArchive(createTestTarArchive(t, entries), ExtractionLimits{MaxSize: 150})
+		assert.Equal(t, ErrArchiveTooLarge, err)
+
+		err = ExtractTarArchive(createTestTarArchive(t, entries), ExtractionLimits{MaxEntries: 1})
+		assert.Equal(t, ErrTooManyEntries, err)
+	})
+}
+
+func TestExtractTarRejectsEscapingEntries(t *testing.T) {
+	withExtractionDir(t, func(outside string) {
+		archive := createTestTarArchive(t, map[string]string{
+			"file.txt":               "inside",
+			"../outside/escaped.txt": "escaped",
+		})
+		require.NoError(t, ExtractTarArchive(archive, ExtractionLimits{}))
+
+		_, err := os.Stat("file.txt")
+		assert.NoError(t, err)
+
+		files, err := ioutil.ReadDir(outside)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, len(files), "nothing should be written outside")
+	})
+}
+
+func createTestTarArchiveWithHeaders(t *testing.T, headers []*tar.Header) *bytes.Buffer {
+	var buffer bytes.Buffer
+	archive := tar.NewWriter(&buffer)
+	for _, th := range headers {
+		require.NoError(t, archive.WriteHeader(th))
+	}
+	require.NoError(t, archive.Close())
+	return &buffer
+}
+
+func TestExtractTarRejectsSymlinksEscapingThroughSymlinks(t *testing.T) {
+	withExtractionDir(t, func(outside string) {
+		archive := createTestTarArchiveWithHeaders(t, []*tar.Header{
+			{Name: "s", Linkname: ".", Typeflag: tar.TypeSymlink, Mode: 0777},
+			{Name: "x", Linkname: "s/../outside", Typeflag: tar.TypeSymlink, Mode: 0777},
+			{Name: "x", Typeflag: tar.TypeDir, Mode: 0777},
+		})
+		require.NoError(t, ExtractTarArchive(archive, ExtractionLimits{}))
+
+		_, err := os.Lstat("s")
+		assert.NoError(t, err, "the symlink inside should be extracted")
+
+		fi, err := os.Lstat("x")
+		if assert.NoError(t, err) {
+			assert.True(t, fi.IsDir(), "the escaping symlink should be rejected")
+		}
+
+		fi, err = os.Stat(outside)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0700), fi.Mode().Perm(), "the outside directory should not be changed")
+	})
+}
+
+func TestExtractTarRemovesSymlinksEscapingAfterReplacement(t *testing.T) {
+	withExtractionDir(t, func(outside string) {
+		archive := createTestTarArchiveWithHeaders(t, []*tar.Header{
+			{Name: "dir/", Typeflag: tar.TypeDir, Mode: 0700},
+			{Name: "s", Linkname: "dir", Typeflag: tar.TypeSymlink, Mode: 0777},
+			{Name: "x", Linkname: "s/../outside", Typeflag: tar.TypeSymlink, Mode: 0777},
+			{Name: "s", Linkname: ".", Typeflag: tar.TypeSymlink, Mode: 0777},
+		})
+		require.NoError(t, ExtractTarArchive(archive, ExtractionLimits{}))
+
+		_, err := os.Lstat("x")
+		assert.True(t, os.IsNotExist(err), "the symlink escaping after the replacement should be removed")
+	})
+}
+
+func TestExtractZipDoesntChangeSymlinkTargets(t *testing.T) {
+	withExtractionDir(t, func(outside string) {
+		require.NoError(t, os.Mkdir("dir", 0700))
+
+		archive := createTestZipArchive(t, nil, map[string]string{
+			"link": "dir",
+		})
+		require.NoError(t, ExtractZipArchive(archive, ExtractionLimits{}))
+
+		fi, err := os.Stat("dir")
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0700), fi.Mode().Perm(), "the symlink target should not be changed")
+	})
+}
@@ -0,0 +1,111 @@
+package archives
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+)
+
+const parallelGzipBlockSize = 1024 * 1024
+
+type gzipBlock struct {
+	data []byte
+	err  error
+}
+
+// parallelGzipWriter compresses blocks of data concurrently and writes them in order,
+// each block is a separate gzip member, which is a valid gzip stream for all readers
+type parallelGzipWriter struct {
+	w      io.Writer
+	level  int
+	block  []byte
+	queue  chan chan gzipBlock
+	done   chan error
+	closed bool
+}
+
+func (p *parallelGzipWriter) compress(data []byte, result chan gzipBlock) {
+	var buffer bytes.Buffer
+	gz, err := gzip.NewWriterLevel(&buffer, p.level)
+	if err == nil {
+		_, err = gz.Write(data)
+	}
+	if err == nil {
+		err = gz.Close()
+	}
+	result <- gzipBlock{data: buffer.Bytes(), err: err}
+}
+
+func (p *parallelGzipWriter) writeBlocks() {
+	var err error
+	for result := range p.queue {
+		block := <-result
+		if err != nil {
+			continue
+		}
+
+		err = block.err
+		if err == nil {
+			_, err = p.w.Write(block.data)
+		}
+	}
+	p.done <- err
+}
+
+func (p *parallelGzipWriter) flush() {
+	result := make(chan gzipBlock, 1)
+	// The queue is limited, so only a few blocks are compressed at the same time
+	p.queue <- result
+	go p.compress(p.block, result)
+	p.block = make([]byte, 0, parallelGzipBlockSize)
+}
+
+func (p *parallelGzipWriter) Write(data []byte) (n int, err error) {
+	for len(data) > 0 {
+		size := parallelGzipBlockSize - len(p.block)
+		if size > len(data) {
+			size = len(data)
+		}
+
+		p.block = append(p.block, data[:size]...)
+		data = data[size:]
+		n += size
+
+		if len(p.block) == parallelGzipBlockSize {
+			p.flush()
+		}
+	}
+	return
+}
+
+func (p *parallelGzipWriter) Close() error {
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+
+	p.flush()
+	close(p.queue)
+	return <-p.done
+}
+
+func newGzipWriter(w io.Writer, level, workers int) (io.WriteCloser, error) {
+	if workers <= 1 {
+		return gzip.NewWriterLevel(w, level)
+	}
+
+	// Check the level upfront, the compression is done later
+	if _, err := gzip.NewWriterLevel(nil, level); err != nil {
+		return nil, err
+	}
+
+	writer := &parallelGzipWriter{
+		w:     w,
+		level: level,
+		block: make([]byte, 0, parallelGzipBlockSize),
+		queue: make(chan chan gzipBlock, workers),
+		done:  make(chan error, 1),
+	}
+	go writer.writeBlocks()
+	return writer, nil
+}
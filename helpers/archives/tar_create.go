@@ -0,0 +1,68 @@
+package archives
+
+import (
+	"archive/tar"
+	"io"
+	"os"
+
+	"github.com/Sirupsen/logrus"
+)
+
+func createTarEntry(archive *tar.Writer, fileName string) error {
+	fi, err := os.Lstat(fileName)
+	if err != nil {
+		logrus.Warningln("File ignored:", err)
+		return nil
+	}
+
+	var link string
+	switch fi.Mode() & os.ModeType {
+	case os.ModeNamedPipe, os.ModeSocket, os.ModeDevice:
+		// Ignore the files that of these types
+		logrus.Warningln("File ignored:", fileName)
+		return nil
+
+	case os.ModeSymlink:
+		link, err = os.Readlink(fileName)
+		if err != nil {
+			return err
+		}
+	}
+
+	th, err := tar.FileInfoHeader(fi, link)
+	if err != nil {
+		return err
+	}
+	th.Name = fileName
+	if fi.IsDir() {
+		th.Name += "/"
+	}
+
+	err = archive.WriteHeader(th)
+	if err != nil || !fi.Mode().IsRegular() {
+		return err
+	}
+
+	file, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// The header has the size of the file, it can't change while it's archived
+	_, err = io.CopyN(archive, file, th.Size)
+	return err
+}
+
+func CreateTarArchive(w io.Writer, fileNames []string) error {
+	archive := tar.NewWriter(w)
+
+	for _, fileName := range fileNames {
+		err := createTarEntry(archive, fileName)
+		if err != nil {
+			return err
+		}
+	}
+
+	return archive.Close()
+}
@@ -0,0 +1,102 @@
+package archives
+
+import (
+	"archive/tar"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/Sirupsen/logrus"
+)
+
+func extractTarEntry(archive *tar.Reader, th *tar.Header, guard *extractionGuard) (err error) {
+	// Create all parents to extract the file
+	os.MkdirAll(filepath.Dir(th.Name), 0777)
+
+	switch th.Typeflag {
+	case tar.TypeDir:
+		err = os.Mkdir(th.Name, os.FileMode(th.Mode).Perm())
+
+		// The error that directory does exists is not a error for us
+		if os.IsExist(err) {
+			err = nil
+		}
+
+	case tar.TypeSymlink:
+		// Remove symlink before creating a new one, otherwise we can error that file does exist
+		os.Remove(th.Name)
+		err = os.Symlink(th.Linkname, th.Name)
+
+	case tar.TypeReg, tar.TypeRegA:
+		var out *os.File
+
+		// Remove file before creating a new one, otherwise we can error that file does exist
+		os.Remove(th.Name)
+		out, err = os.OpenFile(th.Name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, os.FileMode(th.Mode).Perm())
+		if err != nil {
+			return err
+		}
+		defer out.Close()
+		err = guard.copy(out, archive)
+
+	default:
+		// Ignore the files that of these types
+		logrus.Warningf("File ignored: %q", th.Name)
+	}
+	return
+}
+
+func ExtractTarArchive(r io.Reader, limits ExtractionLimits) error {
+	guard, err := newExtractionGuard(limits)
+	if err != nil {
+		return err
+	}
+	defer guard.report()
+
+	tracker := newPathErrorTracker()
+	archive := tar.NewReader(r)
+
+	var headers []*tar.Header
+	for {
+		th, err := archive.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		extracted, err := guard.checkEntry(th.Name, th.FileInfo().Mode(), th.Linkname)
+		if err != nil {
+			return err
+		} else if !extracted {
+			continue
+		}
+
+		err = extractTarEntry(archive, th, guard)
+		if IsExtractionLimitError(err) {
+			return err
+		} else if tracker.actionable(err) {
+			logrus.Warningf("%s: %s (suppressing repeats)", th.Name, err)
+		}
+		headers = append(headers, th)
+	}
+
+	guard.checkSymlinks()
+
+	for _, th := range headers {
+		if guard.isSymlinked(th.Name) {
+			continue
+		}
+
+		// Update file permissions
+		if err := os.Chmod(th.Name, os.FileMode(th.Mode).Perm()); tracker.actionable(err) {
+			logrus.Warningf("%s: %s (suppressing repeats)", th.Name, err)
+		}
+
+		// Restore modification time
+		if err := os.Chtimes(th.Name, th.ModTime, th.ModTime); tracker.actionable(err) {
+			logrus.Warningf("%s: %s (suppressing repeats)", th.Name, err)
+		}
+	}
+	return nil
+}
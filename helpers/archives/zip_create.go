@@ -2,10 +2,9 @@ package archives
 
 import (
 	"archive/zip"
+	"compress/flate"
 	"io"
-	"io/ioutil"
 	"os"
-	"path/filepath"
 
 	"github.com/Sirupsen/logrus"
 )
@@ -51,73 +50,79 @@ func createZipFileEntry(archive *zip.Writer, fh *zip.FileHeader) error {
 	return nil
 }
 
-func createZipEntry(archive *zip.Writer, fileName string) error {
+// createZipEntry returns the header of the written entry, or nil if the file was ignored
+func createZipEntry(archive *zip.Writer, fileName string) (*zip.FileHeader, error) {
 	fi, err := os.Lstat(fileName)
 	if err != nil {
 		logrus.Warningln("File ignored:", err)
-		return nil
+		return nil, nil
 	}
 
 	fh, err := zip.FileInfoHeader(fi)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	fh.Name = fileName
 	fh.Extra = createZipExtra(fi)
 
 	switch fi.Mode() & os.ModeType {
 	case os.ModeDir:
-		return createZipDirectoryEntry(archive, fh)
+		return fh, createZipDirectoryEntry(archive, fh)
 
 	case os.ModeSymlink:
-		return createZipSymlinkEntry(archive, fh)
+		return fh, createZipSymlinkEntry(archive, fh)
 
 	case os.ModeNamedPipe, os.ModeSocket, os.ModeDevice:
 		// Ignore the files that of these types
 		logrus.Warningln("File ignored:", fileName)
-		return nil
+		return nil, nil
 
 	default:
-		return createZipFileEntry(archive, fh)
+		return fh, createZipFileEntry(archive, fh)
 	}
 }
 
-func CreateZipArchive(w io.Writer, fileNames []string) error {
+// createZipArchive writes the metadata, if requested, when the archive is complete,
+// the headers are filled with the sizes and checksums by the zip.Writer
+func createZipArchive(w io.Writer, metadata io.Writer, fileNames []string, level int) error {
 	archive := zip.NewWriter(w)
 	defer archive.Close()
 
+	if level != flate.DefaultCompression {
+		archive.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+			return flate.NewWriter(out, level)
+		})
+	}
+
+	var headers []*zip.FileHeader
 	for _, fileName := range fileNames {
-		err := createZipEntry(archive, fileName)
+		fh, err := createZipEntry(archive, fileName)
 		if err != nil {
 			return err
+		} else if fh != nil {
+			headers = append(headers, fh)
 		}
 	}
 
-	return nil
-}
-
-func CreateZipFile(fileName string, fileNames []string) error {
-	// create directories to store archive
-	os.MkdirAll(filepath.Dir(fileName), 0700)
-
-	tempFile, err := ioutil.TempFile(filepath.Dir(fileName), "archive_")
-	if err != nil {
+	err := archive.Close()
+	if err != nil || metadata == nil {
 		return err
 	}
-	defer tempFile.Close()
-	defer os.Remove(tempFile.Name())
+	return writeZipMetadata(metadata, headers)
+}
 
-	logrus.Debugln("Temporary file:", tempFile.Name())
-	err = CreateZipArchive(tempFile, fileNames)
-	if err != nil {
-		return err
-	}
-	tempFile.Close()
+func CreateZipArchive(w io.Writer, fileNames []string) error {
+	return createZipArchive(w, nil, fileNames, flate.DefaultCompression)
+}
 
-	err = os.Rename(tempFile.Name(), fileName)
-	if err != nil {
-		return err
-	}
+// CreateZipArchiveWithMetadata writes the index of the archive entries to the metadata
+func CreateZipArchiveWithMetadata(w io.Writer, metadata io.Writer, fileNames []string, level int) error {
+	return createZipArchive(w, metadata, fileNames, level)
+}
 
-	return nil
+func CreateZipFile(fileName string, fileNames []string) error {
+	return CreateArchiveFile(fileName, fileNames, ArchiveOptions{
+		Format:           ZipArchive,
+		CompressionLevel: flate.DefaultCompression,
+	})
 }
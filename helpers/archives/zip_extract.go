@@ -20,26 +20,31 @@ func extractZipDirectoryEntry(file *zip.File) (err error) {
 	return
 }
 
-func extractZipSymlinkEntry(file *zip.File) (err error) {
-	var data []byte
+// zipSymlinkMaxSize limits the data read as the symlink target
+const zipSymlinkMaxSize = 4096
+
+func readZipSymlink(file *zip.File) (string, error) {
 	in, err := file.Open()
 	if err != nil {
-		return err
+		return "", err
 	}
 	defer in.Close()
 
-	data, err = ioutil.ReadAll(in)
+	data, err := ioutil.ReadAll(io.LimitReader(in, zipSymlinkMaxSize))
 	if err != nil {
-		return err
+		return "", err
 	}
+	return string(data), nil
+}
 
+func extractZipSymlinkEntry(file *zip.File, linkName string) (err error) {
 	// Remove symlink before creating a new one, otherwise we can error that file does exist
 	os.Remove(file.Name)
-	err = os.Symlink(string(data), file.Name)
+	err = os.Symlink(linkName, file.Name)
 	return
 }
 
-func extractZipFileEntry(file *zip.File) (err error) {
+func extractZipFileEntry(file *zip.File, guard *extractionGuard) (err error) {
 	var out *os.File
 	in, err := file.Open()
 	if err != nil {
@@ -54,12 +59,25 @@ func extractZipFileEntry(file *zip.File) (err error) {
 		return err
 	}
 	defer out.Close()
-	_, err = io.Copy(out, in)
 
-	return
+	return guard.copy(out, in)
 }
 
-func extractZipFile(file *zip.File) (err error) {
+// extractZipFile returns false if the entry was rejected
+func extractZipFile(file *zip.File, guard *extractionGuard) (extracted bool, err error) {
+	var linkName string
+	if file.Mode()&os.ModeSymlink != 0 {
+		linkName, err = readZipSymlink(file)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	extracted, err = guard.checkEntry(file.Name, file.Mode(), linkName)
+	if !extracted || err != nil {
+		return
+	}
+
 	// Create all parents to extract the file
 	os.MkdirAll(filepath.Dir(file.Name), 0777)
 
@@ -68,28 +86,69 @@ func extractZipFile(file *zip.File) (err error) {
 		err = extractZipDirectoryEntry(file)
 
 	case os.ModeSymlink:
-		err = extractZipSymlinkEntry(file)
+		err = extractZipSymlinkEntry(file, linkName)
 
 	case os.ModeNamedPipe, os.ModeSocket, os.ModeDevice:
 		// Ignore the files that of these types
 		logrus.Warningln("File ignored: %q", file.Name)
 
 	default:
-		err = extractZipFileEntry(file)
+		err = extractZipFileEntry(file, guard)
 	}
 	return
 }
 
-func ExtractZipArchive(archive *zip.Reader) error {
+// checkZipLimits rejects the archive upfront, the extracted data is counted again
+// as the sizes in the headers can lie
+func checkZipLimits(archive *zip.Reader, limits ExtractionLimits) error {
+	if limits.MaxEntries > 0 && len(archive.File) > limits.MaxEntries {
+		return ErrTooManyEntries
+	}
+
+	var size uint64
+	for _, file := range archive.File {
+		size += file.UncompressedSize64
+	}
+	if limits.MaxSize > 0 && size > uint64(limits.MaxSize) {
+		return ErrArchiveTooLarge
+	}
+	return nil
+}
+
+func ExtractZipArchive(archive *zip.Reader, limits ExtractionLimits) error {
+	err := checkZipLimits(archive, limits)
+	if err != nil {
+		return err
+	}
+
+	guard, err := newExtractionGuard(limits)
+	if err != nil {
+		return err
+	}
+	defer guard.report()
+
 	tracker := newPathErrorTracker()
 
+	var extractedFiles []*zip.File
 	for _, file := range archive.File {
-		if err := extractZipFile(file); tracker.actionable(err) {
+		extracted, err := extractZipFile(file, guard)
+		if IsExtractionLimitError(err) {
+			return err
+		} else if tracker.actionable(err) {
 			logrus.Warningf("%s: %s (suppressing repeats)", file.Name, err)
 		}
+		if extracted {
+			extractedFiles = append(extractedFiles, file)
+		}
 	}
 
-	for _, file := range archive.File {
+	guard.checkSymlinks()
+
+	for _, file := range extractedFiles {
+		if guard.isSymlinked(file.Name) {
+			continue
+		}
+
 		// Update file permissions
 		if err := os.Chmod(file.Name, file.Mode().Perm()); tracker.actionable(err) {
 			logrus.Warningf("%s: %s (suppressing repeats)", file.Name, err)
@@ -103,12 +162,32 @@ func ExtractZipArchive(archive *zip.Reader) error {
 	return nil
 }
 
-func ExtractZipFile(fileName string) error {
+func ExtractZipFile(fileName string, limits ExtractionLimits) error {
+	archive, err := zip.OpenReader(fileName)
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+
+	return ExtractZipArchive(&archive.Reader, limits)
+}
+
+// ExtractZipFileSelected extracts only the entries accepted by the selected function
+func ExtractZipFileSelected(fileName string, limits ExtractionLimits, selected func(name string) bool) error {
 	archive, err := zip.OpenReader(fileName)
 	if err != nil {
 		return err
 	}
 	defer archive.Close()
 
-	return ExtractZipArchive(&archive.Reader)
+	selectedArchive := &zip.Reader{
+		Comment: archive.Comment,
+	}
+	for _, file := range archive.File {
+		if selected(file.Name) {
+			selectedArchive.File = append(selectedArchive.File, file)
+		}
+	}
+
+	return ExtractZipArchive(selectedArchive, limits)
 }
@@ -0,0 +1,78 @@
+package archives
+
+import (
+	"archive/zip"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"strconv"
+)
+
+// The metadata lets GitLab browse the archive and serve single files without reading it whole,
+// it's a gzipped stream of length prefixed strings: the version, the errors and a path followed
+// by the JSON encoded ZipEntryMetadata for every entry
+const ZipMetadataVersion = "GitLab Build Artifacts Metadata 0.0.2\n"
+
+type ZipEntryMetadata struct {
+	Modified int64  `json:"modified,omitempty"`
+	Mode     string `json:"mode,omitempty"`
+	CRC      uint32 `json:"crc,omitempty"`
+	Size     uint64 `json:"size,omitempty"`
+	Zipped   uint64 `json:"zipped,omitempty"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+func newZipEntryMetadata(fh *zip.FileHeader) ZipEntryMetadata {
+	return ZipEntryMetadata{
+		Modified: fh.ModTime().Unix(),
+		Mode:     strconv.FormatUint(uint64(fh.Mode()), 8),
+		CRC:      fh.CRC32,
+		Size:     fh.UncompressedSize64,
+		Zipped:   fh.CompressedSize64,
+		Comment:  fh.Comment,
+	}
+}
+
+func writeZipMetadataBytes(w io.Writer, data []byte) error {
+	err := binary.Write(w, binary.BigEndian, uint32(len(data)))
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// writeZipMetadata writes the metadata of the headers filled by the closed zip.Writer
+func writeZipMetadata(w io.Writer, headers []*zip.FileHeader) error {
+	gz := gzip.NewWriter(w)
+
+	err := writeZipMetadataBytes(gz, []byte(ZipMetadataVersion))
+	if err != nil {
+		return err
+	}
+
+	err = writeZipMetadataBytes(gz, []byte("{}"))
+	if err != nil {
+		return err
+	}
+
+	for _, fh := range headers {
+		data, err := json.Marshal(newZipEntryMetadata(fh))
+		if err != nil {
+			return err
+		}
+
+		err = writeZipMetadataBytes(gz, []byte(fh.Name))
+		if err != nil {
+			return err
+		}
+
+		err = writeZipMetadataBytes(gz, data)
+		if err != nil {
+			return err
+		}
+	}
+
+	return gz.Close()
+}
@@ -0,0 +1,94 @@
+package archives
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readZipMetadataBytes(r io.Reader) ([]byte, error) {
+	var size uint32
+	err := binary.Read(r, binary.BigEndian, &size)
+	if err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, size)
+	_, err = io.ReadFull(r, data)
+	return data, err
+}
+
+// readZipMetadata returns the metadata of the archive entries by their paths
+func readZipMetadata(r io.Reader) (map[string]ZipEntryMetadata, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	version, err := readZipMetadataBytes(gz)
+	if err != nil {
+		return nil, err
+	} else if string(version) != ZipMetadataVersion {
+		return nil, ErrUnknownArchiveFormat
+	}
+
+	// Skip the errors
+	_, err = readZipMetadataBytes(gz)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make(map[string]ZipEntryMetadata)
+	for {
+		name, err := readZipMetadataBytes(gz)
+		if err == io.EOF {
+			return entries, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		data, err := readZipMetadataBytes(gz)
+		if err != nil {
+			return nil, err
+		}
+
+		var entry ZipEntryMetadata
+		err = json.Unmarshal(data, &entry)
+		if err != nil {
+			return nil, err
+		}
+		entries[string(name)] = entry
+	}
+}
+
+func TestZipArchiveMetadata(t *testing.T) {
+	var archive, metadata bytes.Buffer
+	err := CreateZipArchiveWithMetadata(&archive, &metadata, []string{"zip_metadata.go", "."}, flate.DefaultCompression)
+	require.NoError(t, err)
+
+	reader, err := zip.NewReader(bytes.NewReader(archive.Bytes()), int64(archive.Len()))
+	require.NoError(t, err)
+
+	entries, err := readZipMetadata(&metadata)
+	require.NoError(t, err)
+	require.Equal(t, len(reader.File), len(entries))
+
+	for _, file := range reader.File {
+		entry, ok := entries[file.Name]
+		require.True(t, ok, "missing metadata of %s", file.Name)
+		assert.Equal(t, file.CRC32, entry.CRC)
+		assert.Equal(t, file.UncompressedSize64, entry.Size)
+		assert.Equal(t, file.CompressedSize64, entry.Zipped)
+		assert.Equal(t, file.ModTime().Unix(), entry.Modified)
+	}
+	assert.NotEqual(t, uint32(0), entries["zip_metadata.go"].CRC)
+}
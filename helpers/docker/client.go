@@ -12,9 +12,12 @@ type Client interface {
 	WaitContainer(id string) (int, error)
 	KillContainer(opts docker.KillContainerOptions) error
 	InspectContainer(id string) (*docker.Container, error)
+	ListContainers(opts docker.ListContainersOptions) ([]docker.APIContainers, error)
 	AttachToContainer(opts docker.AttachToContainerOptions) error
 	RemoveContainer(opts docker.RemoveContainerOptions) error
 	Logs(opts docker.LogsOptions) error
+	UploadToContainer(id string, opts docker.UploadToContainerOptions) error
+	DownloadFromContainer(id string, opts docker.DownloadFromContainerOptions) error
 
 	Info() (*docker.Env, error)
 }
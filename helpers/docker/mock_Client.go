@@ -77,6 +77,17 @@ func (m *MockClient) InspectContainer(id string) (*docker.Container, error) {
 
 	return r0, r1
 }
+func (m *MockClient) ListContainers(opts docker.ListContainersOptions) ([]docker.APIContainers, error) {
+	ret := m.Called(opts)
+
+	var r0 []docker.APIContainers
+	if ret.Get(0) != nil {
+		r0 = ret.Get(0).([]docker.APIContainers)
+	}
+	r1 := ret.Error(1)
+
+	return r0, r1
+}
 func (m *MockClient) AttachToContainer(opts docker.AttachToContainerOptions) error {
 	ret := m.Called(opts)
 
@@ -98,6 +109,20 @@ func (m *MockClient) Logs(opts docker.LogsOptions) error {
 
 	return r0
 }
+func (m *MockClient) UploadToContainer(id string, opts docker.UploadToContainerOptions) error {
+	ret := m.Called(id, opts)
+
+	r0 := ret.Error(0)
+
+	return r0
+}
+func (m *MockClient) DownloadFromContainer(id string, opts docker.DownloadFromContainerOptions) error {
+	ret := m.Called(id, opts)
+
+	r0 := ret.Error(0)
+
+	return r0
+}
 func (m *MockClient) Info() (*docker.Env, error) {
 	ret := m.Called()
 
@@ -0,0 +1,20 @@
+package url_helpers
+
+import (
+	"net/url"
+	"path/filepath"
+)
+
+// FilePath returns the local path of a file:// URL, on Windows the path of
+// file:///C:/dir/file has a leading slash before the drive letter that has to be dropped
+func FilePath(u *url.URL) string {
+	p := u.Path
+	if len(p) >= 3 && p[0] == '/' && p[2] == ':' && isDriveLetter(p[1]) {
+		p = p[1:]
+	}
+	return filepath.FromSlash(p)
+}
+
+func isDriveLetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
@@ -0,0 +1,23 @@
+package url_helpers
+
+import (
+	"net/url"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilePath(t *testing.T) {
+	examples := map[string]string{
+		"file:///cache/project/key.zip":    "/cache/project/key.zip",
+		"file:///C:/cache/project/key.zip": "C:/cache/project/key.zip",
+		"file:///d:/key.zip":               "d:/key.zip",
+	}
+
+	for fileURL, expected := range examples {
+		u, err := url.Parse(fileURL)
+		assert.NoError(t, err)
+		assert.Equal(t, filepath.FromSlash(expected), FilePath(u), fileURL)
+	}
+}
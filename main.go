@@ -10,6 +10,9 @@ import (
 	"gitlab.com/gitlab-org/gitlab-ci-multi-runner/helpers/cli"
 	"gitlab.com/gitlab-org/gitlab-ci-multi-runner/helpers/formatter"
 
+	_ "gitlab.com/gitlab-org/gitlab-ci-multi-runner/cache/filesystem"
+	_ "gitlab.com/gitlab-org/gitlab-ci-multi-runner/cache/s3"
+	_ "gitlab.com/gitlab-org/gitlab-ci-multi-runner/cache/server"
 	_ "gitlab.com/gitlab-org/gitlab-ci-multi-runner/commands"
 	_ "gitlab.com/gitlab-org/gitlab-ci-multi-runner/commands/helpers"
 	_ "gitlab.com/gitlab-org/gitlab-ci-multi-runner/executors/docker"
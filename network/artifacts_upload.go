@@ -0,0 +1,304 @@
+package network
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"gitlab.com/gitlab-org/gitlab-ci-multi-runner/common"
+)
+
+const artifactsChunkSize = 8 * 1024 * 1024
+
+// maxArtifactsChunkSize limits the memory used for the chunk size requested by GitLab
+const maxArtifactsChunkSize = 64 * 1024 * 1024
+
+// artifactsUpload sends the archive in chunks, a failed chunk is resumed from the offset
+// acknowledged by GitLab. Only the current chunk is kept in memory, so the archive
+// can be uploaded while it's created. The failed upload is resumed by the next upload
+// of the same archive, the acknowledged part of the archive is then only read again.
+type artifactsUpload struct {
+	ID        string `json:"id"`
+	ChunkSize int    `json:"chunk_size"`
+
+	client   *GitLabClient
+	config   common.BuildCredentials
+	runner   common.RunnerCredentials
+	log      *logrus.Entry
+	policy   RetryPolicy
+	offset   int64
+	checksum hash.Hash
+
+	// broken is set if the upload can't be resumed
+	broken bool
+}
+
+func (u *artifactsUpload) uri() string {
+	return fmt.Sprintf("builds/%d/artifacts/uploads/%s", u.config.ID, url.QueryEscape(u.ID))
+}
+
+func (u *artifactsUpload) headers() http.Header {
+	headers := make(http.Header)
+	headers.Set("BUILD-TOKEN", u.config.Token)
+	return headers
+}
+
+// updateOffset uses the range acknowledged by GitLab, given as bytes=0-<last byte>,
+// the end of the range is inclusive. Without the range nothing was stored yet.
+func (u *artifactsUpload) updateOffset(res *http.Response) {
+	remoteRange := res.Header.Get("Range")
+	if remoteRange == "" {
+		u.offset = 0
+		return
+	}
+
+	var start, end int64
+	_, err := fmt.Sscanf(remoteRange, "bytes=%d-%d", &start, &end)
+	if err == nil && start == 0 && end >= 0 {
+		u.offset = end + 1
+	}
+}
+
+func (u *artifactsUpload) queryOffset() {
+	res, err := u.client.doRaw(u.runner, retryArtifacts, "HEAD", u.uri(), nil, "", u.headers())
+	if err != nil {
+		return
+	}
+	defer res.Body.Close()
+	defer io.Copy(ioutil.Discard, res.Body)
+
+	if res.StatusCode == 200 {
+		u.updateOffset(res)
+	}
+}
+
+func (u *artifactsUpload) putChunk(data []byte) common.UploadState {
+	chunkChecksum := sha256.Sum256(data)
+	contentRange := fmt.Sprintf("bytes %d-%d/*", u.offset, u.offset+int64(len(data))-1)
+
+	headers := u.headers()
+	headers.Set("Content-Range", contentRange)
+	headers.Set("Content-SHA256", fmt.Sprintf("%x", chunkChecksum))
+
+	res, err := u.client.doRaw(u.runner, retryArtifactsChunk, "PUT", u.uri(), bytes.NewReader(data), "application/octet-stream", headers)
+	if err != nil {
+		u.log.WithError(err).Warningln("Uploading artifacts chunk to coordinator...", "error")
+		return common.UploadFailed
+	}
+	defer res.Body.Close()
+	defer io.Copy(ioutil.Discard, res.Body)
+
+	log := u.log.WithFields(logrus.Fields{
+		"sent-range":   contentRange,
+		"remote-range": res.Header.Get("Range"),
+		"status":       res.Status,
+	})
+
+	switch res.StatusCode {
+	case 202:
+		u.offset += int64(len(data))
+		if res.Header.Get("Range") != "" {
+			u.updateOffset(res)
+		}
+		log.Debugln("Uploading artifacts chunk to coordinator...", "ok")
+		return common.UploadSucceeded
+	case 403:
+		log.Errorln("Uploading artifacts chunk to coordinator...", "forbidden")
+		return common.UploadForbidden
+	case 413:
+		log.Errorln("Uploading artifacts chunk to coordinator...", "too large archive")
+		return common.UploadTooLarge
+	case 404:
+		log.Warningln("Uploading artifacts chunk to coordinator...", "upload not found")
+		u.broken = true
+		return common.UploadFailed
+	case 416:
+		log.Warningln("Uploading artifacts chunk to coordinator...", "range mismatch")
+		u.updateOffset(res)
+		return common.UploadFailed
+	default:
+		log.Warningln("Uploading artifacts chunk to coordinator...", "failed")
+		return common.UploadFailed
+	}
+}
+
+// uploadChunk resends the part of the chunk that was not acknowledged
+func (u *artifactsUpload) uploadChunk(chunk []byte) common.UploadState {
+	start := u.offset
+	end := start + int64(len(chunk))
+
+	state := common.UploadFailed
+	u.policy.Do(u.log, func() (bool, time.Duration) {
+		state = u.putChunk(chunk[u.offset-start:])
+		if state == common.UploadForbidden || state == common.UploadTooLarge || u.broken {
+			return false, 0
+		} else if state != common.UploadSucceeded {
+			u.queryOffset()
+		}
+
+		// The data before the chunk is not available anymore
+		if u.offset < start || u.offset > end {
+			u.log.WithField("offset", u.offset).Errorln("Uploading artifacts chunk to coordinator...", "can't resume")
+			u.broken = true
+			state = common.UploadFailed
+			return false, 0
+		} else if u.offset < end {
+			state = common.UploadFailed
+			return true, 0
+		}
+
+		state = common.UploadSucceeded
+		return false, 0
+	})
+
+	if state == common.UploadSucceeded {
+		u.checksum.Write(chunk)
+	}
+	return state
+}
+
+// finish sends the checksum of the whole archive, so GitLab can verify it
+func (u *artifactsUpload) finish(metadata io.Reader) common.UploadState {
+	if metadata == nil {
+		metadata = bytes.NewReader(nil)
+	}
+
+	headers := u.headers()
+	headers.Set("Content-SHA256", fmt.Sprintf("%x", u.checksum.Sum(nil)))
+	headers.Set("Upload-Length", strconv.FormatInt(u.offset, 10))
+
+	res, err := u.client.doRaw(u.runner, retryArtifacts, "POST", u.uri(), metadata, "application/gzip", headers)
+	if err != nil {
+		u.log.WithError(err).Errorln("Uploading artifacts to coordinator...", "error")
+		return common.UploadFailed
+	}
+	defer res.Body.Close()
+	defer io.Copy(ioutil.Discard, res.Body)
+
+	log := u.log.WithField("responseStatus", res.Status)
+
+	switch res.StatusCode {
+	case 201:
+		log.Println("Uploading artifacts to coordinator...", "ok")
+		return common.UploadSucceeded
+	case 403:
+		log.Errorln("Uploading artifacts to coordinator...", "forbidden")
+		return common.UploadForbidden
+	case 413:
+		log.Errorln("Uploading artifacts to coordinator...", "too large archive")
+		return common.UploadTooLarge
+	case 422:
+		log.Errorln("Uploading artifacts to coordinator...", "checksum mismatch")
+		u.broken = true
+		return common.UploadFailed
+	default:
+		log.Warningln("Uploading artifacts to coordinator...", "failed")
+		return common.UploadFailed
+	}
+}
+
+// resume skips the part of the archive acknowledged by GitLab, it's only added to the checksum
+func (u *artifactsUpload) resume(reader io.Reader) common.UploadState {
+	u.checksum = sha256.New()
+	u.queryOffset()
+
+	_, err := io.CopyN(u.checksum, reader, u.offset)
+	if err != nil {
+		u.log.WithError(err).Errorln("Resuming artifacts upload...", "can't resume")
+		u.broken = true
+		return common.UploadFailed
+	}
+
+	u.log.WithField("offset", u.offset).Infoln("Resuming artifacts upload...", "ok")
+	return common.UploadSucceeded
+}
+
+func (u *artifactsUpload) upload(reader io.Reader, metadata io.Reader) common.UploadState {
+	chunk := make([]byte, u.ChunkSize)
+	for {
+		n, err := io.ReadFull(reader, chunk)
+		if n > 0 {
+			state := u.uploadChunk(chunk[:n])
+			if state != common.UploadSucceeded {
+				return state
+			}
+		}
+
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		} else if err != nil {
+			u.log.WithError(err).Errorln("Uploading artifacts to coordinator...", "error")
+			return common.UploadFailed
+		}
+	}
+
+	return u.finish(metadata)
+}
+
+// startArtifactsUpload returns false if GitLab doesn't support the chunked uploads
+func (n *GitLabClient) startArtifactsUpload(config common.BuildCredentials, runner common.RunnerCredentials, log *logrus.Entry, baseName string, expireIn string) (*artifactsUpload, common.UploadState, bool) {
+	query := url.Values{}
+	query.Set("filename", baseName)
+	if expireIn != "" {
+		query.Set("expire_in", expireIn)
+	}
+
+	headers := make(http.Header)
+	headers.Set("BUILD-TOKEN", config.Token)
+	headers.Set("Accept", "application/json")
+	res, err := n.doRaw(runner, retryArtifacts, "POST", fmt.Sprintf("builds/%d/artifacts/uploads?%s", config.ID, query.Encode()), nil, "", headers)
+	if err != nil {
+		log.WithError(err).Errorln("Starting artifacts upload...", "error")
+		return nil, common.UploadFailed, true
+	}
+	defer res.Body.Close()
+	defer io.Copy(ioutil.Discard, res.Body)
+
+	switch res.StatusCode {
+	case 201:
+		upload := &artifactsUpload{
+			client:   n,
+			config:   config,
+			runner:   runner,
+			log:      log,
+			policy:   NewRetryPolicy(runner.Retry, retryArtifacts),
+			checksum: sha256.New(),
+		}
+
+		err = json.NewDecoder(res.Body).Decode(upload)
+		if err != nil || upload.ID == "" {
+			log.WithError(err).Errorln("Starting artifacts upload...", "invalid response")
+			return nil, common.UploadFailed, true
+		}
+
+		if upload.ChunkSize <= 0 {
+			upload.ChunkSize = artifactsChunkSize
+		} else if upload.ChunkSize > maxArtifactsChunkSize {
+			upload.ChunkSize = maxArtifactsChunkSize
+		}
+		log.WithField("chunkSize", upload.ChunkSize).Debugln("Starting artifacts upload...", "ok")
+		return upload, common.UploadSucceeded, true
+	case 403:
+		log.WithField("status", res.Status).Errorln("Starting artifacts upload...", "forbidden")
+		return nil, common.UploadForbidden, true
+	case 413:
+		log.WithField("status", res.Status).Errorln("Starting artifacts upload...", "too large archive")
+		return nil, common.UploadTooLarge, true
+	case 404, 405:
+		log.Debugln("Starting artifacts upload...", "not supported")
+		return nil, common.UploadFailed, false
+	default:
+		log.WithField("status", res.Status).Warningln("Starting artifacts upload...", "failed")
+		return nil, common.UploadFailed, true
+	}
+}
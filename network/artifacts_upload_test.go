@@ -0,0 +1,196 @@
+package network
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+
+	. "gitlab.com/gitlab-org/gitlab-ci-multi-runner/common"
+)
+
+type testChunkedArtifactsServer struct {
+	t           *testing.T
+	stored      []byte
+	metadata    []byte
+	chunkSize   int
+	failOnce    bool
+	corrupt     bool
+	started     int
+	chunkCalled int
+}
+
+// setRange acknowledges the stored bytes, the end of the range is inclusive
+func (s *testChunkedArtifactsServer) setRange(w http.ResponseWriter) {
+	if len(s.stored) > 0 {
+		w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", len(s.stored)-1))
+	}
+}
+
+func (s *testChunkedArtifactsServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("BUILD-TOKEN") != "token" {
+		w.WriteHeader(403)
+		return
+	}
+
+	switch {
+	case r.Method == "POST" && r.URL.Path == "/ci/api/v1/builds/10/artifacts/uploads":
+		assert.Equal(s.t, "artifacts.zip", r.URL.Query().Get("filename"))
+		s.started++
+		chunkSize := s.chunkSize
+		if chunkSize == 0 {
+			chunkSize = 4
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(201)
+		fmt.Fprintf(w, `{"id":"upload","chunk_size":%d}`, chunkSize)
+
+	case r.Method == "HEAD" && r.URL.Path == "/ci/api/v1/builds/10/artifacts/uploads/upload":
+		s.setRange(w)
+		w.WriteHeader(200)
+
+	case r.Method == "PUT" && r.URL.Path == "/ci/api/v1/builds/10/artifacts/uploads/upload":
+		s.chunkCalled++
+		body, _ := ioutil.ReadAll(r.Body)
+		assert.Equal(s.t, fmt.Sprintf("%x", sha256.Sum256(body)), r.Header.Get("Content-SHA256"))
+
+		var start, end int
+		_, err := fmt.Sscanf(r.Header.Get("Content-Range"), "bytes %d-%d/*", &start, &end)
+		assert.NoError(s.t, err)
+		assert.Equal(s.t, len(body), end-start+1, "the end of the range is inclusive")
+		if start != len(s.stored) {
+			s.setRange(w)
+			w.WriteHeader(416)
+			return
+		}
+
+		// Store only a part of the chunk, as if the connection was broken
+		if s.failOnce && len(s.stored) > 0 {
+			s.failOnce = false
+			s.stored = append(s.stored, body[:len(body)/2]...)
+			w.WriteHeader(500)
+			return
+		}
+
+		s.stored = append(s.stored, body...)
+		s.setRange(w)
+		w.WriteHeader(202)
+
+	case r.Method == "POST" && r.URL.Path == "/ci/api/v1/builds/10/artifacts/uploads/upload":
+		s.metadata, _ = ioutil.ReadAll(r.Body)
+		if s.corrupt {
+			s.stored[0]++
+		}
+
+		assert.Equal(s.t, strconv.Itoa(len(s.stored)), r.Header.Get("Upload-Length"))
+		if r.Header.Get("Content-SHA256") != fmt.Sprintf("%x", sha256.Sum256(s.stored)) {
+			w.WriteHeader(422)
+			return
+		}
+		w.WriteHeader(201)
+
+	default:
+		w.WriteHeader(404)
+	}
+}
+
+func uploadChunkedTestArtifacts(server *testChunkedArtifactsServer) UploadState {
+	s := httptest.NewServer(server)
+	defer s.Close()
+
+	c := GitLabClient{}
+	return uploadChunkedTestArtifactsWithClient(&c, s.URL)
+}
+
+func uploadChunkedTestArtifactsWithClient(c *GitLabClient, url string) UploadState {
+	config := BuildCredentials{
+		ID:    10,
+		URL:   url,
+		Token: "token",
+	}
+
+	return c.UploadRawArtifacts(config, strings.NewReader("0123456789"), strings.NewReader("metadata"), "artifacts.zip", "")
+}
+
+func TestChunkedArtifactsUpload(t *testing.T) {
+	server := &testChunkedArtifactsServer{t: t}
+	state := uploadChunkedTestArtifacts(server)
+	assert.Equal(t, UploadSucceeded, state)
+	assert.Equal(t, "0123456789", string(server.stored))
+	assert.Equal(t, "metadata", string(server.metadata))
+	assert.Equal(t, 3, server.chunkCalled)
+}
+
+func TestChunkedArtifactsUploadSendsChunkOnce(t *testing.T) {
+	server := &testChunkedArtifactsServer{t: t, failOnce: true}
+	state := uploadChunkedTestArtifacts(server)
+	assert.Equal(t, UploadFailed, state, "the artifacts commands retry the upload")
+	assert.Equal(t, "012345", string(server.stored))
+	assert.Equal(t, 2, server.chunkCalled, "the failed chunk shouldn't be resent")
+}
+
+func TestChunkedArtifactsUploadChecksumMismatch(t *testing.T) {
+	server := &testChunkedArtifactsServer{t: t, corrupt: true}
+	state := uploadChunkedTestArtifacts(server)
+	assert.Equal(t, UploadFailed, state)
+}
+
+func TestChunkedArtifactsUploadIsResumedByNextUpload(t *testing.T) {
+	server := &testChunkedArtifactsServer{t: t, failOnce: true}
+	s := httptest.NewServer(server)
+	defer s.Close()
+
+	c := GitLabClient{}
+	state := uploadChunkedTestArtifactsWithClient(&c, s.URL)
+	assert.Equal(t, UploadFailed, state)
+	assert.Equal(t, "012345", string(server.stored))
+
+	state = uploadChunkedTestArtifactsWithClient(&c, s.URL)
+	assert.Equal(t, UploadSucceeded, state)
+	assert.Equal(t, 1, server.started, "the upload should be resumed")
+	assert.Equal(t, "0123456789", string(server.stored))
+	assert.Equal(t, 3, server.chunkCalled, "only the rest of the archive should be sent")
+	assert.Empty(t, c.uploads, "the finished upload shouldn't be kept")
+}
+
+func TestChunkedArtifactsUploadChunkSizeIsLimited(t *testing.T) {
+	server := &testChunkedArtifactsServer{t: t, chunkSize: 1024 * 1024 * 1024}
+	s := httptest.NewServer(server)
+	defer s.Close()
+
+	c := GitLabClient{}
+	upload, state, _ := c.startArtifactsUpload(BuildCredentials{ID: 10, URL: s.URL, Token: "token"},
+		RunnerCredentials{URL: s.URL}, logrus.WithField("id", 10), "artifacts.zip", "")
+	assert.Equal(t, UploadSucceeded, state)
+	if assert.NotNil(t, upload) {
+		assert.Equal(t, maxArtifactsChunkSize, upload.ChunkSize)
+	}
+}
+
+func TestArtifactsUploadOffsetFollowsInclusiveRange(t *testing.T) {
+	examples := map[string]int64{
+		"":             0,
+		"bytes=0-0":    1,
+		"bytes=0-1023": 1024,
+		"0-1023":       7,
+		"bytes=10-20":  7,
+	}
+
+	for remoteRange, expected := range examples {
+		res := &http.Response{Header: make(http.Header)}
+		if remoteRange != "" {
+			res.Header.Set("Range", remoteRange)
+		}
+
+		u := &artifactsUpload{offset: 7}
+		u.updateOffset(res)
+		assert.Equal(t, expected, u.offset, remoteRange)
+	}
+}
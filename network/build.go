@@ -2,9 +2,9 @@ package network
 
 import (
 	"bufio"
-	"bytes"
 	"errors"
 	"fmt"
+	"github.com/Sirupsen/logrus"
 	"gitlab.com/gitlab-org/gitlab-ci-multi-runner/common"
 	"gitlab.com/gitlab-org/gitlab-ci-multi-runner/helpers"
 	"io"
@@ -14,16 +14,28 @@ import (
 
 var traceUpdateInterval = common.UpdateInterval
 var traceForceSendInterval = common.ForceTraceSentInterval
-var traceFinishRetryInterval = common.UpdateRetryInterval
 
+// maxTracePatchSize limits the memory used by a single trace update, the rest is sent by the next ones
+const maxTracePatchSize = 1024 * 1024
+
+// maxStaleTraceSize limits the memory used by the updates sending the whole trace,
+// GitLab not supporting the incremental updates receives only the beginning of longer traces
+const maxStaleTraceSize = 4 * maxTracePatchSize
+
+// tracePatch reads the sent part of the trace from the spool, only when it's sent
 type tracePatch struct {
-	trace  bytes.Buffer
+	spool  *traceSpool
 	offset int
 	limit  int
 }
 
 func (tp *tracePatch) Patch() []byte {
-	return tp.trace.Bytes()[tp.offset:tp.limit]
+	data, err := tp.spool.ReadRange(tp.offset, tp.limit)
+	if err != nil {
+		logrus.WithError(err).Errorln("Failed to read the trace patch")
+		return []byte{}
+	}
+	return data
 }
 
 func (tp *tracePatch) Offset() int {
@@ -46,11 +58,16 @@ func (tp *tracePatch) validateRange() bool {
 	return false
 }
 
-func newTracePatch(trace bytes.Buffer, offset int) (*tracePatch, error) {
+func newTracePatch(spool *traceSpool, offset int) (*tracePatch, error) {
+	limit := spool.Len()
+	if limit-offset > maxTracePatchSize {
+		limit = offset + maxTracePatchSize
+	}
+
 	patch := &tracePatch{
-		trace:  trace,
+		spool:  spool,
 		offset: offset,
-		limit:  trace.Len(),
+		limit:  limit,
 	}
 
 	if !patch.validateRange() {
@@ -72,10 +89,13 @@ type clientBuildTrace struct {
 
 	incrementalAvailable bool
 
-	log      bytes.Buffer
-	lock     sync.RWMutex
-	state    common.BuildState
-	finished chan bool
+	spool     *traceSpool
+	fullLog   *traceSpool
+	truncated bool
+	lock      sync.RWMutex
+	state     common.BuildState
+	finished  chan bool
+	processed chan bool
 
 	sentTrace int
 	sentTime  time.Time
@@ -113,7 +133,15 @@ func (c *clientBuildTrace) IsStdout() bool {
 func (c *clientBuildTrace) start() {
 	reader, writer := io.Pipe()
 	c.PipeWriter = writer
+	c.spool = newTraceSpool()
+	if c.config.OutputFullLog {
+		c.fullLog = newFullLogSpool()
+		if c.fullLog == nil {
+			c.config.Log().WithField("build", c.id).Warningln("The untruncated trace can't be kept without the trace spool file")
+		}
+	}
 	c.finished = make(chan bool)
+	c.processed = make(chan bool)
 	c.state = common.Running
 	c.incrementalAvailable = true
 	go c.process(reader)
@@ -122,23 +150,61 @@ func (c *clientBuildTrace) start() {
 
 func (c *clientBuildTrace) finish() {
 	c.Close()
+	<-c.processed
 	c.finished <- true
 
-	// Do final upload of build trace
-	for {
-		if c.staleUpdate() != common.UpdateFailed {
-			return
+	if c.fullLog != nil {
+		if c.truncated {
+			c.uploadFullLog()
 		}
-		time.Sleep(traceFinishRetryInterval)
+		c.fullLog.Remove()
+	}
+
+	// Do final upload of build trace
+	update := common.UpdateFailed
+	policy := NewRetryPolicy(c.config.Retry, retryFinishBuild)
+	policy.Do(c.config.Log(), func() (bool, time.Duration) {
+		update = c.finalUpdate()
+		return update == common.UpdateFailed, 0
+	})
+
+	if update != common.UpdateFailed {
+		c.spool.Remove()
+		return
+	}
+
+	// Keep the trace, so it can be sent later
+	c.lock.RLock()
+	pending := pendingTrace{
+		URL:         c.config.URL,
+		Runner:      c.config.ShortDescription(),
+		ID:          c.id,
+		Token:       c.buildCredentials.Token,
+		State:       c.state,
+		SentTrace:   c.sentTrace,
+		Incremental: c.incrementalAvailable,
+	}
+	c.lock.RUnlock()
+
+	err := c.spool.Keep(pending)
+	if err != nil {
+		c.config.Log().WithError(err).Errorln("Failed to keep the trace of build", c.id)
 	}
 }
 
-func (c *clientBuildTrace) writeRune(r rune, limit int) (n int, err error) {
-	c.lock.Lock()
-	defer c.lock.Unlock()
+// resume continues sending the kept trace from the part accepted by GitLab
+func (c *clientBuildTrace) resume(spool *traceSpool, pending pendingTrace) {
+	c.spool = spool
+	c.state = pending.State
+	c.incrementalAvailable = pending.Incremental
+	if pending.Incremental && pending.SentTrace <= spool.Len() {
+		c.sentTrace = pending.SentTrace
+	}
+}
 
-	n, err = c.log.WriteRune(r)
-	if c.log.Len() < limit {
+func (c *clientBuildTrace) writeRune(r rune, limit int, tailLimit int) (n int, err error) {
+	n, err = c.spool.WriteRune(r)
+	if err != nil || c.spool.Len() < limit {
 		return
 	}
 
@@ -147,12 +213,33 @@ func (c *clientBuildTrace) writeRune(r rune, limit int) (n int, err error) {
 		limit,
 		helpers.ANSI_RESET,
 	)
-	c.log.WriteString(output)
+	if tailLimit > 0 {
+		output = fmt.Sprintf("\n%sBuild log exceeded limit of %v bytes, only the last %v bytes will be kept.%s\n",
+			helpers.ANSI_BOLD_RED,
+			limit,
+			tailLimit,
+			helpers.ANSI_RESET,
+		)
+	}
+	c.spool.WriteString(output)
 	err = io.EOF
 	return
 }
 
+// writeTail appends the end of the trace exceeding the limit, after the marker of the skipped part
+func (c *clientBuildTrace) writeTail(tail *traceTail) {
+	if skipped := tail.Skipped(); skipped > 0 {
+		c.spool.WriteString(fmt.Sprintf("%s[... %v bytes skipped ...]%s\n",
+			helpers.ANSI_BOLD_RED,
+			skipped,
+			helpers.ANSI_RESET,
+		))
+	}
+	c.spool.WriteString(string(tail.Bytes()))
+}
+
 func (c *clientBuildTrace) process(pipe *io.PipeReader) {
+	defer close(c.processed)
 	defer pipe.Close()
 
 	stopped := false
@@ -162,16 +249,29 @@ func (c *clientBuildTrace) process(pipe *io.PipeReader) {
 	}
 	limit *= 1024
 
+	var tail *traceTail
+	tailLimit := c.config.OutputTailLimit * 1024
+	if tailLimit > 0 {
+		tail = newTraceTail(tailLimit)
+	}
+
 	reader := bufio.NewReader(pipe)
 	for {
 		r, s, err := reader.ReadRune()
 		if s <= 0 {
 			break
-		} else if stopped {
-			// ignore symbols if build log exceeded limit
+		} else if err == nil && c.fullLog != nil {
+			c.fullLog.WriteRune(r)
+		}
+
+		if stopped {
+			// keep only the tail if build log exceeded limit
+			if tail != nil {
+				tail.WriteRune(r)
+			}
 			continue
 		} else if err == nil {
-			_, err = c.writeRune(r, limit)
+			_, err = c.writeRune(r, limit, tailLimit)
 			if err == io.EOF {
 				stopped = true
 			}
@@ -180,6 +280,11 @@ func (c *clientBuildTrace) process(pipe *io.PipeReader) {
 			continue
 		}
 	}
+
+	if tail != nil && tail.written > 0 {
+		c.writeTail(tail)
+	}
+	c.truncated = stopped
 }
 
 func (c *clientBuildTrace) update() common.UpdateState {
@@ -204,11 +309,10 @@ func (c *clientBuildTrace) update() common.UpdateState {
 func (c *clientBuildTrace) incrementalUpdate() common.UpdateState {
 	c.lock.RLock()
 	state := c.state
-	trace := c.log
 	c.lock.RUnlock()
 
 	if c.sentState == state &&
-		c.sentTrace == trace.Len() &&
+		c.sentTrace == c.spool.Len() &&
 		time.Since(c.sentTime) < traceForceSendInterval {
 		return common.UpdateSucceeded
 	}
@@ -218,9 +322,15 @@ func (c *clientBuildTrace) incrementalUpdate() common.UpdateState {
 		c.sentState = state
 	}
 
-	tracePatch, err := newTracePatch(trace, c.sentTrace)
+	return c.sendPatch()
+}
+
+// sendPatch sends the part of the trace not sent yet, at most maxTracePatchSize of it
+func (c *clientBuildTrace) sendPatch() common.UpdateState {
+	tracePatch, err := newTracePatch(c.spool, c.sentTrace)
 	if err != nil {
 		c.config.Log().Errorln("Error while creating a tracePatch", err.Error())
+		return common.UpdateFailed
 	}
 
 	update := c.client.PatchTrace(c.config, c.buildCredentials, tracePatch)
@@ -240,6 +350,32 @@ func (c *clientBuildTrace) incrementalUpdate() common.UpdateState {
 	return update
 }
 
+// finalUpdate sends the rest of the trace in patches if GitLab supports them, and the final state without the trace
+func (c *clientBuildTrace) finalUpdate() common.UpdateState {
+	for c.incrementalAvailable && c.sentTrace < c.spool.Len() {
+		update := c.sendPatch()
+		if update == common.UpdateNotFound {
+			c.incrementalAvailable = false
+		} else if update != common.UpdateSucceeded {
+			return update
+		}
+	}
+
+	if !c.incrementalAvailable {
+		return c.staleUpdate()
+	}
+
+	c.lock.RLock()
+	state := c.state
+	c.lock.RUnlock()
+
+	update := c.client.UpdateBuild(c.config, c.id, state, nil)
+	if update == common.UpdateSucceeded {
+		c.sentState = state
+	}
+	return update
+}
+
 func (c *clientBuildTrace) resendPatch(id int, config common.RunnerConfig, buildCredentials *common.BuildCredentials, tracePatch common.BuildTracePatch) (update common.UpdateState) {
 	config.Log().Warningln(id, "Resending trace patch due to range mismatch")
 
@@ -252,21 +388,28 @@ func (c *clientBuildTrace) resendPatch(id int, config common.RunnerConfig, build
 	return
 }
 
+// staleUpdate sends the whole trace, at most maxStaleTraceSize of it is read from the spool only for the update
 func (c *clientBuildTrace) staleUpdate() common.UpdateState {
 	c.lock.RLock()
 	state := c.state
-	trace := c.log.String()
 	c.lock.RUnlock()
 
+	size := c.spool.Len()
 	if c.sentState == state &&
-		c.sentTrace == len(trace) &&
+		c.sentTrace == size &&
 		time.Since(c.sentTime) < traceForceSendInterval {
 		return common.UpdateSucceeded
 	}
 
+	trace, err := c.spool.Head(maxStaleTraceSize)
+	if err != nil {
+		c.config.Log().WithError(err).Errorln("Failed to read the trace")
+		return common.UpdateFailed
+	}
+
 	upload := c.client.UpdateBuild(c.config, c.id, state, &trace)
 	if upload == common.UpdateSucceeded {
-		c.sentTrace = len(trace)
+		c.sentTrace = size
 		c.sentState = state
 		c.sentTime = time.Now()
 	}
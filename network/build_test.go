@@ -1,8 +1,13 @@
 package network
 
 import (
+	"archive/zip"
+	"bytes"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
 	"testing"
 	"time"
 
@@ -17,6 +22,8 @@ const retryID = 6
 
 var buildConfig = common.RunnerConfig{}
 var buildOutputLimit = common.RunnerConfig{OutputLimit: 1}
+var buildOutputTailLimit = common.RunnerConfig{OutputLimit: 1, OutputTailLimit: 1}
+var buildOutputFullLog = common.RunnerConfig{OutputLimit: 1, OutputTailLimit: 1, OutputFullLog: true}
 
 type updateTraceNetwork struct {
 	common.MockNetwork
@@ -125,8 +132,110 @@ func TestBuildOutputLimit(t *testing.T) {
 	assert.Contains(t, *u.trace, "Build log exceeded limit")
 }
 
+func TestBuildOutputTailLimit(t *testing.T) {
+	u := &updateTraceNetwork{}
+	buildCredentials := &common.BuildCredentials{
+		ID: successID,
+	}
+	b := newBuildTrace(u, buildOutputTailLimit, buildCredentials)
+	b.start()
+
+	// Write 500k to the buffer
+	for i := 0; i < 100000; i++ {
+		fmt.Fprint(b, "abcde")
+	}
+	fmt.Fprint(b, "the failure reason")
+	b.Success()
+	assert.True(t, len(*u.trace) < 3000, "the output should be less than 3000 bytes")
+	assert.Contains(t, *u.trace, "only the last 1024 bytes will be kept")
+	assert.Contains(t, *u.trace, "bytes skipped")
+	assert.True(t, strings.HasSuffix(*u.trace, "abcdethe failure reason"), "the tail should be kept")
+}
+
+type fullLogTraceNetwork struct {
+	updateTraceNetwork
+	uploads     int
+	credentials common.BuildCredentials
+	baseName    string
+	archive     []byte
+}
+
+func (m *fullLogTraceNetwork) UploadRawArtifacts(config common.BuildCredentials, reader io.Reader, metadata io.Reader, baseName string, expireIn string) common.UploadState {
+	m.uploads++
+	m.credentials = config
+	m.baseName = baseName
+	m.archive, _ = ioutil.ReadAll(reader)
+	return common.UploadSucceeded
+}
+
+func (m *fullLogTraceNetwork) fullLog(t *testing.T) string {
+	archive, err := zip.NewReader(bytes.NewReader(m.archive), int64(len(m.archive)))
+	if !assert.NoError(t, err) || !assert.Equal(t, 1, len(archive.File)) {
+		return ""
+	}
+	assert.Equal(t, "build.log", archive.File[0].Name)
+
+	file, err := archive.File[0].Open()
+	if !assert.NoError(t, err) {
+		return ""
+	}
+	defer file.Close()
+
+	data, err := ioutil.ReadAll(file)
+	assert.NoError(t, err)
+	return string(data)
+}
+
+func TestBuildOutputFullLogIsUploaded(t *testing.T) {
+	u := &fullLogTraceNetwork{}
+	buildCredentials := &common.BuildCredentials{
+		ID:    successID,
+		Token: "build-token",
+	}
+	config := buildOutputFullLog
+	config.URL = "https://gitlab.example.com/ci"
+	b := newBuildTrace(u, config, buildCredentials)
+	b.start()
+
+	for i := 0; i < 100000; i++ {
+		fmt.Fprint(b, "abcde")
+	}
+	fmt.Fprint(b, "the failure reason")
+	b.Success()
+
+	assert.True(t, len(*u.trace) < 3000, "the output should be less than 3000 bytes")
+	assert.Contains(t, *u.trace, "The untruncated build log was uploaded as the build artifacts")
+
+	assert.Equal(t, 1, u.uploads)
+	assert.Equal(t, "build-log.zip", u.baseName)
+	assert.Equal(t, successID, u.credentials.ID)
+	assert.Equal(t, "build-token", u.credentials.Token)
+	assert.Equal(t, "https://gitlab.example.com/ci", u.credentials.URL)
+
+	fullLog := u.fullLog(t)
+	assert.Equal(t, 500018, len(fullLog))
+	assert.Equal(t, strings.Repeat("abcde", 100000)+"the failure reason", fullLog)
+}
+
+func TestBuildOutputFullLogIsNotUploadedWithinLimit(t *testing.T) {
+	u := &fullLogTraceNetwork{}
+	buildCredentials := &common.BuildCredentials{
+		ID: successID,
+	}
+	b := newBuildTrace(u, buildOutputFullLog, buildCredentials)
+	b.start()
+
+	fmt.Fprint(b, "short log")
+	b.Success()
+
+	assert.Equal(t, "short log", *u.trace)
+	assert.Equal(t, 0, u.uploads)
+}
+
 func TestBuildFinishRetry(t *testing.T) {
-	traceFinishRetryInterval = time.Microsecond
+	defaultPolicy := DefaultRetryPolicy
+	defer func() { DefaultRetryPolicy = defaultPolicy }()
+	DefaultRetryPolicy.InitialBackoff = time.Microsecond
 
 	u := &updateTraceNetwork{}
 	buildCredentials := &common.BuildCredentials{
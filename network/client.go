@@ -31,14 +31,21 @@ type client struct {
 	http.Client
 	url        *url.URL
 	caFile     string
+	certFile   string
+	keyFile    string
+	proxy      *url.URL
 	skipVerify bool
 	updateTime time.Time
+
+	compression traceCompression
 }
 
 func (n *client) ensureTLSConfig() {
 	// certificate got modified
-	if stat, err := os.Stat(n.caFile); err == nil && n.updateTime.Before(stat.ModTime()) {
-		n.Transport = nil
+	for _, file := range []string{n.caFile, n.certFile, n.keyFile} {
+		if stat, err := os.Stat(file); err == nil && n.updateTime.Before(stat.ModTime()) {
+			n.Transport = nil
+		}
 	}
 
 	// create or update transport
@@ -74,9 +81,27 @@ func (n *client) createTransport() {
 		}
 	}
 
+	// load TLS client certificate
+	if n.certFile != "" && n.keyFile != "" {
+		logrus.Debugln("Trying to load", n.certFile, "and", n.keyFile, "...")
+
+		certificate, err := tls.LoadX509KeyPair(n.certFile, n.keyFile)
+		if err == nil {
+			tlsConfig.Certificates = []tls.Certificate{certificate}
+		} else {
+			logrus.Errorln("Failed to load", n.certFile, "and", n.keyFile, err)
+		}
+	}
+
+	// the proxy of the runner takes precedence over the environment
+	proxy := http.ProxyFromEnvironment
+	if n.proxy != nil {
+		proxy = http.ProxyURL(n.proxy)
+	}
+
 	// create transport
 	n.Transport = &http.Transport{
-		Proxy: http.ProxyFromEnvironment,
+		Proxy: proxy,
 		Dial: func(network, addr string) (net.Conn, error) {
 			logrus.Debugln("Dialing:", network, addr, "...")
 			return dialer.Dial(network, addr)
@@ -126,7 +151,7 @@ func (n *client) getCAChain(tls *tls.ConnectionState) (certificates string) {
 	return
 }
 
-func (n *client) do(uri, method string, request io.Reader, requestType string, headers http.Header) (res *http.Response, err error) {
+func (n *client) send(uri, method string, request io.Reader, requestType string, headers http.Header) (res *http.Response, err error) {
 	url, err := n.url.Parse(uri)
 	if err != nil {
 		return
@@ -154,10 +179,78 @@ func (n *client) do(uri, method string, request io.Reader, requestType string, h
 		err = fmt.Errorf("couldn't execute %v against %s: %v", req.Method, req.URL, err)
 		return
 	}
+
+	n.compression.update(res)
 	return
 }
 
-func (n *client) doJSON(uri, method string, statusCode int, request interface{}, response interface{}) (int, string, string) {
+// do retries the request according to the policy, only if it can be sent again
+func (n *client) do(policy RetryPolicy, uri, method string, request io.Reader, requestType string, headers http.Header) (res *http.Response, err error) {
+	seeker, replayable := request.(io.Seeker)
+	if request != nil && !replayable {
+		policy = RetryPolicy{}
+	}
+
+	log := logrus.WithFields(logrus.Fields{
+		"method": method,
+		"uri":    uri,
+	})
+
+	policy.Do(log, func() (bool, time.Duration) {
+		if res != nil {
+			io.Copy(ioutil.Discard, res.Body)
+			res.Body.Close()
+			res = nil
+		}
+
+		if seeker != nil {
+			_, err = seeker.Seek(0, io.SeekStart)
+			if err != nil {
+				return false, 0
+			}
+		}
+
+		res, err = n.send(uri, method, request, requestType, headers)
+		if err != nil {
+			log.WithError(err).Debugln("Request failed")
+			return true, 0
+		} else if isRetryableStatus(res.StatusCode) {
+			log.WithField("status", res.Status).Debugln("Request failed")
+			return true, parseRetryAfter(res.Header.Get("Retry-After"))
+		}
+		return false, 0
+	})
+	return
+}
+
+// doCompressed sends the gzip-encoded request if GitLab accepts it,
+// and sends it again uncompressed if GitLab responds with 415 Unsupported Media Type
+func (n *client) doCompressed(policy RetryPolicy, uri, method string, request []byte, requestType string, headers http.Header) (res *http.Response, err error) {
+	if headers == nil {
+		headers = make(http.Header)
+	}
+
+	if n.compression.enabled() && len(request) >= traceCompressionMinSize {
+		compressed, gzipErr := gzipBody(request)
+		if gzipErr == nil {
+			headers.Set("Content-Encoding", "gzip")
+			res, err = n.do(policy, uri, method, bytes.NewReader(compressed), requestType, headers)
+			if err != nil || res.StatusCode != http.StatusUnsupportedMediaType {
+				return
+			}
+
+			logrus.WithField("uri", uri).Warningln("Compressed request rejected, sending it uncompressed")
+			n.compression.reject()
+			io.Copy(ioutil.Discard, res.Body)
+			res.Body.Close()
+			headers.Del("Content-Encoding")
+		}
+	}
+
+	return n.do(policy, uri, method, bytes.NewReader(request), requestType, headers)
+}
+
+func (n *client) doJSON(policy RetryPolicy, uri, method string, statusCode int, request interface{}, response interface{}) (int, string, string) {
 	var body io.Reader
 
 	if request != nil {
@@ -173,7 +266,7 @@ func (n *client) doJSON(uri, method string, statusCode int, request interface{},
 		headers.Set("Accept", "application/json")
 	}
 
-	res, err := n.do(uri, method, body, "application/json", headers)
+	res, err := n.do(policy, uri, method, body, "application/json", headers)
 	if err != nil {
 		return -1, err.Error(), ""
 	}
@@ -216,9 +309,24 @@ func newClient(config common.RunnerCredentials) (c *client, err error) {
 		return
 	}
 
+	if (config.TLSCertFile == "") != (config.TLSKeyFile == "") {
+		err = errors.New("both tls-cert-file and tls-key-file need to be set for the client certificate")
+		return
+	}
+
 	c = &client{
-		url:    url,
-		caFile: config.TLSCAFile,
+		url:      url,
+		caFile:   config.TLSCAFile,
+		certFile: config.TLSCertFile,
+		keyFile:  config.TLSKeyFile,
+	}
+
+	if config.Proxy != "" {
+		c.proxy, err = url.Parse(config.Proxy)
+		if err != nil {
+			err = fmt.Errorf("invalid proxy: %v", err)
+			return
+		}
 	}
 
 	if CertificateDirectory != "" && c.caFile == "" {
@@ -1,6 +1,8 @@
 package network
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/pem"
 	"errors"
 	"fmt"
@@ -80,7 +82,7 @@ func TestClientDo(t *testing.T) {
 	assert.NoError(t, err)
 	assert.NotNil(t, c)
 
-	statusCode, statusText, _ := c.doJSON("test/auth", "GET", 200, nil, nil)
+	statusCode, statusText, _ := c.doJSON(RetryPolicy{}, "test/auth", "GET", 200, nil, nil)
 	assert.Equal(t, 403, statusCode, statusText)
 
 	req := struct {
@@ -93,16 +95,16 @@ func TestClientDo(t *testing.T) {
 		Key string `json:"key"`
 	}{}
 
-	statusCode, statusText, _ = c.doJSON("test/json", "GET", 200, nil, &res)
+	statusCode, statusText, _ = c.doJSON(RetryPolicy{}, "test/json", "GET", 200, nil, &res)
 	assert.Equal(t, 400, statusCode, statusText)
 
-	statusCode, statusText, _ = c.doJSON("test/json", "GET", 200, &req, nil)
+	statusCode, statusText, _ = c.doJSON(RetryPolicy{}, "test/json", "GET", 200, &req, nil)
 	assert.Equal(t, 406, statusCode, statusText)
 
-	statusCode, statusText, _ = c.doJSON("test/json", "GET", 200, nil, nil)
+	statusCode, statusText, _ = c.doJSON(RetryPolicy{}, "test/json", "GET", 200, nil, nil)
 	assert.Equal(t, 400, statusCode, statusText)
 
-	statusCode, statusText, _ = c.doJSON("test/json", "GET", 200, &req, &res)
+	statusCode, statusText, _ = c.doJSON(RetryPolicy{}, "test/json", "GET", 200, &req, &res)
 	assert.Equal(t, 200, statusCode, statusText)
 	assert.Equal(t, "value", res.Key, statusText)
 }
@@ -114,7 +116,7 @@ func TestClientInvalidSSL(t *testing.T) {
 	c, _ := newClient(RunnerCredentials{
 		URL: s.URL,
 	})
-	statusCode, statusText, _ := c.doJSON("test/ok", "GET", 200, nil, nil)
+	statusCode, statusText, _ := c.doJSON(RetryPolicy{}, "test/ok", "GET", 200, nil, nil)
 	assert.Equal(t, -1, statusCode, statusText)
 	assert.Contains(t, statusText, "certificate signed by unknown authority")
 }
@@ -135,7 +137,7 @@ func TestClientTLSCAFile(t *testing.T) {
 		URL:       s.URL,
 		TLSCAFile: file.Name(),
 	})
-	statusCode, statusText, certificates := c.doJSON("test/ok", "GET", 200, nil, nil)
+	statusCode, statusText, certificates := c.doJSON(RetryPolicy{}, "test/ok", "GET", 200, nil, nil)
 	assert.Equal(t, 200, statusCode, statusText)
 	assert.NotEmpty(t, certificates)
 }
@@ -155,11 +157,93 @@ func TestClientCertificateInPredefinedDirectory(t *testing.T) {
 	c, _ := newClient(RunnerCredentials{
 		URL: s.URL,
 	})
-	statusCode, statusText, certificates := c.doJSON("test/ok", "GET", 200, nil, nil)
+	statusCode, statusText, certificates := c.doJSON(RetryPolicy{}, "test/ok", "GET", 200, nil, nil)
 	assert.Equal(t, 200, statusCode, statusText)
 	assert.NotEmpty(t, certificates)
 }
 
+func writeTLSKey(s *httptest.Server, file string) error {
+	key, err := x509.MarshalPKCS8PrivateKey(s.TLS.Certificates[0].PrivateKey)
+	if err != nil {
+		return err
+	}
+
+	encoded := pem.EncodeToMemory(&pem.Block{
+		Type:  "PRIVATE KEY",
+		Bytes: key,
+	})
+	return ioutil.WriteFile(file, encoded, 0600)
+}
+
+func TestClientTLSCertFile(t *testing.T) {
+	s := httptest.NewUnstartedServer(http.HandlerFunc(clientHandler))
+	s.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	s.StartTLS()
+	defer s.Close()
+
+	tempDir, err := ioutil.TempDir("", "certs")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	// The server certificate is presented as the client certificate too
+	caFile := filepath.Join(tempDir, "ca.crt")
+	keyFile := filepath.Join(tempDir, "client.key")
+	assert.NoError(t, writeTLSCertificate(s, caFile))
+	assert.NoError(t, writeTLSKey(s, keyFile))
+
+	c, _ := newClient(RunnerCredentials{
+		URL:       s.URL,
+		TLSCAFile: caFile,
+	})
+	statusCode, statusText, _ := c.doJSON(RetryPolicy{}, "test/ok", "GET", 200, nil, nil)
+	assert.Equal(t, -1, statusCode, statusText)
+
+	c, _ = newClient(RunnerCredentials{
+		URL:         s.URL,
+		TLSCAFile:   caFile,
+		TLSCertFile: caFile,
+		TLSKeyFile:  keyFile,
+	})
+	statusCode, statusText, _ = c.doJSON(RetryPolicy{}, "test/ok", "GET", 200, nil, nil)
+	assert.Equal(t, 200, statusCode, statusText)
+}
+
+func TestClientTLSCertFileWithoutKeyFile(t *testing.T) {
+	_, err := newClient(RunnerCredentials{
+		URL:         "https://gitlab.example.com/",
+		TLSCertFile: "client.crt",
+	})
+	assert.Error(t, err)
+
+	_, err = newClient(RunnerCredentials{
+		URL:        "https://gitlab.example.com/",
+		TLSKeyFile: "client.key",
+	})
+	assert.Error(t, err)
+}
+
+func TestClientProxy(t *testing.T) {
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Host != "gitlab.example.com" {
+			w.WriteHeader(502)
+		} else if r.Header.Get("Proxy-Authorization") != "Basic dXNlcjpwYXNzd29yZA==" {
+			w.WriteHeader(407)
+		} else {
+			clientHandler(w, r)
+		}
+	}))
+	defer proxy.Close()
+
+	c, err := newClient(RunnerCredentials{
+		URL:   "http://gitlab.example.com/",
+		Proxy: "http://user:password@" + proxy.Listener.Addr().String(),
+	})
+	assert.NoError(t, err)
+
+	statusCode, statusText, _ := c.doJSON(RetryPolicy{}, "test/ok", "GET", 200, nil, nil)
+	assert.Equal(t, 200, statusCode, statusText)
+}
+
 func TestUrlFixing(t *testing.T) {
 	assert.Equal(t, "https://gitlab.example.com/ci", fixCIURL("https://gitlab.example.com/ci///"))
 	assert.Equal(t, "https://gitlab.example.com/ci", fixCIURL("https://gitlab.example.com/ci/"))
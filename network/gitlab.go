@@ -1,7 +1,7 @@
 package network
 
 import (
-	"bytes"
+	"encoding/json"
 	"fmt"
 	"github.com/Sirupsen/logrus"
 	"gitlab.com/gitlab-org/gitlab-ci-multi-runner/common"
@@ -20,15 +20,24 @@ import (
 
 const clientError = -100
 
+// artifactsRetryConfig sends the artifacts requests only once,
+// the artifacts commands retry the whole upload or download
+var artifactsRetryConfig = &common.RetryConfig{
+	RetryBudget: common.RetryBudget{MaxAttempts: 1},
+}
+
 type GitLabClient struct {
 	clients map[string]*client
+
+	// uploads keeps the failed artifacts uploads, so the next upload of the archive resumes them
+	uploads map[string]*artifactsUpload
 }
 
 func (n *GitLabClient) getClient(runner common.RunnerCredentials) (c *client, err error) {
 	if n.clients == nil {
 		n.clients = make(map[string]*client)
 	}
-	key := fmt.Sprintf("%s_%s", runner.URL, runner.TLSCAFile)
+	key := fmt.Sprintf("%s_%s_%s_%s_%s", runner.URL, runner.TLSCAFile, runner.TLSCertFile, runner.TLSKeyFile, runner.Proxy)
 	c = n.clients[key]
 	if c == nil {
 		c, err = newClient(runner)
@@ -48,6 +57,9 @@ func (n *GitLabClient) getRunnerVersion(config common.RunnerConfig) common.Versi
 		Platform:     runtime.GOOS,
 		Architecture: runtime.GOARCH,
 		Executor:     config.Executor,
+		Features: common.FeaturesInfo{
+			TraceCompression: true,
+		},
 	}
 
 	if executor := common.GetExecutor(config.Executor); executor != nil {
@@ -61,22 +73,52 @@ func (n *GitLabClient) getRunnerVersion(config common.RunnerConfig) common.Versi
 	return info
 }
 
-func (n *GitLabClient) doRaw(runner common.RunnerCredentials, method, uri string, request io.Reader, requestType string, headers http.Header) (res *http.Response, err error) {
+func (n *GitLabClient) doRaw(runner common.RunnerCredentials, endpoint string, method, uri string, request io.Reader, requestType string, headers http.Header) (res *http.Response, err error) {
+	c, err := n.getClient(runner)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.do(NewRetryPolicy(runner.Retry, endpoint), uri, method, request, requestType, headers)
+}
+
+func (n *GitLabClient) doCompressed(runner common.RunnerCredentials, endpoint string, method, uri string, request []byte, requestType string, headers http.Header) (res *http.Response, err error) {
 	c, err := n.getClient(runner)
 	if err != nil {
 		return nil, err
 	}
 
-	return c.do(uri, method, request, requestType, headers)
+	return c.doCompressed(NewRetryPolicy(runner.Retry, endpoint), uri, method, request, requestType, headers)
 }
 
-func (n *GitLabClient) doJSON(runner common.RunnerCredentials, method, uri string, statusCode int, request interface{}, response interface{}) (int, string, string) {
+func (n *GitLabClient) doJSON(runner common.RunnerCredentials, endpoint string, method, uri string, statusCode int, request interface{}, response interface{}) (int, string, string) {
 	c, err := n.getClient(runner)
 	if err != nil {
 		return clientError, err.Error(), ""
 	}
 
-	return c.doJSON(uri, method, statusCode, request, response)
+	return c.doJSON(NewRetryPolicy(runner.Retry, endpoint), uri, method, statusCode, request, response)
+}
+
+// getClientCertificate returns the client certificate and its key, so the build can use them too
+func (n *GitLabClient) getClientCertificate(config common.RunnerConfig) (cert string, key string) {
+	if config.TLSCertFile == "" || config.TLSKeyFile == "" {
+		return
+	}
+
+	certData, err := ioutil.ReadFile(config.TLSCertFile)
+	if err != nil {
+		config.Log().WithError(err).Warningln("Failed to load the TLS client certificate")
+		return
+	}
+
+	keyData, err := ioutil.ReadFile(config.TLSKeyFile)
+	if err != nil {
+		config.Log().WithError(err).Warningln("Failed to load the TLS client key")
+		return
+	}
+
+	return string(certData), string(keyData)
 }
 
 func (n *GitLabClient) GetBuild(config common.RunnerConfig) (*common.GetBuildResponse, bool) {
@@ -86,7 +128,7 @@ func (n *GitLabClient) GetBuild(config common.RunnerConfig) (*common.GetBuildRes
 	}
 
 	var response common.GetBuildResponse
-	result, statusText, certificates := n.doJSON(config.RunnerCredentials, "POST", "builds/register.json", 201, &request, &response)
+	result, statusText, certificates := n.doJSON(config.RunnerCredentials, retryGetBuild, "POST", "builds/register.json", 201, &request, &response)
 
 	switch result {
 	case 201:
@@ -95,6 +137,7 @@ func (n *GitLabClient) GetBuild(config common.RunnerConfig) (*common.GetBuildRes
 			"repo_url": response.RepoCleanURL(),
 		}).Println("Checking for builds...", "received")
 		response.TLSCAChain = certificates
+		response.TLSAuthCert, response.TLSAuthKey = n.getClientCertificate(config)
 		return &response, true
 	case 403:
 		config.Log().Errorln("Checking for builds...", "forbidden")
@@ -121,7 +164,7 @@ func (n *GitLabClient) RegisterRunner(runner common.RunnerCredentials, descripti
 	}
 
 	var response common.RegisterRunnerResponse
-	result, statusText, _ := n.doJSON(runner, "POST", "runners/register.json", 201, &request, &response)
+	result, statusText, _ := n.doJSON(runner, retryRegisterRunner, "POST", "runners/register.json", 201, &request, &response)
 
 	switch result {
 	case 201:
@@ -144,7 +187,7 @@ func (n *GitLabClient) DeleteRunner(runner common.RunnerCredentials) bool {
 		Token: runner.Token,
 	}
 
-	result, statusText, _ := n.doJSON(runner, "DELETE", "runners/delete", 200, &request, nil)
+	result, statusText, _ := n.doJSON(runner, retryDeleteRunner, "DELETE", "runners/delete", 200, &request, nil)
 
 	switch result {
 	case 200:
@@ -168,7 +211,7 @@ func (n *GitLabClient) VerifyRunner(runner common.RunnerCredentials) bool {
 	}
 
 	// HACK: we use non-existing build id to check if receive forbidden or not found
-	result, statusText, _ := n.doJSON(runner, "PUT", fmt.Sprintf("builds/%d", -1), 200, &request, nil)
+	result, statusText, _ := n.doJSON(runner, retryVerifyRunner, "PUT", fmt.Sprintf("builds/%d", -1), 200, &request, nil)
 
 	switch result {
 	case 404:
@@ -187,6 +230,27 @@ func (n *GitLabClient) VerifyRunner(runner common.RunnerCredentials) bool {
 	}
 }
 
+// updateBuildRequest sends the build update with the trace, compressed if GitLab accepts it
+func (n *GitLabClient) updateBuildRequest(runner common.RunnerCredentials, id int, request *common.UpdateBuildRequest) (int, string) {
+	if _, err := n.getClient(runner); err != nil {
+		return clientError, err.Error()
+	}
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		return -1, fmt.Sprintf("failed to marshal project object: %v", err)
+	}
+
+	response, err := n.doCompressed(runner, retryUpdateBuild, "PUT", fmt.Sprintf("builds/%d.json", id), body, "application/json", nil)
+	if err != nil {
+		return -1, err.Error()
+	}
+	defer response.Body.Close()
+	defer io.Copy(ioutil.Discard, response.Body)
+
+	return response.StatusCode, response.Status
+}
+
 func (n *GitLabClient) UpdateBuild(config common.RunnerConfig, id int, state common.BuildState, trace *string) common.UpdateState {
 	request := common.UpdateBuildRequest{
 		Info:  n.getRunnerVersion(config),
@@ -197,7 +261,7 @@ func (n *GitLabClient) UpdateBuild(config common.RunnerConfig, id int, state com
 
 	log := config.Log().WithField("build", id)
 
-	result, statusText, _ := n.doJSON(config.RunnerCredentials, "PUT", fmt.Sprintf("builds/%d.json", id), 200, &request, nil)
+	result, statusText := n.updateBuildRequest(config.RunnerCredentials, id, &request)
 	switch result {
 	case 200:
 		log.Debugln("Submitting build to coordinator...", "ok")
@@ -225,9 +289,7 @@ func (n *GitLabClient) PatchTrace(config common.RunnerConfig, buildCredentials *
 	headers.Set("Content-Range", contentRange)
 	headers.Set("BUILD-TOKEN", buildCredentials.Token)
 	uri := fmt.Sprintf("builds/%d/trace.txt", id)
-	request := bytes.NewReader(tracePatch.Patch())
-
-	response, err := n.doRaw(config.RunnerCredentials, "PATCH", uri, request, "text/plain", headers)
+	response, err := n.doCompressed(config.RunnerCredentials, retryPatchTrace, "PATCH", uri, tracePatch.Patch(), "text/plain", headers)
 	if err != nil {
 		config.Log().Errorln("Appending trace to coordinator...", "error", err.Error())
 		return common.UpdateFailed
@@ -279,7 +341,9 @@ func (n *GitLabClient) PatchTrace(config common.RunnerConfig, buildCredentials *
 	}
 }
 
-func (n *GitLabClient) createArtifactsForm(mpw *multipart.Writer, reader io.Reader, baseName string) error {
+// createArtifactsForm sends the metadata after the archive,
+// so it can be generated while the archive is created
+func (n *GitLabClient) createArtifactsForm(mpw *multipart.Writer, reader io.Reader, metadata io.Reader, baseName string) error {
 	wr, err := mpw.CreateFormFile("file", baseName)
 	if err != nil {
 		return err
@@ -289,10 +353,69 @@ func (n *GitLabClient) createArtifactsForm(mpw *multipart.Writer, reader io.Read
 	if err != nil {
 		return err
 	}
+
+	if metadata == nil {
+		return nil
+	}
+
+	wr, err = mpw.CreateFormFile("metadata", "metadata.gz")
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(wr, metadata)
+	if err != nil {
+		return err
+	}
 	return nil
 }
 
-func (n *GitLabClient) UploadRawArtifacts(config common.BuildCredentials, reader io.Reader, baseName string, expireIn string) common.UploadState {
+// UploadRawArtifacts uses the chunked upload, which can be resumed after failures,
+// and falls back to a single request if GitLab doesn't support it
+func (n *GitLabClient) UploadRawArtifacts(config common.BuildCredentials, reader io.Reader, metadata io.Reader, baseName string, expireIn string) common.UploadState {
+	// TODO: Create proper interface for `doRaw` that can use other types than RunnerCredentials
+	mappedConfig := config.RunnerCredentials()
+	mappedConfig.Retry = artifactsRetryConfig
+
+	log := logrus.WithFields(logrus.Fields{
+		"id":    config.ID,
+		"token": helpers.ShortenToken(config.Token),
+	})
+
+	key := fmt.Sprintf("%s/%d/%s", config.URL, config.ID, baseName)
+	if upload := n.uploads[key]; upload != nil {
+		state := upload.resume(reader)
+		if state == common.UploadSucceeded {
+			state = upload.upload(reader, metadata)
+		}
+		n.keepFailedUpload(key, upload, state)
+		return state
+	}
+
+	upload, state, supported := n.startArtifactsUpload(config, mappedConfig, log, baseName, expireIn)
+	if upload != nil {
+		state = upload.upload(reader, metadata)
+		n.keepFailedUpload(key, upload, state)
+		return state
+	} else if supported {
+		return state
+	}
+
+	return n.uploadArtifactsRequest(config, mappedConfig, log, reader, metadata, baseName, expireIn)
+}
+
+func (n *GitLabClient) keepFailedUpload(key string, upload *artifactsUpload, state common.UploadState) {
+	if state == common.UploadFailed && !upload.broken {
+		if n.uploads == nil {
+			n.uploads = make(map[string]*artifactsUpload)
+		}
+		n.uploads[key] = upload
+	} else {
+		delete(n.uploads, key)
+	}
+}
+
+func (n *GitLabClient) uploadArtifactsRequest(config common.BuildCredentials, mappedConfig common.RunnerCredentials, log *logrus.Entry, reader io.Reader, metadata io.Reader, baseName string, expireIn string) common.UploadState {
 	pr, pw := io.Pipe()
 	defer pr.Close()
 
@@ -301,19 +424,12 @@ func (n *GitLabClient) UploadRawArtifacts(config common.BuildCredentials, reader
 	go func() {
 		defer pw.Close()
 		defer mpw.Close()
-		err := n.createArtifactsForm(mpw, reader, baseName)
+		err := n.createArtifactsForm(mpw, reader, metadata, baseName)
 		if err != nil {
 			pw.CloseWithError(err)
 		}
 	}()
 
-	// TODO: Create proper interface for `doRaw` that can use other types than RunnerCredentials
-	mappedConfig := common.RunnerCredentials{
-		URL:       config.URL,
-		Token:     config.Token,
-		TLSCAFile: config.TLSCAFile,
-	}
-
 	query := url.Values{}
 	if expireIn != "" {
 		query.Set("expire_in", expireIn)
@@ -321,12 +437,7 @@ func (n *GitLabClient) UploadRawArtifacts(config common.BuildCredentials, reader
 
 	headers := make(http.Header)
 	headers.Set("BUILD-TOKEN", config.Token)
-	res, err := n.doRaw(mappedConfig, "POST", fmt.Sprintf("builds/%d/artifacts?%s", config.ID, query.Encode()), pr, mpw.FormDataContentType(), headers)
-
-	log := logrus.WithFields(logrus.Fields{
-		"id":    config.ID,
-		"token": helpers.ShortenToken(config.Token),
-	})
+	res, err := n.doRaw(mappedConfig, retryArtifacts, "POST", fmt.Sprintf("builds/%d/artifacts?%s", config.ID, query.Encode()), pr, mpw.FormDataContentType(), headers)
 
 	if res != nil {
 		log = log.WithField("responseStatus", res.Status)
@@ -379,20 +490,17 @@ func (n *GitLabClient) UploadArtifacts(config common.BuildCredentials, artifacts
 	}
 
 	baseName := filepath.Base(artifactsFile)
-	return n.UploadRawArtifacts(config, file, baseName, "")
+	return n.UploadRawArtifacts(config, file, nil, baseName, "")
 }
 
 func (n *GitLabClient) DownloadArtifacts(config common.BuildCredentials, artifactsFile string) common.DownloadState {
 	// TODO: Create proper interface for `doRaw` that can use other types than RunnerCredentials
-	mappedConfig := common.RunnerCredentials{
-		URL:       config.URL,
-		Token:     config.Token,
-		TLSCAFile: config.TLSCAFile,
-	}
+	mappedConfig := config.RunnerCredentials()
+	mappedConfig.Retry = artifactsRetryConfig
 
 	headers := make(http.Header)
 	headers.Set("BUILD-TOKEN", config.Token)
-	res, err := n.doRaw(mappedConfig, "GET", fmt.Sprintf("builds/%d/artifacts", config.ID), nil, "", headers)
+	res, err := n.doRaw(mappedConfig, retryArtifacts, "GET", fmt.Sprintf("builds/%d/artifacts", config.ID), nil, "", headers)
 
 	log := logrus.WithFields(logrus.Fields{
 		"id":    config.ID,
@@ -1,14 +1,17 @@
 package network
 
 import (
+	"compress/gzip"
 	"encoding/json"
 	"fmt"
 	"github.com/stretchr/testify/assert"
 	. "gitlab.com/gitlab-org/gitlab-ci-multi-runner/common"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 )
 
@@ -420,6 +423,65 @@ func TestUpdateBuild(t *testing.T) {
 	assert.Equal(t, UpdateAbort, state)
 }
 
+func TestUpdateBuildCompression(t *testing.T) {
+	var encodings []string
+	rejectGzip := false
+
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		encodings = append(encodings, r.Header.Get("Content-Encoding"))
+		if r.Header.Get("Content-Encoding") == "gzip" && rejectGzip {
+			w.WriteHeader(415)
+			return
+		}
+
+		reader := io.Reader(r.Body)
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			gzipReader, err := gzip.NewReader(r.Body)
+			if !assert.NoError(t, err) {
+				w.WriteHeader(400)
+				return
+			}
+			reader = gzipReader
+		}
+
+		var req UpdateBuildRequest
+		err := json.NewDecoder(reader).Decode(&req)
+		assert.NoError(t, err)
+		assert.True(t, req.Info.Features.TraceCompression)
+		assert.Equal(t, 2000, len(*req.Trace))
+
+		w.Header().Set("Accept-Encoding", "gzip, deflate")
+		w.WriteHeader(200)
+	}
+
+	s := httptest.NewServer(http.HandlerFunc(handler))
+	defer s.Close()
+
+	config := RunnerConfig{
+		RunnerCredentials: RunnerCredentials{
+			URL:   s.URL,
+			Token: "token",
+		},
+	}
+
+	trace := strings.Repeat("x", 2000)
+	c := GitLabClient{}
+
+	state := c.UpdateBuild(config, 10, "running", &trace)
+	assert.Equal(t, UpdateSucceeded, state)
+	state = c.UpdateBuild(config, 10, "running", &trace)
+	assert.Equal(t, UpdateSucceeded, state)
+	assert.Equal(t, []string{"", "gzip"}, encodings, "the trace should be compressed after GitLab accepts gzip")
+
+	encodings = nil
+	rejectGzip = true
+	state = c.UpdateBuild(config, 10, "running", &trace)
+	assert.Equal(t, UpdateSucceeded, state)
+	state = c.UpdateBuild(config, 10, "running", &trace)
+	assert.Equal(t, UpdateSucceeded, state)
+	assert.Equal(t, []string{"gzip", "", ""}, encodings, "the trace should be sent uncompressed after GitLab rejects gzip")
+}
+
 func TestArtifactsUpload(t *testing.T) {
 	handler := func(w http.ResponseWriter, r *http.Request) {
 		if r.URL.Path != "/ci/api/v1/builds/10/artifacts" {
@@ -488,3 +550,46 @@ func TestArtifactsUpload(t *testing.T) {
 	state = c.UploadArtifacts(invalidToken, tempFile.Name())
 	assert.Equal(t, UploadForbidden, state, "Artifacts should be rejected if invalid token")
 }
+
+func TestArtifactsUploadWithMetadata(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ci/api/v1/builds/10/artifacts" {
+			w.WriteHeader(404)
+			return
+		}
+
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			w.WriteHeader(400)
+			return
+		}
+		body, err := ioutil.ReadAll(file)
+		assert.NoError(t, err)
+		assert.Equal(t, "content", string(body))
+
+		metadata, header, err := r.FormFile("metadata")
+		if err != nil {
+			w.WriteHeader(400)
+			return
+		}
+		body, err = ioutil.ReadAll(metadata)
+		assert.NoError(t, err)
+		assert.Equal(t, "metadata", string(body))
+		assert.Equal(t, "metadata.gz", header.Filename)
+
+		w.WriteHeader(201)
+	}
+
+	s := httptest.NewServer(http.HandlerFunc(handler))
+	defer s.Close()
+
+	config := BuildCredentials{
+		ID:    10,
+		URL:   s.URL,
+		Token: "token",
+	}
+
+	c := GitLabClient{}
+	state := c.UploadRawArtifacts(config, strings.NewReader("content"), strings.NewReader("metadata"), "artifacts.zip", "")
+	assert.Equal(t, UploadSucceeded, state, "Artifacts should be uploaded with metadata")
+}
@@ -0,0 +1,163 @@
+package network
+
+import (
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"gitlab.com/gitlab-org/gitlab-ci-multi-runner/common"
+)
+
+// The endpoints select the retry budget, they can be configured in the [runners.retry.endpoints] section
+const (
+	retryRegisterRunner = "register_runner"
+	retryDeleteRunner   = "delete_runner"
+	retryVerifyRunner   = "verify_runner"
+	retryGetBuild       = "get_build"
+	retryUpdateBuild    = "update_build"
+	retryPatchTrace     = "patch_trace"
+	retryFinishBuild    = "finish_build"
+	retryArtifacts      = "artifacts"
+	retryArtifactsChunk = "artifacts_chunk"
+)
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    3,
+	MaxTime:        time.Minute,
+	InitialBackoff: time.Second,
+	MaxBackoff:     30 * time.Second,
+}
+
+var defaultRetryBudgets = map[string]common.RetryBudget{
+	// Registering isn't idempotent, when GitLab handled the request but the response got lost
+	// a retry would register another runner or leave the assigned build never run
+	retryRegisterRunner: {MaxAttempts: 1},
+	retryGetBuild:       {MaxAttempts: 1},
+
+	// The trace is updated periodically, a failed update is retried by the next one
+	retryUpdateBuild: {MaxAttempts: 1},
+	retryPatchTrace:  {MaxAttempts: 1},
+
+	// The final update of the build is retried until it succeeds or the hour passes
+	retryFinishBuild: {MaxTime: 3600},
+
+	// A failed chunk is resumed by the next attempt of the artifacts upload
+	retryArtifactsChunk: {MaxAttempts: 1},
+}
+
+// RetryPolicy retries with capped exponential backoff and jitter, as long as the
+// number of attempts and the time spent on them stays within the budget.
+// The zero policy sends the request only once.
+type RetryPolicy struct {
+	MaxAttempts    int
+	MaxTime        time.Duration
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// NewRetryPolicy returns the policy of the endpoint, the defaults are overridden by the configuration
+func NewRetryPolicy(config *common.RetryConfig, endpoint string) RetryPolicy {
+	policy := DefaultRetryPolicy
+	if config != nil {
+		if config.InitialBackoff > 0 {
+			policy.InitialBackoff = time.Duration(config.InitialBackoff) * time.Second
+		}
+		if config.MaxBackoff > 0 {
+			policy.MaxBackoff = time.Duration(config.MaxBackoff) * time.Second
+		}
+		policy.applyBudget(config.RetryBudget)
+	}
+
+	policy.applyBudget(defaultRetryBudgets[endpoint])
+	if config != nil && config.Endpoints[endpoint] != nil {
+		policy.applyBudget(*config.Endpoints[endpoint])
+	}
+	return policy
+}
+
+func (p *RetryPolicy) applyBudget(budget common.RetryBudget) {
+	if budget.MaxAttempts <= 0 && budget.MaxTime <= 0 {
+		return
+	}
+
+	p.MaxAttempts = budget.MaxAttempts
+	p.MaxTime = time.Duration(budget.MaxTime) * time.Second
+}
+
+// Backoff returns how long to wait before the retry, the first retry is 1
+func (p *RetryPolicy) Backoff(retry int) time.Duration {
+	backoff := p.InitialBackoff
+	for i := 1; i < retry && (p.MaxBackoff <= 0 || backoff < p.MaxBackoff); i++ {
+		backoff *= 2
+	}
+	if p.MaxBackoff > 0 && backoff > p.MaxBackoff {
+		backoff = p.MaxBackoff
+	}
+
+	// Keep at least a half of the backoff, so the jitter doesn't make retries too early
+	if half := backoff / 2; half > 0 {
+		backoff = half + time.Duration(rand.Int63n(int64(half)+1))
+	}
+	return backoff
+}
+
+// Do calls the handler until it doesn't ask to retry or the budget is spent.
+// The handler can ask to wait longer than the backoff, eg. because of Retry-After.
+func (p *RetryPolicy) Do(log *logrus.Entry, handler func() (retry bool, retryAfter time.Duration)) {
+	started := time.Now()
+
+	for attempt := 1; ; attempt++ {
+		retry, retryAfter := handler()
+		if !retry {
+			return
+		} else if p.MaxAttempts <= 0 && p.MaxTime <= 0 {
+			return
+		} else if p.MaxAttempts > 0 && attempt >= p.MaxAttempts {
+			return
+		}
+
+		wait := p.Backoff(attempt)
+		if retryAfter > wait {
+			wait = retryAfter
+		}
+		if p.MaxTime > 0 && time.Since(started)+wait > p.MaxTime {
+			return
+		}
+
+		if log != nil {
+			log.WithField("attempt", attempt).Warningln("Retrying in", wait, "...")
+		}
+		time.Sleep(wait)
+	}
+}
+
+// isRetryableStatus returns true for the responses of overloaded or temporarily unavailable GitLab
+func isRetryableStatus(statusCode int) bool {
+	switch statusCode {
+	case 429, 500, 502, 503, 504:
+		return true
+	default:
+		return false
+	}
+}
+
+// parseRetryAfter accepts the delay in seconds or the date
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		if wait := date.Sub(time.Now()); wait > 0 {
+			return wait
+		}
+	}
+	return 0
+}
@@ -0,0 +1,123 @@
+package network
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	. "gitlab.com/gitlab-org/gitlab-ci-multi-runner/common"
+)
+
+func TestNewRetryPolicy(t *testing.T) {
+	policy := NewRetryPolicy(nil, retryVerifyRunner)
+	assert.Equal(t, DefaultRetryPolicy, policy)
+
+	policy = NewRetryPolicy(nil, retryGetBuild)
+	assert.Equal(t, 1, policy.MaxAttempts, "the build request shouldn't be sent again")
+
+	policy = NewRetryPolicy(nil, retryUpdateBuild)
+	assert.Equal(t, 1, policy.MaxAttempts)
+	assert.Equal(t, time.Duration(0), policy.MaxTime)
+
+	config := &RetryConfig{
+		RetryBudget:    RetryBudget{MaxAttempts: 5},
+		InitialBackoff: 2,
+		MaxBackoff:     10,
+		Endpoints: map[string]*RetryBudget{
+			retryFinishBuild: {MaxAttempts: 20, MaxTime: 600},
+		},
+	}
+
+	policy = NewRetryPolicy(config, retryVerifyRunner)
+	assert.Equal(t, 5, policy.MaxAttempts)
+	assert.Equal(t, time.Duration(0), policy.MaxTime)
+	assert.Equal(t, 2*time.Second, policy.InitialBackoff)
+	assert.Equal(t, 10*time.Second, policy.MaxBackoff)
+
+	policy = NewRetryPolicy(config, retryFinishBuild)
+	assert.Equal(t, 20, policy.MaxAttempts)
+	assert.Equal(t, 10*time.Minute, policy.MaxTime)
+
+	policy = NewRetryPolicy(config, retryPatchTrace)
+	assert.Equal(t, 1, policy.MaxAttempts, "the default budget of the endpoint should be kept")
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{InitialBackoff: time.Second, MaxBackoff: 5 * time.Second}
+
+	for retry, expected := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		backoff := policy.Backoff(retry + 1)
+		assert.True(t, backoff >= expected/2 && backoff <= expected, "%v should be between %v and %v", backoff, expected/2, expected)
+	}
+}
+
+func TestRetryPolicyDo(t *testing.T) {
+	attempts := 0
+	policy := RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}
+	policy.Do(nil, func() (bool, time.Duration) {
+		attempts++
+		return true, 0
+	})
+	assert.Equal(t, 3, attempts)
+
+	attempts = 0
+	policy = RetryPolicy{}
+	policy.Do(nil, func() (bool, time.Duration) {
+		attempts++
+		return true, 0
+	})
+	assert.Equal(t, 1, attempts, "the zero policy should not retry")
+
+	attempts = 0
+	policy = RetryPolicy{MaxAttempts: 3, MaxTime: time.Second}
+	policy.Do(nil, func() (bool, time.Duration) {
+		attempts++
+		return true, time.Hour
+	})
+	assert.Equal(t, 1, attempts, "the Retry-After longer than the budget should stop retrying")
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	assert.Equal(t, 5*time.Second, parseRetryAfter("5"))
+	assert.Equal(t, time.Duration(0), parseRetryAfter(""))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("invalid"))
+	assert.Equal(t, time.Duration(0), parseRetryAfter(time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)))
+
+	wait := parseRetryAfter(time.Now().Add(time.Hour).UTC().Format(http.TimeFormat))
+	assert.True(t, wait > 59*time.Minute && wait <= time.Hour)
+}
+
+func TestClientRetriesReplayableRequests(t *testing.T) {
+	var bodies []string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		if len(bodies) < 3 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(503)
+			return
+		}
+		w.WriteHeader(200)
+	}))
+	defer s.Close()
+
+	c, _ := newClient(RunnerCredentials{
+		URL: s.URL,
+	})
+	policy := RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Millisecond}
+
+	statusCode, statusText, _ := c.doJSON(policy, "test/ok", "POST", 200, map[string]string{"key": "value"}, nil)
+	assert.Equal(t, 200, statusCode, statusText)
+	assert.Equal(t, []string{`{"key":"value"}`, `{"key":"value"}`, `{"key":"value"}`}, bodies)
+
+	bodies = nil
+	res, err := c.do(policy, "test/ok", "POST", ioutil.NopCloser(strings.NewReader("stream")), "text/plain", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 503, res.StatusCode)
+	assert.Equal(t, []string{"stream"}, bodies, "the stream can't be sent again")
+}
@@ -0,0 +1,59 @@
+package network
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// traceCompressionMinSize is the smallest trace body worth compressing
+const traceCompressionMinSize = 1024
+
+const (
+	traceCompressionUnknown int32 = iota
+	traceCompressionAccepted
+	traceCompressionRejected
+)
+
+// traceCompression is negotiated with GitLab: the traces are compressed after GitLab
+// announces that it accepts gzip, and never again after it rejects a compressed body
+type traceCompression struct {
+	state int32
+}
+
+// update checks whether the response announces that gzip-encoded requests are accepted
+func (t *traceCompression) update(res *http.Response) {
+	for _, value := range res.Header[http.CanonicalHeaderKey("Accept-Encoding")] {
+		for _, encoding := range strings.Split(value, ",") {
+			encoding = strings.TrimSpace(strings.SplitN(encoding, ";", 2)[0])
+			if encoding == "gzip" {
+				atomic.CompareAndSwapInt32(&t.state, traceCompressionUnknown, traceCompressionAccepted)
+				return
+			}
+		}
+	}
+}
+
+func (t *traceCompression) enabled() bool {
+	return atomic.LoadInt32(&t.state) == traceCompressionAccepted
+}
+
+func (t *traceCompression) reject() {
+	atomic.StoreInt32(&t.state, traceCompressionRejected)
+}
+
+func gzipBody(data []byte) ([]byte, error) {
+	var buffer bytes.Buffer
+	writer := gzip.NewWriter(&buffer)
+	_, err := writer.Write(data)
+	if err != nil {
+		return nil, err
+	}
+	err = writer.Close()
+	if err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
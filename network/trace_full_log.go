@@ -0,0 +1,90 @@
+package network
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"time"
+
+	"gitlab.com/gitlab-org/gitlab-ci-multi-runner/common"
+	"gitlab.com/gitlab-org/gitlab-ci-multi-runner/helpers"
+)
+
+const fullLogArchiveName = "build-log.zip"
+const fullLogFileName = "build.log"
+
+// newFullLogSpool keeps the untruncated trace, only in a file as it's not limited
+func newFullLogSpool() *traceSpool {
+	spool := newTraceSpool()
+	if spool.file == nil {
+		return nil
+	}
+	return spool
+}
+
+// writeFullLogArchive writes the zip archive of the untruncated trace, the trace is read in parts
+func writeFullLogArchive(spool *traceSpool, w io.Writer) error {
+	archive := zip.NewWriter(w)
+
+	header := &zip.FileHeader{
+		Name:   fullLogFileName,
+		Method: zip.Deflate,
+	}
+	header.SetModTime(time.Now())
+	header.SetMode(0644)
+
+	file, err := archive.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+
+	for offset := 0; offset < spool.Len(); offset += maxTracePatchSize {
+		data, err := spool.ReadRange(offset, offset+maxTracePatchSize)
+		if err != nil {
+			return err
+		}
+
+		_, err = file.Write(data)
+		if err != nil {
+			return err
+		}
+	}
+
+	return archive.Close()
+}
+
+// uploadFullLog uploads the untruncated trace as the build artifacts, the result is added to the trace
+func (c *clientBuildTrace) uploadFullLog() {
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(writeFullLogArchive(c.fullLog, writer))
+	}()
+
+	credentials := common.BuildCredentials{
+		ID:          c.buildCredentials.ID,
+		Token:       c.buildCredentials.Token,
+		URL:         c.config.URL,
+		TLSCAFile:   c.config.TLSCAFile,
+		TLSCertFile: c.config.TLSCertFile,
+		TLSKeyFile:  c.config.TLSKeyFile,
+		Proxy:       c.config.Proxy,
+	}
+	state := c.client.UploadRawArtifacts(credentials, reader, nil, fullLogArchiveName, "")
+	reader.Close()
+
+	if state != common.UploadSucceeded {
+		c.config.Log().WithField("build", c.id).Warningln("Failed to upload the untruncated trace")
+		c.spool.WriteString(fmt.Sprintf("%sFailed to upload the untruncated build log as the build artifacts.%s\n",
+			helpers.ANSI_BOLD_RED,
+			helpers.ANSI_RESET,
+		))
+		return
+	}
+
+	c.spool.WriteString(fmt.Sprintf("%sThe untruncated build log was uploaded as the build artifacts, %s in %s.%s\n",
+		helpers.ANSI_BOLD_GREEN,
+		fullLogFileName,
+		fullLogArchiveName,
+		helpers.ANSI_RESET,
+	))
+}
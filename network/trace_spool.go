@@ -0,0 +1,316 @@
+package network
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"gitlab.com/gitlab-org/gitlab-ci-multi-runner/common"
+	"gitlab.com/gitlab-org/gitlab-ci-multi-runner/helpers"
+)
+
+// TraceSpoolDirectory keeps the build traces, the temporary directory is used if empty
+var TraceSpoolDirectory string
+
+const traceSpoolPrefix = "gitlab-runner-trace-"
+const pendingTraceSuffix = ".json"
+
+// traceSpoolMaxAge is the age of the spool files left by the killed runners and
+// of the pending traces of the runners removed from the configuration, when they are removed
+const traceSpoolMaxAge = 7 * 24 * time.Hour
+
+// traceSpool keeps the build trace in a temporary file, so only the parts being sent are kept in memory.
+// The trace is kept in memory if the file can't be created.
+type traceSpool struct {
+	lock   sync.Mutex
+	file   *os.File
+	writer *bufio.Writer
+	memory bytes.Buffer
+	size   int
+}
+
+// openTraceSpool opens the kept trace to be sent again, the trace is empty if the file doesn't exist
+func openTraceSpool(fileName string) (*traceSpool, error) {
+	file, err := os.Open(fileName)
+	if os.IsNotExist(err) {
+		return &traceSpool{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	fi, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &traceSpool{
+		file:   file,
+		writer: bufio.NewWriter(file),
+		size:   int(fi.Size()),
+	}, nil
+}
+
+func newTraceSpool() *traceSpool {
+	spool := &traceSpool{}
+
+	file, err := ioutil.TempFile(TraceSpoolDirectory, traceSpoolPrefix)
+	if err != nil {
+		logrus.WithError(err).Warningln("Failed to create the trace spool, keeping the trace in memory")
+		return spool
+	}
+
+	spool.file = file
+	spool.writer = bufio.NewWriter(file)
+	return spool
+}
+
+func (s *traceSpool) WriteString(data string) (n int, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.writer != nil {
+		n, err = s.writer.WriteString(data)
+	} else {
+		n, err = s.memory.WriteString(data)
+	}
+	s.size += n
+	return
+}
+
+func (s *traceSpool) WriteRune(r rune) (n int, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.writer != nil {
+		n, err = s.writer.WriteRune(r)
+	} else {
+		n, err = s.memory.WriteRune(r)
+	}
+	s.size += n
+	return
+}
+
+func (s *traceSpool) Len() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.size
+}
+
+// ReadRange returns the part of the trace between the offset and the limit
+func (s *traceSpool) ReadRange(offset, limit int) ([]byte, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if limit > s.size {
+		limit = s.size
+	}
+	if offset >= limit {
+		return []byte{}, nil
+	}
+
+	if s.file == nil {
+		return s.memory.Bytes()[offset:limit], nil
+	}
+
+	err := s.writer.Flush()
+	if err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, limit-offset)
+	_, err = s.file.ReadAt(data, int64(offset))
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// Head returns the beginning of the trace, the trace longer than the limit ends with the size of the part not returned
+func (s *traceSpool) Head(limit int) (string, error) {
+	size := s.Len()
+	if size > limit {
+		size = limit
+	}
+
+	data, err := s.ReadRange(0, size)
+	if err != nil {
+		return "", err
+	}
+	return string(data) + truncatedTraceMarker(s.Len()-size), nil
+}
+
+func truncatedTraceMarker(skipped int) string {
+	if skipped <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("\n%s[... %v bytes not sent ...]%s\n", helpers.ANSI_BOLD_RED, skipped, helpers.ANSI_RESET)
+}
+
+// Close closes the spool file, the trace is kept
+func (s *traceSpool) Close() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.file != nil {
+		s.file.Close()
+	}
+}
+
+// Remove deletes the spool file, the trace is not available anymore
+func (s *traceSpool) Remove() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.file != nil {
+		s.file.Close()
+		os.Remove(s.file.Name())
+	}
+}
+
+// Keep stores the trace that failed to be sent, so it can be sent later by ResendPendingTraces
+func (s *traceSpool) Keep(pending pendingTrace) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.file == nil {
+		return os.ErrNotExist
+	}
+
+	err := s.writer.Flush()
+	if err != nil {
+		return err
+	}
+	s.file.Close()
+
+	data, err := json.Marshal(pending)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(s.file.Name()+pendingTraceSuffix, data, 0600)
+}
+
+// pendingTrace identifies the build and the runner of a kept trace, the runner token is not stored.
+// The trace is sent from the offset accepted by GitLab, if it accepted the incremental updates.
+type pendingTrace struct {
+	URL         string            `json:"url"`
+	Runner      string            `json:"runner"`
+	ID          int               `json:"id"`
+	Token       string            `json:"token,omitempty"`
+	State       common.BuildState `json:"state"`
+	SentTrace   int               `json:"sent_trace"`
+	Incremental bool              `json:"incremental"`
+}
+
+func (p *pendingTrace) findRunner(runners []*common.RunnerConfig) *common.RunnerConfig {
+	for _, runner := range runners {
+		if runner.URL == p.URL && runner.ShortDescription() == p.Runner {
+			return runner
+		}
+	}
+	return nil
+}
+
+func removeTraceFiles(fileNames ...string) {
+	for _, fileName := range fileNames {
+		os.Remove(fileName)
+	}
+}
+
+func isExpiredTraceFile(fi os.FileInfo) bool {
+	return time.Since(fi.ModTime()) > traceSpoolMaxAge
+}
+
+func resendPendingTrace(client common.Network, runners []*common.RunnerConfig, fileName string) {
+	traceFile := strings.TrimSuffix(fileName, pendingTraceSuffix)
+
+	fi, err := os.Stat(fileName)
+	if err != nil {
+		return
+	}
+
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return
+	}
+
+	var pending pendingTrace
+	err = json.Unmarshal(data, &pending)
+	if err != nil {
+		logrus.WithError(err).Warningln("Failed to read the pending trace", fileName)
+		if isExpiredTraceFile(fi) {
+			removeTraceFiles(traceFile, fileName)
+		}
+		return
+	}
+
+	// the runners of other configurations can use the same directory, so only the old traces are removed
+	runner := pending.findRunner(runners)
+	if runner == nil {
+		if isExpiredTraceFile(fi) {
+			logrus.WithFields(logrus.Fields{
+				"runner": pending.Runner,
+				"build":  pending.ID,
+			}).Warningln("Removing the pending trace of unknown runner")
+			removeTraceFiles(traceFile, fileName)
+		}
+		return
+	}
+
+	spool, err := openTraceSpool(traceFile)
+	if err != nil {
+		return
+	}
+	defer spool.Close()
+
+	trace := newBuildTrace(client, *runner, &common.BuildCredentials{ID: pending.ID, Token: pending.Token})
+	trace.resume(spool, pending)
+	if trace.finalUpdate() == common.UpdateFailed {
+		return
+	}
+
+	runner.Log().WithField("build", pending.ID).Infoln("Sending the pending trace...", "done")
+	removeTraceFiles(traceFile, fileName)
+}
+
+// removeOrphanedSpool removes the spool file left without the pending trace, when its runner was killed
+func removeOrphanedSpool(fileName string) {
+	if _, err := os.Stat(fileName + pendingTraceSuffix); !os.IsNotExist(err) {
+		return
+	}
+
+	fi, err := os.Stat(fileName)
+	if err != nil || !fi.Mode().IsRegular() || !isExpiredTraceFile(fi) {
+		return
+	}
+
+	logrus.WithField("file", fileName).Warningln("Removing the orphaned trace spool")
+	removeTraceFiles(fileName)
+}
+
+// ResendPendingTraces sends the traces which failed to be sent when their builds finished
+func ResendPendingTraces(client common.Network, runners []*common.RunnerConfig) {
+	directory := TraceSpoolDirectory
+	if directory == "" {
+		directory = os.TempDir()
+	}
+
+	files, _ := filepath.Glob(filepath.Join(directory, traceSpoolPrefix+"*"))
+	for _, fileName := range files {
+		if strings.HasSuffix(fileName, pendingTraceSuffix) {
+			resendPendingTrace(client, runners, fileName)
+		} else {
+			removeOrphanedSpool(fileName)
+		}
+	}
+}
@@ -0,0 +1,252 @@
+package network
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-ci-multi-runner/common"
+)
+
+func useTestTraceSpoolDirectory(t *testing.T) func() {
+	directory, err := ioutil.TempDir("", "traces")
+	require.NoError(t, err)
+
+	TraceSpoolDirectory = directory
+	return func() {
+		TraceSpoolDirectory = ""
+		os.RemoveAll(directory)
+	}
+}
+
+func TestTraceSpool(t *testing.T) {
+	defer useTestTraceSpoolDirectory(t)()
+
+	spool := newTraceSpool()
+	require.NotNil(t, spool.file)
+
+	spool.WriteString("test ")
+	spool.WriteRune('ż')
+	assert.Equal(t, 7, spool.Len())
+
+	data, err := spool.ReadRange(2, 4)
+	assert.NoError(t, err)
+	assert.Equal(t, "st", string(data))
+
+	trace, err := spool.Head(spool.Len())
+	assert.NoError(t, err)
+	assert.Equal(t, "test ż", trace)
+
+	spool.Remove()
+	_, err = os.Stat(spool.file.Name())
+	assert.True(t, os.IsNotExist(err), "the spool should be removed")
+}
+
+func TestTracePatchIsLimited(t *testing.T) {
+	defer useTestTraceSpoolDirectory(t)()
+
+	spool := newTraceSpool()
+	defer spool.Remove()
+	spool.WriteString(strings.Repeat("a", maxTracePatchSize+10))
+
+	patch, err := newTracePatch(spool, 5)
+	require.NoError(t, err)
+	assert.Equal(t, 5+maxTracePatchSize, patch.Limit())
+	assert.Equal(t, maxTracePatchSize, len(patch.Patch()))
+
+	patch, err = newTracePatch(spool, patch.Limit())
+	require.NoError(t, err)
+	assert.Equal(t, 5, len(patch.Patch()))
+}
+
+type unavailableTraceNetwork struct {
+	updateTraceNetwork
+	unavailable bool
+}
+
+func (m *unavailableTraceNetwork) UpdateBuild(config common.RunnerConfig, id int, state common.BuildState, trace *string) common.UpdateState {
+	if m.unavailable {
+		return common.UpdateFailed
+	}
+	return m.updateTraceNetwork.UpdateBuild(config, id, state, trace)
+}
+
+func TestBuildTraceIsKeptAndResent(t *testing.T) {
+	defer useTestTraceSpoolDirectory(t)()
+
+	config := common.RunnerConfig{
+		RunnerCredentials: common.RunnerCredentials{
+			URL:   "https://gitlab.example.com/",
+			Token: "runner-token",
+			Retry: &common.RetryConfig{
+				Endpoints: map[string]*common.RetryBudget{
+					retryFinishBuild: {MaxAttempts: 1},
+				},
+			},
+		},
+	}
+
+	u := &unavailableTraceNetwork{unavailable: true}
+	b := newBuildTrace(u, config, &common.BuildCredentials{ID: successID})
+	b.start()
+	b.Write([]byte("test content"))
+	b.Success()
+
+	pending, _ := filepath.Glob(filepath.Join(TraceSpoolDirectory, "*.json"))
+	require.Equal(t, 1, len(pending), "the trace should be kept")
+
+	u.unavailable = false
+	ResendPendingTraces(u, []*common.RunnerConfig{{}})
+	assert.Nil(t, u.trace, "the trace should be sent only by the runner of the build")
+
+	ResendPendingTraces(u, []*common.RunnerConfig{&config})
+	require.NotNil(t, u.trace)
+	assert.Equal(t, "test content", *u.trace)
+	assert.Equal(t, common.Success, u.state)
+
+	files, _ := filepath.Glob(filepath.Join(TraceSpoolDirectory, traceSpoolPrefix+"*"))
+	assert.Empty(t, files, "the sent trace should be removed")
+}
+
+func TestTraceSpoolHeadIsLimited(t *testing.T) {
+	defer useTestTraceSpoolDirectory(t)()
+
+	spool := newTraceSpool()
+	defer spool.Remove()
+	spool.WriteString("test content")
+
+	trace, err := spool.Head(4)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(trace, "test\n"), "only the beginning should be read")
+	assert.Contains(t, trace, "[... 8 bytes not sent ...]")
+}
+
+type patchTraceNetwork struct {
+	updateTraceNetwork
+	patched string
+}
+
+func (m *patchTraceNetwork) PatchTrace(config common.RunnerConfig, buildCredentials *common.BuildCredentials, tracePatch common.BuildTracePatch) common.UpdateState {
+	m.patched += string(tracePatch.Patch())
+	return common.UpdateSucceeded
+}
+
+func TestBuildTraceFinalUpdateSendsPatches(t *testing.T) {
+	defer useTestTraceSpoolDirectory(t)()
+
+	u := &patchTraceNetwork{}
+	b := newBuildTrace(u, buildConfig, &common.BuildCredentials{ID: successID})
+	b.start()
+	b.Write([]byte("test content"))
+	b.Success()
+
+	assert.Equal(t, "test content", u.patched)
+	assert.Nil(t, u.trace, "the whole trace should not be sent")
+	assert.Equal(t, common.Success, u.state)
+}
+
+func TestOldTraceFilesAreRemoved(t *testing.T) {
+	defer useTestTraceSpoolDirectory(t)()
+
+	old := time.Now().Add(-2 * traceSpoolMaxAge)
+	create := func(name, content string, modified time.Time) string {
+		fileName := filepath.Join(TraceSpoolDirectory, traceSpoolPrefix+name)
+		require.NoError(t, ioutil.WriteFile(fileName, []byte(content), 0600))
+		require.NoError(t, os.Chtimes(fileName, modified, modified))
+		return fileName
+	}
+
+	orphaned := create("orphaned", "trace", old)
+	running := create("running", "trace", time.Now())
+	unknown := create("unknown", "trace", old)
+	unknownPending := create("unknown"+pendingTraceSuffix, `{"url":"https://other.example.com/","runner":"other","id":1}`, old)
+	recent := create("recent", "trace", old)
+	recentPending := create("recent"+pendingTraceSuffix, `{"url":"https://other.example.com/","runner":"other","id":2}`, time.Now())
+
+	u := &updateTraceNetwork{}
+	ResendPendingTraces(u, []*common.RunnerConfig{{}})
+	assert.Nil(t, u.trace, "the traces of unknown runners should not be sent")
+
+	for _, fileName := range []string{orphaned, unknown, unknownPending} {
+		_, err := os.Stat(fileName)
+		assert.True(t, os.IsNotExist(err), "%s should be removed", fileName)
+	}
+	for _, fileName := range []string{running, recent, recentPending} {
+		_, err := os.Stat(fileName)
+		assert.NoError(t, err, "%s should be kept", fileName)
+	}
+}
+
+type failingUpdateTraceNetwork struct {
+	patchTraceNetwork
+	unavailable bool
+	token       string
+}
+
+func (m *failingUpdateTraceNetwork) UpdateBuild(config common.RunnerConfig, id int, state common.BuildState, trace *string) common.UpdateState {
+	if m.unavailable {
+		return common.UpdateFailed
+	}
+	return m.patchTraceNetwork.UpdateBuild(config, id, state, trace)
+}
+
+func (m *failingUpdateTraceNetwork) PatchTrace(config common.RunnerConfig, buildCredentials *common.BuildCredentials, tracePatch common.BuildTracePatch) common.UpdateState {
+	m.token = buildCredentials.Token
+	return m.patchTraceNetwork.PatchTrace(config, buildCredentials, tracePatch)
+}
+
+func TestKeptTraceRecordsTheSentPatches(t *testing.T) {
+	defer useTestTraceSpoolDirectory(t)()
+
+	config := buildConfig
+	config.Retry = &common.RetryConfig{
+		Endpoints: map[string]*common.RetryBudget{
+			retryFinishBuild: {MaxAttempts: 1},
+		},
+	}
+
+	u := &failingUpdateTraceNetwork{unavailable: true}
+	b := newBuildTrace(u, config, &common.BuildCredentials{ID: successID, Token: "build-token"})
+	b.start()
+	b.Write([]byte("test content"))
+	b.Success()
+
+	files, _ := filepath.Glob(filepath.Join(TraceSpoolDirectory, "*"+pendingTraceSuffix))
+	require.Equal(t, 1, len(files), "the trace should be kept")
+	data, err := ioutil.ReadFile(files[0])
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"token":"build-token"`)
+	assert.Contains(t, string(data), `"sent_trace":12`)
+	assert.Contains(t, string(data), `"incremental":true`)
+}
+
+func TestKeptTraceIsResumedFromTheSentPatches(t *testing.T) {
+	defer useTestTraceSpoolDirectory(t)()
+
+	config := buildConfig
+	config.URL = "https://gitlab.example.com/"
+	config.Token = "runner-token"
+
+	traceFile := filepath.Join(TraceSpoolDirectory, traceSpoolPrefix+"resumed")
+	require.NoError(t, ioutil.WriteFile(traceFile, []byte("sent before|resumed"), 0600))
+	require.NoError(t, ioutil.WriteFile(traceFile+pendingTraceSuffix, []byte(`{"url":"https://gitlab.example.com/","runner":"`+
+		config.ShortDescription()+`","id":`+strconv.Itoa(successID)+`,"token":"build-token","state":"success","sent_trace":12,"incremental":true}`), 0600))
+
+	u := &failingUpdateTraceNetwork{}
+	ResendPendingTraces(u, []*common.RunnerConfig{&config})
+
+	assert.Equal(t, "resumed", u.patched, "only the part not accepted by GitLab should be sent")
+	assert.Equal(t, "build-token", u.token)
+	assert.Nil(t, u.trace, "the whole trace should not replace the sent one")
+	assert.Equal(t, common.Success, u.state)
+
+	files, _ := filepath.Glob(filepath.Join(TraceSpoolDirectory, traceSpoolPrefix+"*"))
+	assert.Empty(t, files, "the sent trace should be removed")
+}
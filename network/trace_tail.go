@@ -0,0 +1,49 @@
+package network
+
+import (
+	"unicode/utf8"
+)
+
+// traceTail keeps the last bytes of the trace exceeding the output limit,
+// it uses at most twice the size of memory
+type traceTail struct {
+	size    int
+	data    []byte
+	written int
+}
+
+func newTraceTail(size int) *traceTail {
+	return &traceTail{
+		size: size,
+		data: make([]byte, 0, 2*size),
+	}
+}
+
+func (t *traceTail) WriteRune(r rune) {
+	var encoded [utf8.UTFMax]byte
+	n := utf8.EncodeRune(encoded[:], r)
+
+	// Drop the older bytes before the buffer would have to grow
+	if len(t.data)+n > 2*t.size && len(t.data) > t.size {
+		t.data = append(t.data[:0], t.data[len(t.data)-t.size:]...)
+	}
+	t.written += n
+	t.data = append(t.data, encoded[:n]...)
+}
+
+// Bytes returns the kept tail, it starts at the beginning of a rune
+func (t *traceTail) Bytes() []byte {
+	data := t.data
+	if len(data) > t.size {
+		data = data[len(data)-t.size:]
+	}
+	for len(data) > 0 && !utf8.RuneStart(data[0]) {
+		data = data[1:]
+	}
+	return data
+}
+
+// Skipped returns how many bytes were dropped before the tail
+func (t *traceTail) Skipped() int {
+	return t.written - len(t.Bytes())
+}
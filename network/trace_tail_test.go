@@ -0,0 +1,27 @@
+package network
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTraceTail(t *testing.T) {
+	tail := newTraceTail(4)
+	for _, r := range "abcdefghij" {
+		tail.WriteRune(r)
+	}
+	assert.Equal(t, "ghij", string(tail.Bytes()))
+	assert.Equal(t, 6, tail.Skipped())
+	assert.True(t, cap(tail.data) <= 8, "the tail should not grow")
+}
+
+func TestTraceTailStartsAtRune(t *testing.T) {
+	tail := newTraceTail(5)
+	for _, r := range strings.Repeat("ż", 10) {
+		tail.WriteRune(r)
+	}
+	assert.Equal(t, "żż", string(tail.Bytes()))
+	assert.Equal(t, 16, tail.Skipped())
+}
@@ -1,6 +1,7 @@
 package shells
 
 import (
+	"fmt"
 	"path"
 	"path/filepath"
 	"strconv"
@@ -8,6 +9,7 @@ import (
 
 	"errors"
 	"gitlab.com/gitlab-org/gitlab-ci-multi-runner/common"
+	"gitlab.com/gitlab-org/gitlab-ci-multi-runner/helpers/archives"
 )
 
 type AbstractShell struct {
@@ -19,7 +21,7 @@ func (b *AbstractShell) GetFeatures(features *common.FeaturesInfo) {
 }
 
 func (b *AbstractShell) GetSupportedOptions() []string {
-	return []string{"artifacts", "cache", "dependencies", "after_script"}
+	return []string{"artifacts", "cache", "dependencies", "before_script", "script", "after_script"}
 }
 
 func (b *AbstractShell) writeCdBuildDir(w ShellWriter, info common.ShellScriptInfo) {
@@ -44,19 +46,102 @@ func (b *AbstractShell) writeTLSCAInfo(w ShellWriter, build *common.Build, key s
 	}
 }
 
-func (b *AbstractShell) writeCloneCmd(w ShellWriter, build *common.Build, projectDir string) {
+func (b *AbstractShell) writeTLSClientInfo(w ShellWriter, build *common.Build, certKey string, keyKey string) {
+	if build.TLSAuthCert != "" && build.TLSAuthKey != "" {
+		w.Variable(common.BuildVariable{
+			Key:      certKey,
+			Value:    build.TLSAuthCert,
+			Public:   true,
+			Internal: true,
+			File:     true,
+		})
+		w.Variable(common.BuildVariable{
+			Key:      keyKey,
+			Value:    build.TLSAuthKey,
+			Internal: true,
+			File:     true,
+		})
+	}
+}
+
+// writeProxyInfo exports the proxy of the runner, unless the build defines the variable itself
+func (b *AbstractShell) writeProxyInfo(w ShellWriter, build *common.Build, keys ...string) {
+	if build.Runner == nil || build.Runner.Proxy == "" {
+		return
+	}
+
+	variables := build.GetAllVariables()
+	for _, key := range keys {
+		if variables.Get(key) != "" {
+			continue
+		}
+
+		w.Variable(common.BuildVariable{
+			Key:      key,
+			Value:    build.Runner.Proxy,
+			Internal: true,
+		})
+	}
+}
+
+func (b *AbstractShell) writeServerInfo(w ShellWriter, build *common.Build) {
+	b.writeTLSCAInfo(w, build, "CI_SERVER_TLS_CA_FILE")
+	b.writeTLSClientInfo(w, build, "CI_SERVER_TLS_CERT_FILE", "CI_SERVER_TLS_KEY_FILE")
+	b.writeProxyInfo(w, build, "CI_SERVER_PROXY")
+}
+
+func (b *AbstractShell) writeGitMirrorCmd(w ShellWriter, info common.ShellScriptInfo) (mirrorDir string) {
+	// Shallow clones are cheap already and can't borrow objects from the mirror
+	if info.Build.GetGitDepth() != "" {
+		return ""
+	}
+
+	mirrorDir = info.Build.GitMirrorDir()
+	if mirrorDir == "" {
+		return ""
+	}
+
+	args := []string{
+		"git-mirror-updater",
+		"--dir", mirrorDir,
+		"--url", info.Build.RepoURL,
+	}
+
+	b.guardRunnerCommand(w, info.RunnerCommand, "Updating git mirror", func() {
+		w.Notice("Updating git mirror...")
+		w.Command(info.RunnerCommand, args...)
+	})
+	return
+}
+
+func (b *AbstractShell) writeCloneCmd(w ShellWriter, build *common.Build, projectDir string, mirrorDir string) {
+	args := []string{"clone", build.RepoURL, projectDir}
+	if !build.IsGitCheckout() {
+		args = append(args, "--no-checkout")
+	}
+
 	w.RmDir(projectDir)
 	if depth := build.GetGitDepth(); depth != "" {
 		w.Notice("Cloning repository for %s with git depth set to %s...", build.RefName, depth)
-		w.Command("git", "clone", build.RepoURL, projectDir, "--depth", depth, "--branch", build.RefName)
+		args = append(args, "--depth", depth, "--branch", build.RefName)
 	} else {
 		w.Notice("Cloning repository...")
-		w.Command("git", "clone", build.RepoURL, projectDir)
+	}
+
+	if mirrorDir != "" {
+		// Borrow objects from the mirror, but copy them so the mirror can be pruned later
+		w.IfDirectory(mirrorDir)
+		w.Command("git", append(args, "--reference", mirrorDir, "--dissociate")...)
+		w.Else()
+		w.Command("git", args...)
+		w.EndIf()
+	} else {
+		w.Command("git", args...)
 	}
 	w.Cd(projectDir)
 }
 
-func (b *AbstractShell) writeFetchCmd(w ShellWriter, build *common.Build, projectDir string, gitDir string) {
+func (b *AbstractShell) writeFetchCmd(w ShellWriter, build *common.Build, projectDir string, gitDir string, mirrorDir string) {
 	depth := build.GetGitDepth()
 
 	w.IfDirectory(gitDir)
@@ -66,9 +151,16 @@ func (b *AbstractShell) writeFetchCmd(w ShellWriter, build *common.Build, projec
 		w.Notice("Fetching changes...")
 	}
 	w.Cd(projectDir)
-	w.Command("git", "clean", "-ffdx")
+	if cleanFlags := build.GetGitCleanFlags(); len(cleanFlags) > 0 {
+		w.Command("git", append([]string{"clean"}, cleanFlags...)...)
+	}
 	w.Command("git", "reset", "--hard")
 	w.Command("git", "remote", "set-url", "origin", build.RepoURL)
+	if mirrorDir != "" {
+		w.IfDirectory(mirrorDir)
+		w.Command("git", "fetch", mirrorDir, "+refs/heads/*:refs/remotes/origin/*", "+refs/tags/*:refs/tags/*")
+		w.EndIf()
+	}
 	if depth != "" {
 		var refspec string
 		if build.Tag {
@@ -81,18 +173,82 @@ func (b *AbstractShell) writeFetchCmd(w ShellWriter, build *common.Build, projec
 		w.Command("git", "fetch", "origin", "--prune", "+refs/heads/*:refs/remotes/origin/*", "+refs/tags/*:refs/tags/*")
 	}
 	w.Else()
-	b.writeCloneCmd(w, build, projectDir)
+	b.writeCloneCmd(w, build, projectDir, mirrorDir)
 	w.EndIf()
 }
 
 func (b *AbstractShell) writeCheckoutCmd(w ShellWriter, build *common.Build) {
+	if !build.IsGitCheckout() {
+		w.Notice("Skipping Git checkout")
+		return
+	}
+
 	w.Notice("Checking out %s as %s...", build.Sha[0:8], build.RefName)
 	// We remove a git index file, this is required if `git checkout` is terminated
 	w.RmFile(".git/index.lock")
 	w.Command("git", "checkout", "-q", build.Sha)
 }
 
-func (b *AbstractShell) cacheFile(build *common.Build, userKey string) (key, file string) {
+func (b *AbstractShell) writeGitLFSCmd(w ShellWriter, build *common.Build) {
+	if !build.IsGitCheckout() {
+		return
+	}
+
+	w.IfCmd("git-lfs", "version")
+	w.Notice("Fetching Git LFS objects...")
+	w.Command("git", "lfs", "install", "--local")
+	// Keep the object store in the cache directory, so it survives between builds
+	if path.IsAbs(build.CacheDir) || filepath.IsAbs(build.CacheDir) {
+		w.Command("git", "config", "lfs.storage", path.Join(build.CacheDir, "lfs"))
+	} else {
+		w.Notice("Skipping Git LFS cache, the cache directory is not an absolute path")
+	}
+	// The LFS endpoint is derived from origin which already carries the build token
+	w.Command("git", "lfs", "pull")
+	w.EndIf()
+}
+
+func (b *AbstractShell) writeSubmoduleUpdateCmd(w ShellWriter, build *common.Build, recursive bool) {
+	if recursive {
+		w.Notice("Updating/initializing submodules recursively...")
+	} else {
+		w.Notice("Updating/initializing submodules...")
+	}
+
+	// Sync .git/config to .gitmodules in case URL changes (e.g. new build token),
+	// relative URLs are resolved against origin which already carries the token
+	args := []string{"submodule", "sync"}
+	if recursive {
+		args = append(args, "--recursive")
+	}
+	w.Command("git", args...)
+
+	// Update / initialize submodules
+	args = []string{"submodule", "update", "--init"}
+	if recursive {
+		args = append(args, "--recursive")
+	}
+	w.Command("git", args...)
+}
+
+func (b *AbstractShell) writeSubmoduleUpdateCmds(w ShellWriter, build *common.Build) error {
+	switch build.GetSubmoduleStrategy() {
+	case common.SubmoduleNormal:
+		b.writeSubmoduleUpdateCmd(w, build, false)
+
+	case common.SubmoduleRecursive:
+		b.writeSubmoduleUpdateCmd(w, build, true)
+
+	case common.SubmoduleNone:
+		// Submodules are left untouched
+
+	default:
+		return errors.New("unknown GIT_SUBMODULE_STRATEGY")
+	}
+	return nil
+}
+
+func (b *AbstractShell) cacheFile(build *common.Build, userKey string, format archives.ArchiveFormat) (key, file string) {
 	if build.CacheDir == "" {
 		return
 	}
@@ -107,8 +263,9 @@ func (b *AbstractShell) cacheFile(build *common.Build, userKey string) (key, fil
 	if key == "" {
 		return
 	}
+	key += getCacheKeySuffix(build)
 
-	file = path.Join(build.CacheDir, key, "cache.zip")
+	file = path.Join(build.CacheDir, key, "cache."+string(format))
 	file, err := filepath.Rel(build.BuildDir, file)
 	if err != nil {
 		return "", ""
@@ -116,14 +273,141 @@ func (b *AbstractShell) cacheFile(build *common.Build, userKey string) (key, fil
 	return
 }
 
+// cacheKeyArguments returns the cache key and the helper arguments pointing to its archive,
+// keys computed from files are resolved by the helper inside the build environment
+func (b *AbstractShell) cacheKeyArguments(build *common.Build, key *cacheKey, format archives.ArchiveFormat) (string, []string, error) {
+	if !key.IsComputed() {
+		cacheKey, cacheFile := b.cacheFile(build, key.Value, format)
+		if cacheKey == "" {
+			return "", nil, nil
+		}
+		return cacheKey, []string{"--file", cacheFile}, nil
+	}
+
+	if len(key.Files) > maxCacheKeyFiles {
+		return "", nil, fmt.Errorf("cache key can be computed from at most %d files", maxCacheKeyFiles)
+	}
+
+	if build.CacheDir == "" {
+		return "", nil, nil
+	}
+
+	cacheDir, err := filepath.Rel(build.BuildDir, build.CacheDir)
+	if err != nil {
+		return "", nil, nil
+	}
+
+	variables := build.GetAllVariables()
+	prefix := variables.ExpandValue(key.Prefix)
+	if suffix := getCacheKeySuffix(build); suffix != "" {
+		prefix = strings.TrimPrefix(prefix+suffix, "-")
+	}
+
+	var files []string
+	args := []string{"--cache-dir", cacheDir}
+	if prefix != "" {
+		args = append(args, "--key-prefix", prefix)
+	}
+	for _, file := range key.Files {
+		file = variables.ExpandValue(file)
+		files = append(files, file)
+		args = append(args, "--key-file", file)
+	}
+
+	description := "<checksum of " + strings.Join(files, ", ") + ">"
+	if prefix != "" {
+		description = prefix + "-" + description
+	}
+	return description, args, nil
+}
+
+// validateCacheKeys checks that multiple caches don't share their archives,
+// the caches without the key would all use the default one
+func (b *AbstractShell) validateCacheKeys(build *common.Build, caches caches) error {
+	if len(caches) < 2 {
+		return nil
+	}
+
+	variables := build.GetAllVariables()
+	keys := make(map[string]bool)
+	for _, cache := range caches {
+		var key string
+		if cache.Key.IsComputed() {
+			key = variables.ExpandValue(cache.Key.Prefix)
+			for _, file := range cache.Key.Files {
+				key += "\x00" + variables.ExpandValue(file)
+			}
+		} else {
+			key = variables.ExpandValue(cache.Key.Value)
+			if key == "" {
+				return errors.New("every cache needs a key when more than one cache is defined")
+			}
+		}
+
+		if keys[key] {
+			return fmt.Errorf("the cache key %q is used by more than one cache", strings.Replace(key, "\x00", " ", -1))
+		}
+		keys[key] = true
+	}
+	return nil
+}
+
+func (b *AbstractShell) cacheFallbackKeys(build *common.Build, options *archivingOptions) []string {
+	if len(options.FallbackKeys) > 0 {
+		return options.FallbackKeys
+	}
+
+	// Use runner defaults, if job doesn't define any
+	if build.Runner.Cache != nil {
+		return build.Runner.Cache.FallbackKeys
+	}
+	return nil
+}
+
 func (o *archivingOptions) CommandArguments() (args []string) {
 	for _, path := range o.Paths {
 		args = append(args, "--path", path)
 	}
 
+	// The reports are uploaded with the artifacts
+	for _, path := range o.Reports.JUnit {
+		args = append(args, "--path", path)
+	}
+
 	if o.Untracked {
 		args = append(args, "--untracked")
 	}
+
+	// Excludes alone don't define anything to archive
+	if len(args) == 0 {
+		return
+	}
+
+	for _, exclude := range o.Exclude {
+		args = append(args, "--exclude", exclude)
+	}
+	return
+}
+
+// retryArguments pass the retry configuration of the runner to the helper commands
+func retryArguments(build *common.Build) (args []string) {
+	if build.Runner == nil || build.Runner.Retry == nil {
+		return
+	}
+
+	retry := build.Runner.Retry
+	if retry.MaxAttempts > 0 {
+		args = append(args, "--retry", strconv.Itoa(retry.MaxAttempts-1))
+	}
+	if retry.MaxTime > 0 {
+		args = append(args, "--retry-max-time", fmt.Sprintf("%ds", retry.MaxTime))
+	}
+	if retry.InitialBackoff > 0 {
+		args = append(args, "--retry-time", fmt.Sprintf("%ds", retry.InitialBackoff))
+	}
+	if retry.MaxBackoff > 0 {
+		args = append(args, "--retry-max-backoff", fmt.Sprintf("%ds", retry.MaxBackoff))
+	}
 	return
 }
 
@@ -140,52 +424,93 @@ func (b *AbstractShell) guardRunnerCommand(w ShellWriter, runnerCommand string,
 	w.EndIf()
 }
 
-func (b *AbstractShell) cacheExtractor(w ShellWriter, options *archivingOptions, info common.ShellScriptInfo) {
+func (b *AbstractShell) cacheExtractor(w ShellWriter, options *archivingOptions, info common.ShellScriptInfo) error {
 	if options == nil {
-		return
+		return nil
 	}
 
 	// Skip restoring cache if no cache is defined
 	if archiverArgs := options.CommandArguments(); len(archiverArgs) == 0 {
-		return
+		return nil
+	}
+
+	format, _, err := getCacheArchiveArguments(info.Build)
+	if err != nil {
+		return err
 	}
 
 	// Skip archiving if no cache is defined
-	cacheKey, cacheFile := b.cacheFile(info.Build, options.Key)
-	if cacheKey == "" {
-		return
+	cacheKey, keyArgs, err := b.cacheKeyArguments(info.Build, &options.Key, format)
+	if err != nil {
+		return err
+	} else if cacheKey == "" {
+		return nil
 	}
 
-	args := []string{
-		"cache-extractor",
-		"--file", cacheFile,
+	if ok, err := options.CheckPolicy(cachePolicyPull); err != nil {
+		return fmt.Errorf("%s for %s", err, cacheKey)
+	} else if !ok {
+		w.Notice("Not downloading cache %s due to policy", cacheKey)
+		return nil
 	}
 
-	// Generate cache download address
-	if url := getCacheDownloadURL(info.Build, cacheKey); url != nil {
-		args = append(args, "--url", url.String())
+	args := append([]string{"cache-extractor"}, keyArgs...)
+	args = append(args, retryArguments(info.Build)...)
+
+	// The helper generates the remote address of the key it computes
+	cacheKeys := []string{cacheKey}
+	var urlKeys []string
+	if !options.Key.IsComputed() {
+		urlKeys = append(urlKeys, cacheKey)
+	} else {
+		adapterArgs, err := writeCacheAdapterArguments(w, info.Build)
+		if err != nil {
+			return err
+		}
+		args = append(args, adapterArgs...)
+
+		if format != archives.ZipArchive {
+			args = append(args, "--format", string(format))
+		}
+	}
+	for _, fallbackKey := range b.cacheFallbackKeys(info.Build, options) {
+		fallbackKey, fallbackFile := b.cacheFile(info.Build, fallbackKey, format)
+		if fallbackKey == "" || fallbackKey == cacheKey {
+			continue
+		}
+		cacheKeys = append(cacheKeys, fallbackKey)
+		urlKeys = append(urlKeys, fallbackKey)
+		args = append(args, "--file", fallbackFile)
+	}
+
+	// Generate cache download addresses, each file needs a matching one
+	var urls []string
+	var hasURL bool
+	for _, key := range urlKeys {
+		if url := getCacheDownloadURL(info.Build, key); url != nil {
+			urls = append(urls, url.String())
+			hasURL = true
+		} else {
+			urls = append(urls, "")
+		}
+	}
+	if hasURL {
+		for _, url := range urls {
+			args = append(args, "--url", url)
+		}
+		writeCacheEncryptionKey(w, info.Build)
 	}
 
 	// Execute archive command
 	b.guardRunnerCommand(w, info.RunnerCommand, "Extracting cache", func() {
-		w.Notice("Checking cache for %s...", cacheKey)
+		if len(cacheKeys) > 1 {
+			w.Notice("Checking cache for %s (fallback keys: %s)...", cacheKey, strings.Join(cacheKeys[1:], ", "))
+		} else {
+			w.Notice("Checking cache for %s...", cacheKey)
+		}
 		w.Command(info.RunnerCommand, args...)
 	})
-}
-
-func (b *AbstractShell) downloadArtifacts(w ShellWriter, build *common.BuildInfo, info common.ShellScriptInfo) {
-	args := []string{
-		"artifacts-downloader",
-		"--url",
-		info.Build.Runner.URL,
-		"--token",
-		build.Token,
-		"--id",
-		strconv.Itoa(build.ID),
-	}
-
-	w.Notice("Downloading artifacts for %s (%d)...", build.Name, build.ID)
-	w.Command(info.RunnerCommand, args...)
+	return nil
 }
 
 func (b *AbstractShell) buildArtifacts(dependencies *dependencies, info common.ShellScriptInfo) (otherBuilds []common.BuildInfo) {
@@ -207,10 +532,26 @@ func (b *AbstractShell) downloadAllArtifacts(w ShellWriter, dependencies *depend
 		return
 	}
 
-	b.guardRunnerCommand(w, info.RunnerCommand, "Artifacts downloading", func() {
-		for _, otherBuild := range otherBuilds {
-			b.downloadArtifacts(w, &otherBuild, info)
+	// The helper downloads the artifacts in parallel, and extracts them in this order
+	args := []string{
+		"artifacts-downloader",
+		"--url",
+		info.Build.Runner.URL,
+	}
+	args = append(args, retryArguments(info.Build)...)
+
+	var names []string
+	for _, otherBuild := range otherBuilds {
+		args = append(args, "--dependency", fmt.Sprintf("%d:%s:%s", otherBuild.ID, otherBuild.Token, otherBuild.Name))
+		for _, path := range dependencies.Paths(otherBuild.Name) {
+			args = append(args, "--dependency-path", fmt.Sprintf("%d:%s", otherBuild.ID, path))
 		}
+		names = append(names, fmt.Sprintf("%s (%d)", otherBuild.Name, otherBuild.ID))
+	}
+
+	b.guardRunnerCommand(w, info.RunnerCommand, "Artifacts downloading", func() {
+		w.Notice("Downloading artifacts for %s...", strings.Join(names, ", "))
+		w.Command(info.RunnerCommand, args...)
 	})
 }
 
@@ -222,21 +563,48 @@ func (b *AbstractShell) writePrepareScript(w ShellWriter, info common.ShellScrip
 	gitDir := path.Join(build.FullProjectDir(), ".git")
 
 	b.writeTLSCAInfo(w, info.Build, "GIT_SSL_CAINFO")
-	b.writeTLSCAInfo(w, info.Build, "CI_SERVER_TLS_CA_FILE")
+	b.writeTLSClientInfo(w, info.Build, "GIT_SSL_CERT", "GIT_SSL_KEY")
+	b.writeProxyInfo(w, info.Build, "http_proxy", "https_proxy")
+	b.writeServerInfo(w, info.Build)
+
+	// LFS objects are fetched after checkout, directly into the cached storage
+	gitLFSSkipSmudge := common.BuildVariable{
+		Key:      "GIT_LFS_SKIP_SMUDGE",
+		Value:    "1",
+		Internal: true,
+	}
 
-	w.Command("git", "config", "--global", "fetch.recurseSubmodules", "false")
 	switch info.Build.GetGitStrategy() {
 	case common.GitFetch:
-		b.writeFetchCmd(w, build, projectDir, gitDir)
+		w.Command("git", "config", "--global", "fetch.recurseSubmodules", "false")
+		w.Variable(gitLFSSkipSmudge)
+		mirrorDir := b.writeGitMirrorCmd(w, info)
+		b.writeFetchCmd(w, build, projectDir, gitDir, mirrorDir)
+		b.writeCheckoutCmd(w, build)
+		b.writeGitLFSCmd(w, build)
 
 	case common.GitClone:
-		b.writeCloneCmd(w, build, projectDir)
+		w.Command("git", "config", "--global", "fetch.recurseSubmodules", "false")
+		w.Variable(gitLFSSkipSmudge)
+		mirrorDir := b.writeGitMirrorCmd(w, info)
+		b.writeCloneCmd(w, build, projectDir, mirrorDir)
+		b.writeCheckoutCmd(w, build)
+		b.writeGitLFSCmd(w, build)
+
+	case common.GitNone:
+		// The repository is not needed, but cache and artifacts still are
+		w.Notice("Skipping Git repository setup")
+		w.MkDir(projectDir)
+		w.Cd(projectDir)
 
 	default:
 		return errors.New("unknown GIT_STRATEGY")
 	}
 
-	b.writeCheckoutCmd(w, build)
+	err = b.writeSubmoduleUpdateCmds(w, build)
+	if err != nil {
+		return
+	}
 
 	// Parse options
 	var options shellOptions
@@ -245,61 +613,120 @@ func (b *AbstractShell) writePrepareScript(w ShellWriter, info common.ShellScrip
 		return
 	}
 
+	err = b.validateCacheKeys(build, options.Cache)
+	if err != nil {
+		return
+	}
+
 	// Try to restore from main cache, if not found cache for master
-	b.cacheExtractor(w, options.Cache, info)
+	for _, cache := range options.Cache {
+		err = b.cacheExtractor(w, &cache, info)
+		if err != nil {
+			return
+		}
+	}
 
 	// Process all artifacts
 	b.downloadAllArtifacts(w, options.Dependencies, info)
 	return nil
 }
 
-func (b *AbstractShell) writeBuildScript(w ShellWriter, info common.ShellScriptInfo) (err error) {
-	b.writeExports(w, info)
-	b.writeCdBuildDir(w, info)
-
-	commands := info.Build.Commands
-	commands = strings.TrimSpace(commands)
-	for _, command := range strings.Split(commands, "\n") {
+func (b *AbstractShell) writeCommands(w ShellWriter, commands ...string) {
+	for _, command := range commands {
 		command = strings.TrimSpace(command)
 		if command != "" {
 			w.Notice("$ %s", command)
 		} else {
 			w.EmptyLine()
 		}
+
+		if mw, ok := w.(multiLineCommandWriter); ok && strings.Contains(command, "\n") {
+			mw.MultiLineCommand(command)
+			continue
+		}
 		w.Line(command)
 		w.CheckForErrors()
 	}
+}
+
+func (b *AbstractShell) writeBuildScript(w ShellWriter, info common.ShellScriptInfo) (err error) {
+	shellOptions := struct {
+		BeforeScript []string `json:"before_script"`
+		Script       []string `json:"script"`
+	}{}
+	err = info.Build.Options.Decode(&shellOptions)
+	if err != nil {
+		return err
+	}
+
+	b.writeExports(w, info)
+	b.writeCdBuildDir(w, info)
+
+	// Each script entry is a single command, even if it spans multiple lines
+	commands := append(shellOptions.BeforeScript, shellOptions.Script...)
+	if len(shellOptions.Script) == 0 {
+		// Fallback to commands where each line is a separate command
+		commands = strings.Split(strings.TrimSpace(info.Build.Commands), "\n")
+	}
+	b.writeCommands(w, commands...)
 
 	return nil
 }
 
-func (b *AbstractShell) cacheArchiver(w ShellWriter, options *archivingOptions, info common.ShellScriptInfo) {
+func (b *AbstractShell) cacheArchiver(w ShellWriter, options *archivingOptions, info common.ShellScriptInfo) error {
 	if options == nil {
-		return
+		return nil
 	}
 
-	// Skip archiving if no cache is defined
-	cacheKey, cacheFile := b.cacheFile(info.Build, options.Key)
-	if cacheKey == "" {
-		return
+	format, formatArgs, err := getCacheArchiveArguments(info.Build)
+	if err != nil {
+		return err
 	}
 
-	args := []string{
-		"cache-archiver",
-		"--file", cacheFile,
+	// Skip archiving if no cache is defined
+	cacheKey, keyArgs, err := b.cacheKeyArguments(info.Build, &options.Key, format)
+	if err != nil {
+		return err
+	} else if cacheKey == "" {
+		return nil
 	}
 
+	args := append([]string{"cache-archiver"}, keyArgs...)
+	args = append(args, formatArgs...)
+	args = append(args, retryArguments(info.Build)...)
+
 	// Create list of files to archive
 	archiverArgs := options.CommandArguments()
 	if len(archiverArgs) == 0 {
 		// Skip creating archive
-		return
+		return nil
 	}
 	args = append(args, archiverArgs...)
 
-	// Generate cache upload address
-	if url := getCacheUploadURL(info.Build, cacheKey); url != nil {
-		args = append(args, "--url", url.String())
+	if ok, err := options.CheckPolicy(cachePolicyPush); err != nil {
+		return fmt.Errorf("%s for %s", err, cacheKey)
+	} else if !ok {
+		w.Notice("Not uploading cache %s due to policy", cacheKey)
+		return nil
+	}
+
+	// Generate cache upload address, the helper generates it for the key it computes
+	if options.Key.IsComputed() {
+		adapterArgs, err := writeCacheAdapterArguments(w, info.Build)
+		if err != nil {
+			return err
+		}
+		args = append(args, adapterArgs...)
+	} else if adapter := getCacheAdapter(info.Build, cacheKey); adapter != nil {
+		if url := adapter.GetUploadURL(); url != nil {
+			args = append(args, "--url", url.String())
+			writeCacheEncryptionKey(w, info.Build)
+
+			// The archive is uploaded while it's created, when the size doesn't have to be known upfront
+			if isCacheStreamingSupported(adapter) {
+				args = append(args, "--stream")
+			}
+		}
 	}
 
 	b.guardRunnerCommand(w, info.RunnerCommand, "Creating cache", func() {
@@ -307,6 +734,7 @@ func (b *AbstractShell) cacheArchiver(w ShellWriter, options *archivingOptions,
 		w.Notice("Creating cache %s...", cacheKey)
 		w.Command(info.RunnerCommand, args...)
 	})
+	return nil
 }
 
 func (b *AbstractShell) uploadArtifacts(w ShellWriter, options *archivingOptions, info common.ShellScriptInfo) {
@@ -326,6 +754,7 @@ func (b *AbstractShell) uploadArtifacts(w ShellWriter, options *archivingOptions
 		"--id",
 		strconv.Itoa(info.Build.ID),
 	}
+	args = append(args, retryArguments(info.Build)...)
 
 	// Create list of files to archive
 	archiverArgs := options.CommandArguments()
@@ -345,12 +774,32 @@ func (b *AbstractShell) uploadArtifacts(w ShellWriter, options *archivingOptions
 		args = append(args, "--expire-in", expireIn)
 	}
 
+	if level := info.Build.GetAllVariables().Get("ARTIFACT_COMPRESSION_LEVEL"); level != "" {
+		args = append(args, "--compression-level", level)
+	}
+
 	b.guardRunnerCommand(w, info.RunnerCommand, "Uploading artifacts", func() {
 		w.Notice("Uploading artifacts...")
 		w.Command(info.RunnerCommand, args...)
 	})
 }
 
+func (b *AbstractShell) junitReport(w ShellWriter, options *archivingOptions, info common.ShellScriptInfo) {
+	if options == nil || len(options.Reports.JUnit) == 0 {
+		return
+	}
+
+	args := []string{"junit-report"}
+	for _, path := range options.Reports.JUnit {
+		args = append(args, "--path", path)
+	}
+
+	b.guardRunnerCommand(w, info.RunnerCommand, "Parsing JUnit reports", func() {
+		w.Notice("Parsing JUnit reports...")
+		w.Command(info.RunnerCommand, args...)
+	})
+}
+
 func (b *AbstractShell) writeAfterScript(w ShellWriter, info common.ShellScriptInfo) error {
 	shellOptions := struct {
 		AfterScript []string `json:"after_script"`
@@ -368,17 +817,7 @@ func (b *AbstractShell) writeAfterScript(w ShellWriter, info common.ShellScriptI
 	b.writeCdBuildDir(w, info)
 
 	w.Notice("Running after script...")
-
-	for _, command := range shellOptions.AfterScript {
-		command = strings.TrimSpace(command)
-		if command != "" {
-			w.Notice("$ %s", command)
-		} else {
-			w.EmptyLine()
-		}
-		w.Line(command)
-		w.CheckForErrors()
-	}
+	b.writeCommands(w, shellOptions.AfterScript...)
 
 	return nil
 }
@@ -393,10 +832,20 @@ func (b *AbstractShell) writeArchiveCacheScript(w ShellWriter, info common.Shell
 
 	b.writeExports(w, info)
 	b.writeCdBuildDir(w, info)
-	b.writeTLSCAInfo(w, info.Build, "CI_SERVER_TLS_CA_FILE")
+	b.writeServerInfo(w, info.Build)
+
+	err = b.validateCacheKeys(info.Build, options.Cache)
+	if err != nil {
+		return
+	}
 
 	// Find cached files and archive them
-	b.cacheArchiver(w, options.Cache, info)
+	for _, cache := range options.Cache {
+		err = b.cacheArchiver(w, &cache, info)
+		if err != nil {
+			return
+		}
+	}
 	return
 }
 
@@ -410,13 +859,36 @@ func (b *AbstractShell) writeUploadArtifactsScript(w ShellWriter, info common.Sh
 
 	b.writeExports(w, info)
 	b.writeCdBuildDir(w, info)
-	b.writeTLSCAInfo(w, info.Build, "CI_SERVER_TLS_CA_FILE")
+	b.writeServerInfo(w, info.Build)
 
-	// Upload artifacts
+	// Summarize the test reports and upload them with artifacts
+	b.junitReport(w, options.Artifacts, info)
 	b.uploadArtifacts(w, options.Artifacts, info)
 	return
 }
 
+// writeUploadReportsScript is used when the artifacts are not uploaded because of artifacts:when,
+// only the test reports are summarized and uploaded then
+func (b *AbstractShell) writeUploadReportsScript(w ShellWriter, info common.ShellScriptInfo) (err error) {
+	// Parse options
+	var options shellOptions
+	err = info.Build.Options.Decode(&options)
+	if err != nil || options.Artifacts == nil {
+		return
+	}
+
+	b.writeExports(w, info)
+	b.writeCdBuildDir(w, info)
+	b.writeServerInfo(w, info.Build)
+
+	reports := &archivingOptions{
+		Reports: options.Artifacts.Reports,
+	}
+	b.junitReport(w, reports, info)
+	b.uploadArtifacts(w, reports, info)
+	return
+}
+
 func (b *AbstractShell) writeScript(w ShellWriter, scriptType common.ShellScriptType, info common.ShellScriptInfo) (err error) {
 	switch scriptType {
 	case common.ShellPrepareScript:
@@ -434,6 +906,9 @@ func (b *AbstractShell) writeScript(w ShellWriter, scriptType common.ShellScript
 	case common.ShellUploadArtifacts:
 		return b.writeUploadArtifactsScript(w, info)
 
+	case common.ShellUploadReports:
+		return b.writeUploadReportsScript(w, info)
+
 	default:
 		return errors.New("Not supported script type: " + string(scriptType))
 	}
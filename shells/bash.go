@@ -41,6 +41,7 @@ type BashWriter struct {
 	bytes.Buffer
 	TemporaryPath string
 	indent        int
+	files         temporaryFiles
 }
 
 func (b *BashWriter) Line(text string) {
@@ -72,9 +73,13 @@ func (b *BashWriter) Command(command string, arguments ...string) {
 
 func (b *BashWriter) Variable(variable common.BuildVariable) {
 	if variable.File {
-		variableFile := b.Absolute(path.Join(b.TemporaryPath, variable.Key))
-		b.Line(fmt.Sprintf("mkdir -p %q", helpers.ToSlash(b.TemporaryPath)))
-		b.Line(fmt.Sprintf("echo -n %s > %q", helpers.ShellEscape(variable.Value), variableFile))
+		variableFile, written := b.files.find(variable.Value)
+		if !written {
+			variableFile = b.Absolute(path.Join(b.TemporaryPath, variable.Key))
+			b.Line(fmt.Sprintf("mkdir -p %q", helpers.ToSlash(b.TemporaryPath)))
+			b.Line(fmt.Sprintf("echo -n %s > %q", helpers.ShellEscape(variable.Value), variableFile))
+			b.files.add(variable, variableFile)
+		}
 		b.Line(fmt.Sprintf("export %s=%q", helpers.ShellEscape(variable.Key), variableFile))
 	} else {
 		b.Line(fmt.Sprintf("export %s=%s", helpers.ShellEscape(variable.Key), helpers.ShellEscape(variable.Value)))
@@ -111,6 +116,10 @@ func (b *BashWriter) Cd(path string) {
 	b.Command("cd", path)
 }
 
+func (b *BashWriter) MkDir(path string) {
+	b.Command("mkdir", "-p", path)
+}
+
 func (b *BashWriter) RmDir(path string) {
 	b.Command("rm", "-r", "-f", path)
 }
@@ -155,6 +164,14 @@ func (b *BashWriter) Finish() string {
 	w := bufio.NewWriter(&buffer)
 	io.WriteString(w, "set -eo pipefail\n")
 	io.WriteString(w, "set +o noclobber\n")
+	if len(b.files.secrets) > 0 {
+		// the secrets are removed also if the script fails
+		var remove []string
+		for _, file := range b.files.secrets {
+			remove = append(remove, strconv.Quote(file))
+		}
+		io.WriteString(w, "trap "+helpers.ShellEscape("rm -f "+strings.Join(remove, " "))+" EXIT\n")
+	}
 	io.WriteString(w, ": | eval "+helpers.ShellEscape(b.String())+"\n")
 	w.Flush()
 	return buffer.String()
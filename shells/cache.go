@@ -1,121 +1,148 @@
 package shells
 
 import (
-	"bytes"
-	"encoding/xml"
-	"io/ioutil"
-	"net/http"
+	"encoding/json"
+	"fmt"
 	"net/url"
 	"path"
-	"strconv"
 	"time"
 
-	"github.com/minio/minio-go"
-
 	"github.com/Sirupsen/logrus"
 	"gitlab.com/gitlab-org/gitlab-ci-multi-runner/common"
+	"gitlab.com/gitlab-org/gitlab-ci-multi-runner/helpers/archives"
 )
 
-type bucketLocationTripper struct {
-	bucketLocation string
-}
-
-func (b *bucketLocationTripper) RoundTrip(req *http.Request) (res *http.Response, err error) {
-	var buffer bytes.Buffer
-	xml.NewEncoder(&buffer).Encode(b.bucketLocation)
-	res = &http.Response{
-		StatusCode: http.StatusOK,
-		Body:       ioutil.NopCloser(&buffer),
+func getCacheObjectName(build *common.Build, cache *common.CacheConfig, key string) string {
+	if key == "" {
+		return ""
 	}
-	return
-}
 
-func (b *bucketLocationTripper) CancelRequest(req *http.Request) {
-	// Do nothing
+	return path.Join(common.GetCacheProjectPath(build, cache), key)
 }
 
-func getCacheObjectName(build *common.Build, cache *common.CacheConfig, key string) string {
-	if key == "" {
+// getCacheKeySuffix separates the caches of protected refs, so they can't be poisoned by other refs
+func getCacheKeySuffix(build *common.Build) string {
+	cache := build.Runner.Cache
+	if cache == nil || !cache.SeparateProtected {
 		return ""
+	} else if build.Protected {
+		return "-protected"
 	}
-	return path.Join("runner", build.Runner.ShortDescription(), "project", strconv.Itoa(build.ProjectID), key)
+	return "-non_protected"
 }
 
-func getCacheStorageClient(cache *common.CacheConfig) (scl *minio.Client, err error) {
-	scl, err = minio.New(cache.ServerAddress, cache.AccessKey, cache.SecretKey, cache.Insecure)
+// getCacheArchiveArguments returns the format of the cache archive and the helper arguments selecting it,
+// the defaults are not passed, so the scripts work with older helpers
+func getCacheArchiveArguments(build *common.Build) (format archives.ArchiveFormat, args []string, err error) {
+	variables := build.GetAllVariables()
+
+	format, err = archives.ParseArchiveFormat(variables.Get("CACHE_ARCHIVE_FORMAT"))
 	if err != nil {
-		logrus.Warningln(err)
-		return
+		return "", nil, err
+	} else if format != archives.ZipArchive {
+		args = append(args, "--format", string(format))
 	}
 
-	scl.SetCustomTransport(&bucketLocationTripper{cache.BucketLocation})
+	if level := variables.Get("CACHE_COMPRESSION_LEVEL"); level != "" {
+		if _, err = archives.ParseCompressionLevel(level); err != nil {
+			return "", nil, err
+		}
+		args = append(args, "--compression-level", level)
+	}
 	return
 }
 
-func getS3DownloadURL(build *common.Build, key string) (url *url.URL) {
+func isCacheStreamingSupported(adapter common.CacheAdapter) bool {
+	streaming, ok := adapter.(common.StreamingCacheAdapter)
+	return ok && streaming.IsStreamingSupported()
+}
+
+func isDistributedCache(build *common.Build) bool {
 	cache := build.Runner.Cache
-	objectName := getCacheObjectName(build, cache, key)
-	if objectName == "" {
-		return
-	}
+	return cache != nil && cache.Type != ""
+}
 
-	scl, err := getCacheStorageClient(cache)
-	if err != nil {
-		logrus.Warningln(err)
+// writeCacheEncryptionKey passes the key in the environment, so it's not visible in the helper arguments
+func writeCacheEncryptionKey(w ShellWriter, build *common.Build) {
+	cache := build.Runner.Cache
+	if !isDistributedCache(build) || cache.EncryptionKey == "" {
 		return
 	}
 
-	url, err = scl.PresignedGetObject(cache.BucketName, objectName, time.Second*time.Duration(build.Timeout), nil)
-	if err != nil {
-		logrus.Warningln(err)
-		return
-	}
-	return
+	w.Variable(common.BuildVariable{
+		Key:      "CACHE_ENCRYPTION_KEY",
+		Value:    cache.EncryptionKey,
+		Internal: true,
+	})
 }
 
-func getCacheDownloadURL(build *common.Build, key string) (url *url.URL) {
-	cache := build.Runner.Cache
-	if cache == nil {
-		return
+// writeCacheAdapterArguments passes the storage to the helper, so it can generate the addresses of the key it computes.
+// The configuration is passed in the environment, like the encryption key.
+func writeCacheAdapterArguments(w ShellWriter, build *common.Build) ([]string, error) {
+	if !isDistributedCache(build) {
+		return nil, nil
 	}
 
-	switch cache.Type {
-	case "s3":
-		return getS3DownloadURL(build, key)
+	cache := build.Runner.Cache
+	config, err := json.Marshal(common.CacheConfig{
+		Type:           cache.Type,
+		ServerAddress:  cache.ServerAddress,
+		AccessKey:      cache.AccessKey,
+		SecretKey:      cache.SecretKey,
+		BucketName:     cache.BucketName,
+		BucketLocation: cache.BucketLocation,
+		Insecure:       cache.Insecure,
+		Secret:         cache.Secret,
+		Path:           cache.Path,
+	})
+	if err != nil {
+		return nil, err
 	}
-	return
+
+	w.Variable(common.BuildVariable{
+		Key:      "CACHE_CONFIG",
+		Value:    string(config),
+		Internal: true,
+	})
+	writeCacheEncryptionKey(w, build)
+
+	return []string{
+		"--cache-object-prefix", common.GetCacheProjectPath(build, cache),
+		"--cache-url-timeout", fmt.Sprintf("%ds", build.Timeout),
+	}, nil
 }
 
-func getS3UploadURL(build *common.Build, key string) (url *url.URL) {
+func getCacheAdapter(build *common.Build, key string) common.CacheAdapter {
+	if !isDistributedCache(build) {
+		return nil
+	}
+
 	cache := build.Runner.Cache
 	objectName := getCacheObjectName(build, cache, key)
 	if objectName == "" {
-		return
-	}
-
-	scl, err := getCacheStorageClient(cache)
-	if err != nil {
-		logrus.Warningln(err)
-		return
+		return nil
 	}
 
-	url, err = scl.PresignedPutObject(cache.BucketName, objectName, time.Second*time.Duration(build.Timeout))
+	adapter, err := common.CreateCacheAdapter(cache, time.Second*time.Duration(build.Timeout), objectName)
 	if err != nil {
 		logrus.Warningln(err)
-		return
+		return nil
 	}
-	return
+	return adapter
 }
 
-func getCacheUploadURL(build *common.Build, key string) (url *url.URL) {
-	cache := build.Runner.Cache
-	if cache == nil {
-		return
+func getCacheDownloadURL(build *common.Build, key string) *url.URL {
+	adapter := getCacheAdapter(build, key)
+	if adapter == nil {
+		return nil
 	}
+	return adapter.GetDownloadURL()
+}
 
-	switch cache.Type {
-	case "s3":
-		return getS3UploadURL(build, key)
+func getCacheUploadURL(build *common.Build, key string) *url.URL {
+	adapter := getCacheAdapter(build, key)
+	if adapter == nil {
+		return nil
 	}
-	return
+	return adapter.GetUploadURL()
 }
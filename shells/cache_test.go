@@ -6,7 +6,9 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	_ "gitlab.com/gitlab-org/gitlab-ci-multi-runner/cache/s3"
 	"gitlab.com/gitlab-org/gitlab-ci-multi-runner/common"
+	"gitlab.com/gitlab-org/gitlab-ci-multi-runner/helpers/archives"
 )
 
 var s3Cache = common.CacheConfig{
@@ -44,3 +46,161 @@ func TestS3CacheDownloadURL(t *testing.T) {
 	require.NotNil(t, url)
 	assert.Equal(t, s3Cache.ServerAddress, url.Host)
 }
+
+func TestCacheObjectNameScope(t *testing.T) {
+	tests := []struct {
+		shared     bool
+		namespace  string
+		objectName string
+	}{
+		{false, "", "runner/longtoke/project/10/key"},
+		{false, "fleet", "runner/longtoke/project/10/key"},
+		{true, "", "project/10/key"},
+		{true, "fleet", "namespace/fleet/project/10/key"},
+	}
+
+	for _, test := range tests {
+		cache := &common.CacheConfig{Shared: test.shared, Namespace: test.namespace}
+		assert.Equal(t, test.objectName, getCacheObjectName(s3CacheBuild, cache, "key"))
+	}
+}
+
+func TestCacheKeySeparateProtected(t *testing.T) {
+	build := &common.Build{
+		GetBuildResponse: common.GetBuildResponse{
+			Name:    "test",
+			RefName: "master",
+		},
+		CacheDir: "/cache/project",
+		BuildDir: "/builds/project",
+		Runner: &common.RunnerConfig{
+			RunnerSettings: common.RunnerSettings{
+				Cache: &common.CacheConfig{SeparateProtected: true},
+			},
+		},
+	}
+
+	shell := AbstractShell{}
+	key, _ := shell.cacheFile(build, "key", archives.ZipArchive)
+	assert.Equal(t, "key-non_protected", key)
+
+	build.Protected = true
+	key, file := shell.cacheFile(build, "key", archives.ZipArchive)
+	assert.Equal(t, "key-protected", key)
+	assert.Equal(t, "../../cache/project/key-protected/cache.zip", file)
+
+	build.Runner.Cache.SeparateProtected = false
+	key, _ = shell.cacheFile(build, "key", archives.ZipArchive)
+	assert.Equal(t, "key", key)
+}
+
+func TestCacheArchiveArguments(t *testing.T) {
+	build := &common.Build{
+		Runner: &common.RunnerConfig{},
+	}
+
+	format, args, err := getCacheArchiveArguments(build)
+	assert.NoError(t, err)
+	assert.Equal(t, archives.ZipArchive, format)
+	assert.Empty(t, args)
+
+	build.Variables = common.BuildVariables{
+		{Key: "CACHE_ARCHIVE_FORMAT", Value: "tar.gz"},
+		{Key: "CACHE_COMPRESSION_LEVEL", Value: "fastest"},
+	}
+	format, args, err = getCacheArchiveArguments(build)
+	assert.NoError(t, err)
+	assert.Equal(t, archives.TarGzipArchive, format)
+	assert.Equal(t, []string{"--format", "tar.gz", "--compression-level", "fastest"}, args)
+
+	build.Variables = common.BuildVariables{
+		{Key: "CACHE_ARCHIVE_FORMAT", Value: "tar.zst"},
+	}
+	_, _, err = getCacheArchiveArguments(build)
+	assert.Error(t, err)
+}
+
+func TestComputedCacheKeyUsesDistributedCache(t *testing.T) {
+	build := &common.Build{
+		GetBuildResponse: common.GetBuildResponse{
+			ProjectID: 10,
+			Timeout:   3600,
+		},
+		CacheDir: "/cache/project",
+		BuildDir: "/builds/project",
+		Runner:   s3CacheBuild.Runner,
+	}
+	options := &archivingOptions{
+		Paths: []string{"vendor/"},
+		Key:   cacheKey{Files: []string{"Gemfile.lock"}},
+	}
+	info := common.ShellScriptInfo{Build: build, RunnerCommand: "gitlab-runner"}
+
+	shell := AbstractShell{}
+	for _, write := range []func(w ShellWriter) error{
+		func(w ShellWriter) error { return shell.cacheExtractor(w, options, info) },
+		func(w ShellWriter) error { return shell.cacheArchiver(w, options, info) },
+	} {
+		w := &BashWriter{}
+		require.NoError(t, write(w))
+
+		script := w.String()
+		assert.Contains(t, script, `"--key-file" "Gemfile.lock"`)
+		assert.Contains(t, script, `"--cache-object-prefix" "runner/longtoke/project/10" "--cache-url-timeout" "3600s"`)
+		assert.Contains(t, script, "export CACHE_CONFIG=")
+		assert.NotContains(t, script, `"--url"`, "the helper generates the address of the computed key")
+		assert.NotContains(t, script, "not supported")
+	}
+}
+
+func TestMultipleCachesNeedUniqueKeys(t *testing.T) {
+	build := &common.Build{
+		GetBuildResponse: common.GetBuildResponse{
+			Variables: common.BuildVariables{{Key: "CI_BUILD_REF_NAME", Value: "master"}},
+		},
+		Runner: &common.RunnerConfig{},
+	}
+
+	tests := []struct {
+		caches caches
+		valid  bool
+	}{
+		{caches{{}}, true},
+		{caches{{Key: cacheKey{Value: "gems"}}, {Key: cacheKey{Value: "node"}}}, true},
+		{caches{{Key: cacheKey{Files: []string{"Gemfile.lock"}}}, {Key: cacheKey{Files: []string{"yarn.lock"}}}}, true},
+		{caches{{Key: cacheKey{Value: "gems"}}, {}}, false},
+		{caches{{Key: cacheKey{Value: "gems"}}, {Key: cacheKey{Value: "gems"}}}, false},
+		{caches{{Key: cacheKey{Value: "$CI_BUILD_REF_NAME"}}, {Key: cacheKey{Value: "master"}}}, false},
+		{caches{{Key: cacheKey{Files: []string{"Gemfile.lock"}}}, {Key: cacheKey{Files: []string{"Gemfile.lock"}}}}, false},
+	}
+
+	shell := AbstractShell{}
+	for i, test := range tests {
+		err := shell.validateCacheKeys(build, test.caches)
+		if test.valid {
+			assert.NoError(t, err, "test %d", i)
+		} else {
+			assert.Error(t, err, "test %d", i)
+		}
+	}
+}
+
+func TestArchiveCacheScriptRejectsCachesWithoutKeys(t *testing.T) {
+	build := &common.Build{
+		GetBuildResponse: common.GetBuildResponse{
+			Options: common.BuildOptions{
+				"cache": []interface{}{
+					map[string]interface{}{"paths": []interface{}{"vendor"}},
+					map[string]interface{}{"paths": []interface{}{"node_modules"}},
+				},
+			},
+		},
+		CacheDir: "/cache/project",
+		BuildDir: "/builds/project",
+		Runner:   &common.RunnerConfig{},
+	}
+
+	shell := AbstractShell{}
+	err := shell.writeArchiveCacheScript(&BashWriter{}, common.ShellScriptInfo{Build: build})
+	assert.Error(t, err)
+}
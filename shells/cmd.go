@@ -19,6 +19,7 @@ type CmdWriter struct {
 	bytes.Buffer
 	TemporaryPath string
 	indent        int
+	files         temporaryFiles
 }
 
 func batchQuote(text string) string {
@@ -56,6 +57,24 @@ func (b *CmdWriter) CheckForErrors() {
 	b.checkErrorLevel()
 }
 
+// MultiLineCommand checks the errorlevel after every line, otherwise cmd ignores the failures
+// of all but the last line. The delayed expansion makes the checks work also within ( ) blocks,
+// the lines continued with ^ are checked after the line ending them.
+func (b *CmdWriter) MultiLineCommand(command string) {
+	lines := strings.Split(command, "\n")
+	for i, line := range lines {
+		line = strings.TrimRight(line, "\r")
+		b.Line(line)
+
+		trimmed := strings.TrimSpace(line)
+		if i == len(lines)-1 {
+			b.checkErrorLevel()
+		} else if trimmed != "" && !strings.HasSuffix(trimmed, "^") {
+			b.Line("IF !errorlevel! NEQ 0 exit /b !errorlevel!")
+		}
+	}
+}
+
 func (b *CmdWriter) Indent() {
 	b.indent++
 }
@@ -84,10 +103,14 @@ func (b *CmdWriter) Command(command string, arguments ...string) {
 
 func (b *CmdWriter) Variable(variable common.BuildVariable) {
 	if variable.File {
-		variableFile := b.Absolute(path.Join(b.TemporaryPath, variable.Key))
-		variableFile = helpers.ToBackslash(variableFile)
-		b.Line(fmt.Sprintf("md %q 2>NUL 1>NUL", batchEscape(helpers.ToBackslash(b.TemporaryPath))))
-		b.Line(fmt.Sprintf("echo %s > %s", batchEscapeVariable(variable.Value), batchEscape(variableFile)))
+		variableFile, written := b.files.find(variable.Value)
+		if !written {
+			variableFile = b.Absolute(path.Join(b.TemporaryPath, variable.Key))
+			variableFile = helpers.ToBackslash(variableFile)
+			b.Line(fmt.Sprintf("md %q 2>NUL 1>NUL", batchEscape(helpers.ToBackslash(b.TemporaryPath))))
+			b.Line(fmt.Sprintf("echo %s > %s", batchEscapeVariable(variable.Value), batchEscape(variableFile)))
+			b.files.add(variable, variableFile)
+		}
 		b.Line("SET " + batchEscapeVariable(variable.Key) + "=" + batchEscape(variableFile))
 	} else {
 		b.Line("SET " + batchEscapeVariable(variable.Key) + "=" + batchEscapeVariable(variable.Value))
@@ -126,6 +149,10 @@ func (b *CmdWriter) Cd(path string) {
 	b.checkErrorLevel()
 }
 
+func (b *CmdWriter) MkDir(path string) {
+	b.Line("md " + batchQuote(helpers.ToBackslash(path)) + " 2>NUL 1>NUL")
+}
+
 func (b *CmdWriter) RmDir(path string) {
 	b.Line("rd /s /q " + batchQuote(helpers.ToBackslash(path)) + " 2>NUL 1>NUL")
 }
@@ -193,10 +220,31 @@ func (b *CmdShell) GenerateScript(scriptType common.ShellScriptType, info common
 	}
 
 	err = b.writeScript(w, scriptType, info)
-	script = w.String()
+	script = w.Finish()
 	return
 }
 
+// Finish returns the script, with the secret files it's called as a subroutine:
+// the failed commands exit only the subroutine, so the secrets are removed also if the script fails
+func (b *CmdWriter) Finish() string {
+	if len(b.files.secrets) == 0 {
+		return b.String()
+	}
+
+	w := &CmdWriter{}
+	w.Line("@echo off")
+	w.Line("call :script")
+	w.Line("set GITLAB_RUNNER_EXIT_CODE=%errorlevel%")
+	for _, file := range b.files.secrets {
+		w.Line("del /f /q " + batchQuote(file) + " 2>NUL 1>NUL")
+	}
+	w.Line("exit /b %GITLAB_RUNNER_EXIT_CODE%")
+	w.Line("")
+	w.Line(":script")
+	w.Write(b.Bytes())
+	return w.String()
+}
+
 func (b *CmdShell) IsDefault() bool {
 	return runtime.GOOS == "windows"
 }
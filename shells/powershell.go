@@ -18,6 +18,7 @@ type PsWriter struct {
 	bytes.Buffer
 	TemporaryPath string
 	indent        int
+	files         temporaryFiles
 }
 
 func psQuote(text string) string {
@@ -79,10 +80,14 @@ func (b *PsWriter) Command(command string, arguments ...string) {
 
 func (b *PsWriter) Variable(variable common.BuildVariable) {
 	if variable.File {
-		variableFile := b.Absolute(path.Join(b.TemporaryPath, variable.Key))
-		variableFile = helpers.ToBackslash(variableFile)
-		b.Line(fmt.Sprintf("md %s -Force | out-null", psQuote(helpers.ToBackslash(b.TemporaryPath))))
-		b.Line(fmt.Sprintf("Set-Content %s -Value %s -Encoding UTF8 -Force", psQuote(variableFile), psQuoteVariable(variable.Value)))
+		variableFile, written := b.files.find(variable.Value)
+		if !written {
+			variableFile = b.Absolute(path.Join(b.TemporaryPath, variable.Key))
+			variableFile = helpers.ToBackslash(variableFile)
+			b.Line(fmt.Sprintf("md %s -Force | out-null", psQuote(helpers.ToBackslash(b.TemporaryPath))))
+			b.Line(fmt.Sprintf("Set-Content %s -Value %s -Encoding UTF8 -Force", psQuote(variableFile), psQuoteVariable(variable.Value)))
+			b.files.add(variable, variableFile)
+		}
 		b.Line("$" + variable.Key + "=" + psQuote(variableFile))
 	} else {
 		b.Line("$" + variable.Key + "=" + psQuoteVariable(variable.Value))
@@ -123,6 +128,10 @@ func (b *PsWriter) Cd(path string) {
 	b.checkErrorLevel()
 }
 
+func (b *PsWriter) MkDir(path string) {
+	b.Line("New-Item -ItemType directory -Force -Path " + psQuote(helpers.ToBackslash(path)) + " | out-null")
+}
+
 func (b *PsWriter) RmDir(path string) {
 	path = psQuote(helpers.ToBackslash(path))
 	b.Line("if( (Get-Command -Name Remove-Item2 -Module NTFSSecurity -ErrorAction SilentlyContinue) -and (Test-Path " + path + " -PathType Container) ) {")
@@ -212,10 +221,30 @@ func (b *PowerShell) GenerateScript(scriptType common.ShellScriptType, info comm
 	}
 
 	err = b.writeScript(w, scriptType, info)
-	script = w.String()
+	script = w.Finish()
 	return
 }
 
+// Finish returns the script, with the secret files it's wrapped in try/finally:
+// the finally block is run also when a failed command exits the script, so the secrets are always removed
+func (b *PsWriter) Finish() string {
+	if len(b.files.secrets) == 0 {
+		return b.String()
+	}
+
+	w := &PsWriter{}
+	w.Line("try {")
+	w.Write(b.Bytes())
+	w.Line("} finally {")
+	w.Indent()
+	for _, file := range b.files.secrets {
+		w.Line("Remove-Item -Force -ErrorAction SilentlyContinue " + psQuote(file))
+	}
+	w.Unindent()
+	w.Line("}")
+	return w.String()
+}
+
 func (b *PowerShell) IsDefault() bool {
 	return false
 }
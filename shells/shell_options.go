@@ -1,28 +1,174 @@
 package shells
 
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+type cachePolicy string
+
+const (
+	cachePolicyUndefined cachePolicy = ""
+	cachePolicyPullPush  cachePolicy = "pull-push"
+	cachePolicyPull      cachePolicy = "pull"
+	cachePolicyPush      cachePolicy = "push"
+)
+
+// Cache key can be computed only from that many files
+const maxCacheKeyFiles = 2
+
+type cacheKey struct {
+	Value  string
+	Files  []string
+	Prefix string
+}
+
+// UnmarshalJSON accepts a key given as a string or as a list of files to compute it from
+func (k *cacheKey) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &k.Value); err == nil {
+		return nil
+	}
+
+	var computed struct {
+		Files  []string `json:"files"`
+		Prefix string   `json:"prefix"`
+	}
+	if err := json.Unmarshal(data, &computed); err != nil {
+		return err
+	}
+
+	// Otherwise the default key would be silently used instead
+	if len(computed.Files) == 0 {
+		return errors.New("cache key needs at least one file to be computed from")
+	}
+
+	k.Files = computed.Files
+	k.Prefix = computed.Prefix
+	return nil
+}
+
+func (k *cacheKey) IsComputed() bool {
+	return len(k.Files) > 0
+}
+
 type archivingOptions struct {
-	Untracked bool     `json:"untracked"`
-	Paths     []string `json:"paths"`
-	Name      string   `json:"name"`
-	Key       string   `json:"key"`
+	Untracked bool        `json:"untracked"`
+	Paths     []string    `json:"paths"`
+	Exclude   []string    `json:"exclude"`
+	Name      string      `json:"name"`
+	Key       cacheKey    `json:"key"`
+	Policy    cachePolicy `json:"policy"`
+	Reports   reports     `json:"reports"`
+
+	FallbackKeys []string `json:"fallback_keys"`
+}
+
+// reportPaths accepts a single path or a list of them
+type reportPaths []string
+
+func (p *reportPaths) UnmarshalJSON(data []byte) error {
+	var list []string
+	if err := json.Unmarshal(data, &list); err == nil {
+		*p = list
+		return nil
+	}
+
+	var single string
+	if err := json.Unmarshal(data, &single); err != nil {
+		return err
+	}
+	*p = reportPaths{single}
+	return nil
+}
+
+type reports struct {
+	JUnit reportPaths `json:"junit"`
 }
 
-type dependencies []string
+// CheckPolicy returns true if the cache policy allows the wanted operation
+func (o *archivingOptions) CheckPolicy(wanted cachePolicy) (bool, error) {
+	switch o.Policy {
+	case cachePolicyUndefined, cachePolicyPullPush:
+		return true, nil
+	case cachePolicyPull, cachePolicyPush:
+		return wanted == o.Policy, nil
+	}
+
+	return false, fmt.Errorf("unknown cache policy %q", o.Policy)
+}
+
+type caches []archivingOptions
+
+// UnmarshalJSON accepts a single cache definition or a list of them
+func (c *caches) UnmarshalJSON(data []byte) error {
+	var list []archivingOptions
+	if err := json.Unmarshal(data, &list); err == nil {
+		*c = list
+		return nil
+	}
+
+	var single archivingOptions
+	if err := json.Unmarshal(data, &single); err != nil {
+		return err
+	}
+	*c = caches{single}
+	return nil
+}
+
+// dependency is given by the build name, optionally with the paths extracted from its artifacts
+type dependency struct {
+	Name  string
+	Paths []string
+}
+
+// UnmarshalJSON accepts a dependency given as a name or as a name with the paths
+func (d *dependency) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &d.Name); err == nil {
+		return nil
+	}
+
+	var selective struct {
+		Name  string   `json:"name"`
+		Paths []string `json:"paths"`
+	}
+	if err := json.Unmarshal(data, &selective); err != nil {
+		return err
+	}
+
+	d.Name = selective.Name
+	d.Paths = selective.Paths
+	return nil
+}
+
+type dependencies []dependency
+
+func (m *dependencies) find(name string) *dependency {
+	for idx := range *m {
+		if (*m)[idx].Name == name {
+			return &(*m)[idx]
+		}
+	}
+	return nil
+}
 
 func (m *dependencies) IsDependent(name string) bool {
+	return m == nil || m.find(name) != nil
+}
+
+// Paths returns the paths extracted from the artifacts of the build, all files are extracted if empty
+func (m *dependencies) Paths(name string) []string {
 	if m == nil {
-		return true
+		return nil
 	}
-	for _, other := range *m {
-		if other == name {
-			return true
-		}
+	if dependency := m.find(name); dependency != nil {
+		return dependency.Paths
 	}
-	return false
+	return nil
 }
 
 type shellOptions struct {
 	Dependencies *dependencies     `json:"dependencies"`
-	Cache        *archivingOptions `json:"cache"`
+	Cache        caches            `json:"cache"`
 	Artifacts    *archivingOptions `json:"artifacts"`
 }
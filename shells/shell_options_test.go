@@ -0,0 +1,163 @@
+package shells
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/gitlab-org/gitlab-ci-multi-runner/common"
+)
+
+func TestCachePolicy(t *testing.T) {
+	tests := []struct {
+		policy   cachePolicy
+		pull     bool
+		push     bool
+		hasError bool
+	}{
+		{cachePolicyUndefined, true, true, false},
+		{cachePolicyPullPush, true, true, false},
+		{cachePolicyPull, true, false, false},
+		{cachePolicyPush, false, true, false},
+		{"unknown", false, false, true},
+	}
+
+	for _, test := range tests {
+		options := archivingOptions{Policy: test.policy}
+
+		pull, err := options.CheckPolicy(cachePolicyPull)
+		assert.Equal(t, test.pull, pull, "pull for %q", test.policy)
+		assert.Equal(t, test.hasError, err != nil, "error for %q", test.policy)
+
+		push, err := options.CheckPolicy(cachePolicyPush)
+		assert.Equal(t, test.push, push, "push for %q", test.policy)
+		assert.Equal(t, test.hasError, err != nil, "error for %q", test.policy)
+	}
+}
+
+func TestDecodeSingleCache(t *testing.T) {
+	options := common.BuildOptions{
+		"cache": map[string]interface{}{
+			"key":    "single",
+			"paths":  []interface{}{"vendor/"},
+			"policy": "pull",
+		},
+	}
+
+	var shellOptions shellOptions
+	require.NoError(t, options.Decode(&shellOptions))
+	require.Equal(t, 1, len(shellOptions.Cache))
+	assert.Equal(t, "single", shellOptions.Cache[0].Key.Value)
+	assert.Equal(t, cachePolicyPull, shellOptions.Cache[0].Policy)
+}
+
+func TestDecodeMultipleCaches(t *testing.T) {
+	options := common.BuildOptions{
+		"cache": []interface{}{
+			map[string]interface{}{
+				"key":   "node_modules",
+				"paths": []interface{}{"node_modules/"},
+			},
+			map[string]interface{}{
+				"key":    "build",
+				"paths":  []interface{}{"build/"},
+				"policy": "push",
+			},
+		},
+	}
+
+	var shellOptions shellOptions
+	require.NoError(t, options.Decode(&shellOptions))
+	require.Equal(t, 2, len(shellOptions.Cache))
+	assert.Equal(t, "node_modules", shellOptions.Cache[0].Key.Value)
+	assert.Equal(t, "build", shellOptions.Cache[1].Key.Value)
+	assert.Equal(t, cachePolicyPush, shellOptions.Cache[1].Policy)
+}
+
+func TestDecodeCacheKeyFromFiles(t *testing.T) {
+	options := common.BuildOptions{
+		"cache": map[string]interface{}{
+			"key": map[string]interface{}{
+				"files":  []interface{}{"Gemfile.lock"},
+				"prefix": "rspec",
+			},
+			"paths": []interface{}{"vendor/"},
+		},
+	}
+
+	var shellOptions shellOptions
+	require.NoError(t, options.Decode(&shellOptions))
+	require.Equal(t, 1, len(shellOptions.Cache))
+	assert.True(t, shellOptions.Cache[0].Key.IsComputed())
+	assert.Equal(t, []string{"Gemfile.lock"}, shellOptions.Cache[0].Key.Files)
+	assert.Equal(t, "rspec", shellOptions.Cache[0].Key.Prefix)
+}
+
+func TestDecodeCacheKeyWithoutFiles(t *testing.T) {
+	keys := []map[string]interface{}{
+		{"files": []interface{}{}, "prefix": "rspec"},
+		{"prefix": "rspec"},
+	}
+
+	for _, key := range keys {
+		options := common.BuildOptions{
+			"cache": map[string]interface{}{
+				"key":   key,
+				"paths": []interface{}{"vendor/"},
+			},
+		}
+
+		var shellOptions shellOptions
+		assert.Error(t, options.Decode(&shellOptions), "for %v", key)
+	}
+}
+
+func TestArchivingOptionsExclude(t *testing.T) {
+	options := archivingOptions{
+		Paths:   []string{"build/"},
+		Exclude: []string{"build/**/*.o"},
+	}
+	assert.Equal(t, []string{"--path", "build/", "--exclude", "build/**/*.o"}, options.CommandArguments())
+
+	options.Paths = nil
+	assert.Empty(t, options.CommandArguments(), "excludes alone should not create the archive")
+}
+
+func TestDecodeDependenciesWithPaths(t *testing.T) {
+	options := common.BuildOptions{
+		"dependencies": []interface{}{
+			"build",
+			map[string]interface{}{
+				"name":  "docs",
+				"paths": []interface{}{"public/**"},
+			},
+		},
+	}
+
+	var shellOptions shellOptions
+	require.NoError(t, options.Decode(&shellOptions))
+	require.NotNil(t, shellOptions.Dependencies)
+	assert.True(t, shellOptions.Dependencies.IsDependent("build"))
+	assert.True(t, shellOptions.Dependencies.IsDependent("docs"))
+	assert.False(t, shellOptions.Dependencies.IsDependent("test"))
+	assert.Empty(t, shellOptions.Dependencies.Paths("build"))
+	assert.Equal(t, []string{"public/**"}, shellOptions.Dependencies.Paths("docs"))
+}
+
+func TestDecodeArtifactsJUnitReports(t *testing.T) {
+	options := common.BuildOptions{
+		"artifacts": map[string]interface{}{
+			"paths": []interface{}{"coverage/"},
+			"reports": map[string]interface{}{
+				"junit": "rspec.xml",
+			},
+		},
+	}
+
+	var shellOptions shellOptions
+	require.NoError(t, options.Decode(&shellOptions))
+	require.NotNil(t, shellOptions.Artifacts)
+	assert.Equal(t, reportPaths{"rspec.xml"}, shellOptions.Artifacts.Reports.JUnit)
+	assert.Equal(t, []string{"--path", "coverage/", "--path", "rspec.xml"}, shellOptions.Artifacts.CommandArguments())
+}
@@ -15,6 +15,7 @@ type ShellWriter interface {
 	EndIf()
 
 	Cd(path string)
+	MkDir(path string)
 	RmDir(path string)
 	RmFile(path string)
 	Absolute(path string) string
@@ -25,3 +26,32 @@ type ShellWriter interface {
 	Error(fmt string, arguments ...interface{})
 	EmptyLine()
 }
+
+// multiLineCommandWriter is implemented by the writers of shells which see only the exit code
+// of the last line of a multi-line command, the writer checks then every line of the command
+type multiLineCommandWriter interface {
+	MultiLineCommand(command string)
+}
+
+// temporaryFiles keeps the files of the file variables written by the script, the same content
+// is written only once and the internal secrets are removed at the end of the script
+type temporaryFiles struct {
+	byValue map[string]string
+	secrets []string
+}
+
+func (f *temporaryFiles) find(value string) (file string, written bool) {
+	file, written = f.byValue[value]
+	return
+}
+
+func (f *temporaryFiles) add(variable common.BuildVariable, file string) {
+	if f.byValue == nil {
+		f.byValue = make(map[string]string)
+	}
+	f.byValue[variable.Value] = file
+
+	if variable.Internal && !variable.Public {
+		f.secrets = append(f.secrets, file)
+	}
+}